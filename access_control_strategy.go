@@ -15,107 +15,343 @@
 package nogo
 
 import (
-	"errors"
-	"fmt"
+	"context"
+	"time"
 )
 
 // This strategy encapsulates all logic surrounding access control checks. For RBAC and ACL checks, clients will generally interface with methods defined on this interface.
 type AccessControlStrategy interface {
-	// Handles all RBAC checks ensuring a principal is authorized to perform a system capability represented by the permission. Returns an error if the principal does not have access.
+	// Handles all RBAC checks ensuring a principal is authorized to perform a system capability represented by the permission. Returns an *AccessDeniedError if the principal does not have access, or a *RepositoryError if access could not be verified.
 	VerifyRoleAccess(principal Principal, permission Permission) error
-	// Handles all ACL checks ensuring a principal is authorized the specific mode of access for a resource.
+	// Handles all ACL checks ensuring a principal is authorized the specific mode of access for a resource. Returns an *AccessDeniedError if the principal does not have access, or a *RepositoryError if access could not be verified.
 	VerifyResourceAccess(principal Principal, permission Permission, secure SecureResource) error
-	// Loads the resource for the id and handles all ACL checks ensuring a principal is authorized the specific mode of access for the resource.
+	// Loads the resource for the id and handles all ACL checks ensuring a principal is authorized the specific mode of access for the resource. In addition to the errors returned by VerifyResourceAccess, returns an error matching ErrResourceNotFound if the repository could not find the resource.
 	VerifyResourceAccessById(principal Principal, permission Permission, resourceId string) error
+	// Performs the same check as VerifyRoleAccess, returning a decision that explains the outcome. Returns an error only if the check could not be performed.
+	ExplainRoleAccess(principal Principal, permission Permission) (*Decision, error)
+	// Performs the same check as VerifyResourceAccess, returning a decision that explains the outcome. Returns an error only if the check could not be performed.
+	ExplainResourceAccess(principal Principal, permission Permission, secure SecureResource) (*Decision, error)
+	// Performs the same check as VerifyResourceAccessById, returning a decision that explains the outcome. Returns an error only if the check could not be performed.
+	ExplainResourceAccessById(principal Principal, permission Permission, resourceId string) (*Decision, error)
+	// Performs the same checks as VerifyResourceAccess for each resource, returning, in order, the resources the principal is authorized the mode of access to. Returns a *RepositoryError if access could not be verified.
+	FilterAuthorized(principal Principal, permission Permission, resources []SecureResource) ([]SecureResource, error)
 }
 
-// Returns the default access control strategy implementation. If allowAdmin is true, all checks are bypassed for principals that have an admin role.
-func NewAccessControlStrategy(resourceRepo SecureResourceRepository, roleRepo RoleRepository, allowAdmin bool) AccessControlStrategy {
-	return &defaultAccessControlStrategy{resourceRepository: resourceRepo, roleRepository: roleRepo, allowFullAdminAccess: allowAdmin}
+// An option that customizes the default access control strategy.
+type StrategyOption func(*defaultAccessControlStrategy)
+
+// Resolves a principal's roles by sid through the membership repository. If includePrincipalRoles is true, the roles returned by the principal's GetRoleNames are also honored, otherwise they are ignored.
+func WithRoleMembership(membershipRepo RoleMembershipRepository, includePrincipalRoles bool) StrategyOption {
+	return func(strategy *defaultAccessControlStrategy) {
+		strategy.membershipRepository = AdaptRoleMembershipRepository(membershipRepo)
+		strategy.includePrincipalRoles = includePrincipalRoles
+	}
+}
+
+// Evaluates time bound ACL entries and role memberships against the clock instead of the current time.
+func WithClock(now func() time.Time) StrategyOption {
+	return func(strategy *defaultAccessControlStrategy) {
+		strategy.now = now
+	}
+}
+
+// Returns the default access control strategy implementation. If allowAdmin is true, all checks are bypassed for principals that have an admin role. The returned strategy also implements ContextAccessControlStrategy.
+func NewAccessControlStrategy(resourceRepo SecureResourceRepository, roleRepo RoleRepository, allowAdmin bool, options ...StrategyOption) AccessControlStrategy {
+	strategy := &defaultAccessControlStrategy{resourceRepository: AdaptSecureResourceRepository(resourceRepo), roleRepository: AdaptRoleRepository(roleRepo), allowFullAdminAccess: allowAdmin, now: time.Now}
+	strategy.aclEvaluator, _ = resourceRepo.(ACLEvaluator)
+	for _, option := range options {
+		option(strategy)
+	}
+	return strategy
 }
 
 type defaultAccessControlStrategy struct {
-	resourceRepository   SecureResourceRepository
-	roleRepository       RoleRepository
-	allowFullAdminAccess bool
+	resourceRepository    ContextSecureResourceRepository
+	aclEvaluator          ACLEvaluator
+	groupResolver         GroupResolver
+	now                   func() time.Time
+	roleRepository        ContextRoleRepository
+	membershipRepository  ContextRoleMembershipRepository
+	includePrincipalRoles bool
+	allowFullAdminAccess  bool
 }
 
 func (this *defaultAccessControlStrategy) VerifyRoleAccess(principal Principal, permission Permission) error {
-	roles, err := this.findRoles(principal.GetRoleNames()...)
+	return this.VerifyRoleAccessContext(context.Background(), principal, permission)
+}
+
+func (this *defaultAccessControlStrategy) VerifyResourceAccess(principal Principal, permission Permission, resource SecureResource) error {
+	return this.VerifyResourceAccessContext(context.Background(), principal, permission, resource)
+}
+
+func (this *defaultAccessControlStrategy) VerifyResourceAccessById(principal Principal, permission Permission, resourceId string) error {
+	return this.VerifyResourceAccessByIdContext(context.Background(), principal, permission, resourceId)
+}
+
+func (this *defaultAccessControlStrategy) ExplainRoleAccess(principal Principal, permission Permission) (*Decision, error) {
+	return this.ExplainRoleAccessContext(context.Background(), principal, permission)
+}
+
+func (this *defaultAccessControlStrategy) ExplainResourceAccess(principal Principal, permission Permission, resource SecureResource) (*Decision, error) {
+	return this.ExplainResourceAccessContext(context.Background(), principal, permission, resource)
+}
+
+func (this *defaultAccessControlStrategy) ExplainResourceAccessById(principal Principal, permission Permission, resourceId string) (*Decision, error) {
+	return this.ExplainResourceAccessByIdContext(context.Background(), principal, permission, resourceId)
+}
+
+func (this *defaultAccessControlStrategy) FilterAuthorized(principal Principal, permission Permission, resources []SecureResource) ([]SecureResource, error) {
+	return this.FilterAuthorizedContext(context.Background(), principal, permission, resources)
+}
+
+func (this *defaultAccessControlStrategy) VerifyRoleAccessContext(ctx context.Context, principal Principal, permission Permission) error {
+	decision, err := this.ExplainRoleAccessContext(ctx, principal, permission)
+	return verifyDecision(ctx, decision, err)
+}
+
+func (this *defaultAccessControlStrategy) VerifyResourceAccessContext(ctx context.Context, principal Principal, permission Permission, resource SecureResource) error {
+	decision, err := this.ExplainResourceAccessContext(ctx, principal, permission, resource)
+	return verifyDecision(ctx, decision, err)
+}
+
+func (this *defaultAccessControlStrategy) VerifyResourceAccessByIdContext(ctx context.Context, principal Principal, permission Permission, resourceId string) error {
+	decision, err := this.ExplainResourceAccessByIdContext(ctx, principal, permission, resourceId)
+	return verifyDecision(ctx, decision, err)
+}
+
+// reports the decision to the context's observers and returns the error the Verify check results in.
+func verifyDecision(ctx context.Context, decision *Decision, err error) error {
 	if err != nil {
-		return errors.New("Could not verify role access.")
+		return err
+	}
+	observeDecision(ctx, decision)
+	if !decision.Granted {
+		return &AccessDeniedError{PrincipalId: decision.PrincipalId, Permission: decision.Permission, ResourceId: decision.ResourceId}
+	}
+	return nil
+}
+
+func (this *defaultAccessControlStrategy) ExplainRoleAccessContext(ctx context.Context, principal Principal, permission Permission) (*Decision, error) {
+	decision := &Decision{PrincipalId: principal.GetId(), Permission: permission, RolesConsulted: make([]string, 0)}
+	roles, err := this.findRoles(ctx, principal)
+	if err != nil {
+		return nil, wrapRepositoryError(err)
 	}
 	for _, role := range roles {
+		decision.RolesConsulted = append(decision.RolesConsulted, role.GetName())
 		if this.allowFullAdminAccess && role.IsAdmin() {
-			return nil
+			decision.Granted, decision.Rule, decision.RoleName = true, AdminRule, role.GetName()
+			return decision, nil
 		}
 		auth, err := role.HasPermission(permission)
 		if err != nil {
-			return errors.New(fmt.Sprintf("Principal %v does not have access", principal.GetId()))
+			return nil, wrapRepositoryError(err)
 		}
 		if auth {
-			return nil
+			decision.Granted, decision.Rule, decision.RoleName = true, RoleRule, role.GetName()
+			return decision, nil
 		}
 	}
-	return errors.New(fmt.Sprintf("Principal %v does not have access", principal.GetId()))
+	return decision, nil
 }
 
-func (this *defaultAccessControlStrategy) VerifyResourceAccess(principal Principal, permission Permission, resource SecureResource) error {
-	owner := resource.GetOwnerSid()
-	if (owner != "" && owner == principal.GetSid()) || (this.allowFullAdminAccess && this.isAdmin(principal)) {
-		return nil
+func (this *defaultAccessControlStrategy) ExplainResourceAccessContext(ctx context.Context, principal Principal, permission Permission, resource SecureResource) (*Decision, error) {
+	decision := newResourceDecision(principal, permission, resource.GetNativeId())
+	if decided, err := this.explainOwnerOrAdmin(ctx, principal, resource.GetOwnerSid(), decision); err != nil {
+		return nil, err
+	} else if decided {
+		return decision, nil
 	}
-	if isAuth, err := hasPermission(principal.GetSid(), permission, resource); isAuth && err == nil {
-		return nil
-	} else if err != nil {
-		return err
+	groupSids, err := this.findGroupSids(ctx, principal)
+	if err != nil {
+		return nil, wrapRepositoryError(err)
+	}
+	result, sid, decidingResource, err := evaluateInheritedACL(ctx, entrySids(principal.GetSid(), groupSids), permission, this.now(), resource, &decision.ResourcesConsulted)
+	if err != nil {
+		return nil, wrapRepositoryError(err)
+	}
+	if result != aceNotApplicable {
+		decision.applyACE(result == aceDenied, sid, principal.GetSid(), decidingResource.GetNativeId())
 	}
-	// cache the id for proper error reporting if access does not resolve.
-	resourceId := resource.GetNativeId()
-	parent := resource.GetParentResource()
-	for resource.InheritsParentACL() && parent != nil {
-		if isAuth, err := hasPermission(principal.GetSid(), permission, parent); isAuth && err == nil {
-			return nil
-		} else if err != nil {
-			return err
+	return decision, nil
+}
+
+func (this *defaultAccessControlStrategy) ExplainResourceAccessByIdContext(ctx context.Context, principal Principal, permission Permission, resourceId string) (*Decision, error) {
+	if this.aclEvaluator != nil {
+		return this.explainEvaluatedAccess(ctx, principal, permission, resourceId)
+	}
+	resource, err := this.resourceRepository.FindResourceContext(ctx, resourceId)
+	if err != nil {
+		return nil, wrapRepositoryError(err)
+	}
+	return this.ExplainResourceAccessContext(ctx, principal, permission, resource)
+}
+
+// explains resource access using a repository that evaluates the resource's ACL and inherited ACLs itself.
+func (this *defaultAccessControlStrategy) explainEvaluatedAccess(ctx context.Context, principal Principal, permission Permission, resourceId string) (*Decision, error) {
+	groupSids, err := this.findGroupSids(ctx, principal)
+	if err != nil {
+		return nil, wrapRepositoryError(err)
+	}
+	evaluation, err := this.aclEvaluator.EvaluateACL(ctx, principal.GetSid(), groupSids, permission, resourceId, this.now())
+	if err != nil {
+		return nil, wrapRepositoryError(err)
+	}
+	decision := newResourceDecision(principal, permission, resourceId)
+	if decided, err := this.explainOwnerOrAdmin(ctx, principal, evaluation.OwnerSid, decision); err != nil {
+		return nil, err
+	} else if decided {
+		return decision, nil
+	}
+	decision.ResourcesConsulted = append(decision.ResourcesConsulted, evaluation.ResourcesConsulted...)
+	if evaluation.Matched {
+		decision.applyACE(evaluation.Deny, evaluation.Sid, principal.GetSid(), evaluation.DecidingResourceId)
+	}
+	return decision, nil
+}
+
+func (this *defaultAccessControlStrategy) FilterAuthorizedContext(ctx context.Context, principal Principal, permission Permission, resources []SecureResource) ([]SecureResource, error) {
+	authorized := make([]SecureResource, 0, len(resources))
+	if len(resources) == 0 {
+		return authorized, nil
+	}
+	// the admin check is made once for the whole list
+	if this.allowFullAdminAccess {
+		adminRole, err := this.findAdminRole(ctx, principal, &Decision{})
+		if err != nil {
+			return nil, wrapRepositoryError(err)
 		}
-		resource = parent
-		parent = resource.GetParentResource()
+		if adminRole != nil {
+			return append(authorized, resources...), nil
+		}
+	}
+	groupSids, err := this.findGroupSids(ctx, principal)
+	if err != nil {
+		return nil, wrapRepositoryError(err)
 	}
-	return errors.New(fmt.Sprintf("Principal %v does not have access to the resource %v.", principal.GetId(), resourceId))
+	sid := principal.GetSid()
+	sids := entrySids(sid, groupSids)
+	at := this.now()
+	evaluated := make(map[string]aceDecision)
+	for _, resource := range resources {
+		if err := ctx.Err(); err != nil {
+			return nil, wrapRepositoryError(err)
+		}
+		if owner := resource.GetOwnerSid(); owner != "" && owner == sid {
+			authorized = append(authorized, resource)
+			continue
+		}
+		result, err := evaluateInheritedACLOnce(sids, permission, at, resource, evaluated)
+		if err != nil {
+			return nil, wrapRepositoryError(err)
+		}
+		if result == aceAllowed {
+			authorized = append(authorized, resource)
+		}
+	}
+	return authorized, nil
 }
 
-func (this *defaultAccessControlStrategy) VerifyResourceAccessById(principal Principal, permission Permission, resourceId string) error {
-	resource, err := this.resourceRepository.FindResource(resourceId)
+// grants access on the decision if the principal owns the resource or is an admin. Returns true if access was granted.
+func (this *defaultAccessControlStrategy) explainOwnerOrAdmin(ctx context.Context, principal Principal, ownerSid string, decision *Decision) (bool, error) {
+	if ownerSid != "" && ownerSid == principal.GetSid() {
+		decision.Granted, decision.Rule = true, OwnerRule
+		return true, nil
+	}
+	if this.allowFullAdminAccess {
+		adminRole, err := this.findAdminRole(ctx, principal, decision)
+		if err != nil {
+			return false, wrapRepositoryError(err)
+		}
+		if adminRole != nil {
+			decision.Granted, decision.Rule, decision.RoleName = true, AdminRule, adminRole.GetName()
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func newResourceDecision(principal Principal, permission Permission, resourceId string) *Decision {
+	return &Decision{PrincipalId: principal.GetId(), Permission: permission, ResourceId: resourceId, RolesConsulted: make([]string, 0), ResourcesConsulted: make([]string, 0)}
+}
+
+// returns the first admin role assigned to the principal, or nil if the principal is not an admin. Consulted roles are recorded on the decision.
+func (this *defaultAccessControlStrategy) findAdminRole(ctx context.Context, principal Principal, decision *Decision) (Role, error) {
+	roles, err := this.findRoles(ctx, principal)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return this.VerifyResourceAccess(principal, permission, resource)
+	for _, role := range roles {
+		decision.RolesConsulted = append(decision.RolesConsulted, role.GetName())
+		if role.IsAdmin() {
+			return role, nil
+		}
+	}
+	return nil, nil
 }
 
-func (this *defaultAccessControlStrategy) isAdmin(principal Principal) bool {
-	roles, err := this.findRoles(principal.GetRoleNames()...)
-	if err == nil {
-		for _, role := range roles {
-			if role.IsAdmin() {
-				return true
+// returns the distinct role names assigned to the principal, either directly or through the membership repository.
+func (this *defaultAccessControlStrategy) findRoleNames(ctx context.Context, principal Principal) ([]string, error) {
+	if this.membershipRepository == nil {
+		return principal.GetRoleNames(), nil
+	}
+	var roleNames []string
+	var err error
+	if timeBound, ok := this.membershipRepository.(ContextTimeBoundRoleMembershipRepository); ok {
+		roleNames, err = timeBound.FindRolesForSidAtContext(ctx, principal.GetSid(), this.now())
+	} else if timeBound, ok := this.membershipRepository.(TimeBoundRoleMembershipRepository); ok {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		roleNames, err = timeBound.FindRolesForSidAt(principal.GetSid(), this.now())
+	} else {
+		roleNames, err = this.membershipRepository.FindRolesForSidContext(ctx, principal.GetSid())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if this.includePrincipalRoles {
+		for _, roleName := range principal.GetRoleNames() {
+			found := false
+			for _, existing := range roleNames {
+				if existing == roleName {
+					found = true
+					break
+				}
+			}
+			if !found {
+				roleNames = append(roleNames, roleName)
 			}
 		}
 	}
-	return false
+	return roleNames, nil
 }
 
-func (this *defaultAccessControlStrategy) findRoles(roleNames ...string) ([]Role, error) {
-	roles, err := this.roleRepository.FindAll()
+func (this *defaultAccessControlStrategy) findRoles(ctx context.Context, principal Principal) ([]Role, error) {
+	roleNames, err := this.findRoleNames(ctx, principal)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := this.roleRepository.FindAllContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 	returnRoles := make([]Role, 0)
-	// while n^2 complexity, the number of roles in a system will be relatively small
-	for _, roleName := range roleNames {
+	visited := make(map[string]bool)
+	// while n^2 complexity, the number of roles in a system will be relatively small. Assigned roles are
+	// followed by the roles they inherit from, breadth first, each role appearing at most once.
+	pending := append([]string{}, roleNames...)
+	for len(pending) > 0 {
+		roleName := pending[0]
+		pending = pending[1:]
+		if visited[roleName] {
+			continue
+		}
+		visited[roleName] = true
 		for _, role := range roles {
 			if roleName == role.GetName() {
 				returnRoles = append(returnRoles, role)
+				pending = append(pending, parentRoleNames(role)...)
 				break
 			}
 		}
@@ -123,34 +359,106 @@ func (this *defaultAccessControlStrategy) findRoles(roleNames ...string) ([]Role
 	return returnRoles, nil
 }
 
-func hasPermission(sid string, permission Permission, resource SecureResource) (bool, error) {
-	acl, err := resource.GetACL()
-	if err != nil {
-		return false, err
-	}
-	ace, err := acl.GetACEForSid(sid)
-	if err != nil {
-		return false, err
+// Evaluates the ACL of the resource and of the ancestors it inherits from for the sids whose entries apply to a principal. The nearest resource with an applicable entry decides, returning the outcome, the sid of the deciding entry and the deciding resource. The native ids of the evaluated resources are appended to consulted.
+func evaluateInheritedACL(ctx context.Context, sids []string, permission Permission, at time.Time, resource SecureResource, consulted *[]string) (aceDecision, string, SecureResource, error) {
+	for resource != nil {
+		if err := ctx.Err(); err != nil {
+			return aceNotApplicable, "", nil, err
+		}
+		*consulted = append(*consulted, resource.GetNativeId())
+		result, entrySid, err := evaluateACL(sids, permission, at, resource)
+		if err != nil {
+			return aceNotApplicable, "", nil, err
+		}
+		if result != aceNotApplicable {
+			return result, entrySid, resource, nil
+		}
+		if !resource.InheritsParentACL() {
+			break
+		}
+		resource = resource.GetParentResource()
 	}
-	if ace != nil {
-		if isAuth, err := ace.HasPermission(permission); isAuth && err == nil {
-			return true, nil
-		} else if err != nil {
-			return false, err
+	return aceNotApplicable, "", nil, nil
+}
+
+// Evaluates inherited ACLs as evaluateInheritedACL does, recording the outcome from each resource walked by native id so that ancestors shared between resources are evaluated once.
+func evaluateInheritedACLOnce(sids []string, permission Permission, at time.Time, resource SecureResource, evaluated map[string]aceDecision) (aceDecision, error) {
+	walked := make([]string, 0)
+	result := aceNotApplicable
+	for resource != nil {
+		if previous, ok := evaluated[resource.GetNativeId()]; ok {
+			result = previous
+			break
+		}
+		walked = append(walked, resource.GetNativeId())
+		current, _, err := evaluateACL(sids, permission, at, resource)
+		if err != nil {
+			return aceNotApplicable, err
 		}
+		if current != aceNotApplicable {
+			result = current
+			break
+		}
+		if !resource.InheritsParentACL() {
+			break
+		}
+		resource = resource.GetParentResource()
 	}
-	// test world sid
-	ace, err = acl.GetACEForSid(WorldSid)
+	for _, nativeId := range walked {
+		evaluated[nativeId] = result
+	}
+	return result, nil
+}
+
+// the outcome of evaluating a single resource's ACL
+type aceDecision int
+
+const (
+	aceNotApplicable aceDecision = iota
+	aceAllowed
+	aceDenied
+)
+
+// Evaluates the ACL of a single resource for the sids, in order of precedence, returning the sid of the matching entry. Deny entries take precedence over allow entries, and entries that do not apply at the given time are ignored. A deny entry returned by GetACEForSid, as an ACL that does not implement DenyACL may do, denies rather than grants.
+func evaluateACL(sids []string, permission Permission, at time.Time, resource SecureResource) (aceDecision, string, error) {
+	acl, err := resource.GetACL()
 	if err != nil {
-		return false, err
+		return aceNotApplicable, "", err
 	}
-	if ace != nil {
-		if isAuth, err := ace.HasPermission(permission); isAuth && err == nil {
-			return true, nil
-		} else if err != nil {
-			return false, err
+	aces := make([]ACE, len(sids))
+	for i, entrySid := range sids {
+		if aces[i], err = acl.GetACEForSid(entrySid); err != nil {
+			return aceNotApplicable, "", err
+		}
+		ace, err := denyACEForSid(acl, entrySid)
+		if err != nil {
+			return aceNotApplicable, "", err
+		}
+		if ace == nil && isDenyACE(aces[i]) {
+			ace = aces[i]
+		}
+		if isMatch, err := aceHasPermission(ace, permission, at); err != nil {
+			return aceNotApplicable, "", err
+		} else if isMatch {
+			return aceDenied, entrySid, nil
+		}
+	}
+	for i, entrySid := range sids {
+		if isDenyACE(aces[i]) {
+			continue
+		}
+		if isMatch, err := aceHasPermission(aces[i], permission, at); err != nil {
+			return aceNotApplicable, "", err
+		} else if isMatch {
+			return aceAllowed, entrySid, nil
 		}
 	}
+	return aceNotApplicable, "", nil
+}
 
-	return false, nil
+func aceHasPermission(ace ACE, permission Permission, at time.Time) (bool, error) {
+	if ace == nil || !isACEActive(ace, at) {
+		return false, nil
+	}
+	return ace.HasPermission(permission)
 }
@@ -15,7 +15,11 @@
 package nogo
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -173,6 +177,78 @@ func TestVerifyInheritedWorldSid(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestVerifyDenyOverridesAllow(t *testing.T) {
+	// given
+	read := Permission(1)
+	p := &mockPrincipal{sid: "contractor", roleNames: []string{}}
+	other := &mockPrincipal{sid: "employee", roleNames: []string{}}
+	acl := NewACL()
+	acl.AddACE(NewACE(WorldSid, read))
+	acl.AddACE(NewDenyACE("contractor", read))
+	resource := &mockResource{nativeId: "folder", acl: acl}
+	aclService := NewAccessControlStrategy(nil, nil, false)
+
+	// when
+	err := aclService.VerifyResourceAccess(p, read, resource)
+
+	// then
+	assert.NotNil(t, err)
+	err = aclService.VerifyResourceAccess(other, read, resource)
+	assert.Nil(t, err)
+}
+
+func TestVerifyDenyWorldSid(t *testing.T) {
+	// given
+	read := Permission(1)
+	p := &mockPrincipal{sid: "id", roleNames: []string{}}
+	acl := NewACL()
+	acl.AddACE(NewACE("id", read))
+	acl.AddACE(NewDenyACE(WorldSid, read))
+	resource := &mockResource{nativeId: "folder", acl: acl, owner: "owner"}
+	aclService := NewAccessControlStrategy(nil, nil, false)
+
+	// when
+	err := aclService.VerifyResourceAccess(p, read, resource)
+
+	// then
+	assert.NotNil(t, err)
+	// owners are not subject to deny entries
+	err = aclService.VerifyResourceAccess(&mockPrincipal{sid: "owner"}, read, resource)
+	assert.Nil(t, err)
+}
+
+func TestVerifyInheritedDenyNearestAncestorWins(t *testing.T) {
+	// given
+	read := Permission(1)
+	p := &mockPrincipal{sid: "id", roleNames: []string{}}
+	rootACL := NewACL()
+	rootACL.AddACE(NewACE(WorldSid, read))
+	root := &mockResource{nativeId: "root", acl: rootACL}
+	folderACL := NewACL()
+	folderACL.AddACE(NewDenyACE("id", read))
+	folder := &mockResource{nativeId: "folder", acl: folderACL, parent: root, inheritACL: true}
+	document := &mockResource{nativeId: "document", acl: NewACL(), parent: folder, inheritACL: true}
+	aclService := NewAccessControlStrategy(nil, nil, false)
+
+	// when
+	// the folder deny is nearer than the root allow
+	err := aclService.VerifyResourceAccess(p, read, document)
+
+	// then
+	assert.NotNil(t, err)
+
+	// an allow on the document is nearer than the folder deny
+	documentACL, _ := document.GetACL()
+	documentACL.AddACE(NewACE("id", read))
+	err = aclService.VerifyResourceAccess(p, read, document)
+	assert.Nil(t, err)
+
+	// a deny on the document overrides the allow on the document
+	documentACL.AddACE(NewDenyACE(WorldSid, read))
+	err = aclService.VerifyResourceAccess(p, read, document)
+	assert.NotNil(t, err)
+}
+
 func TestVerifyAdminResourceAccess(t *testing.T) {
 	// given
 	create := Permission(1)
@@ -195,6 +271,430 @@ func TestVerifyAdminResourceAccess(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestVerifyResourceAccessById(t *testing.T) {
+	// given
+	create := Permission(1)
+	update := Permission(2)
+	p := &mockPrincipal{sid: "id", roleNames: []string{}}
+	resourceRepo := NewMapBackedSecureResourceRepository()
+	parent := NewSecureResource("parentId", "owner", nil, false)
+	parentACL, _ := parent.GetACL()
+	parentACL.AddACE(NewACE("id", update))
+	resourceRepo.CreateResource(parent)
+	resourceRepo.CreateResource(NewSecureResource("id", "owner", parent, true))
+	aclService := NewAccessControlStrategy(resourceRepo, nil, false)
+
+	// when
+	err := aclService.VerifyResourceAccessById(p, update, "id")
+
+	// then
+	assert.Nil(t, err)
+
+	err = aclService.VerifyResourceAccessById(p, create, "id")
+	assert.NotNil(t, err)
+
+	err = aclService.VerifyResourceAccessById(p, update, "missingId")
+	assert.NotNil(t, err)
+}
+
+func TestVerifyRoleAccessWithMembership(t *testing.T) {
+	// given
+	create := Permission(1)
+	update := Permission(2)
+	mockRoleRepo := new(mockRoleRepository)
+	mockRoleRepo.On("FindAll").Return([]Role{NewRole("createRole", create), NewRole("updateRole", update)}, nil)
+	membershipRepo := NewMapBackedRoleMembershipRepository()
+	membershipRepo.AddMember("createRole", "sid")
+	p := &mockPrincipal{sid: "sid", roleNames: []string{"updateRole"}}
+
+	// when
+	// verify membership only
+	aclService := NewAccessControlStrategy(nil, mockRoleRepo, true, WithRoleMembership(membershipRepo, false))
+	err := aclService.VerifyRoleAccess(p, create)
+	assert.Nil(t, err)
+	err = aclService.VerifyRoleAccess(p, update)
+	assert.NotNil(t, err)
+
+	// then
+	// verify membership in addition to principal roles
+	aclService = NewAccessControlStrategy(nil, mockRoleRepo, true, WithRoleMembership(membershipRepo, true))
+	err = aclService.VerifyRoleAccess(p, create)
+	assert.Nil(t, err)
+	err = aclService.VerifyRoleAccess(p, update)
+	assert.Nil(t, err)
+}
+
+func TestExplainRoleAccess(t *testing.T) {
+	// given
+	create := Permission(1)
+	update := Permission(2)
+	mockRoleRepo := new(mockRoleRepository)
+	mockRoleRepo.On("FindAll").Return([]Role{NewRole("createRole", create), NewRole("updateRole", update), NewAdminRole("adminRole", EmptyPermissionMask)}, nil)
+	p := &mockPrincipal{id: "bob", roleNames: []string{"createRole", "updateRole"}}
+	aclService := NewAccessControlStrategy(nil, mockRoleRepo, true)
+
+	// when
+	decision, err := aclService.ExplainRoleAccess(p, update)
+
+	// then
+	assert.Nil(t, err)
+	assert.True(t, decision.Granted)
+	assert.Equal(t, RoleRule, decision.Rule)
+	assert.Equal(t, "updateRole", decision.RoleName)
+	assert.Equal(t, []string{"createRole", "updateRole"}, decision.RolesConsulted)
+
+	decision, err = aclService.ExplainRoleAccess(p, Permission(4))
+	assert.Nil(t, err)
+	assert.False(t, decision.Granted)
+	assert.Equal(t, NoMatchingRule, decision.Rule)
+	assert.Equal(t, "bob", decision.PrincipalId)
+
+	decision, err = aclService.ExplainRoleAccess(&mockPrincipal{roleNames: []string{"adminRole"}}, update)
+	assert.Nil(t, err)
+	assert.True(t, decision.Granted)
+	assert.Equal(t, AdminRule, decision.Rule)
+	assert.Equal(t, "adminRole", decision.RoleName)
+}
+
+func TestVerifyReportsDecisionToObservers(t *testing.T) {
+	// given
+	read := Permission(1)
+	roleRepo := NewMapBackedRoleRepository()
+	roleRepo.CreateRole(NewRole("reader", read))
+	aclService := NewAccessControlStrategy(nil, roleRepo, false).(ContextAccessControlStrategy)
+	observed := make([]string, 0)
+	ctx := WithDecisionObserver(context.Background(), func(decision *Decision) {
+		observed = append(observed, "outer "+decision.Rule.String())
+	})
+	ctx = WithDecisionObserver(ctx, func(decision *Decision) {
+		observed = append(observed, "inner "+decision.Rule.String())
+	})
+
+	// when
+	err := aclService.VerifyRoleAccessContext(ctx, &mockPrincipal{id: "bob", sid: "id", roleNames: []string{"reader"}}, read)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"inner role", "outer role"}, observed)
+}
+
+func TestExplainResourceAccess(t *testing.T) {
+	// given
+	read := Permission(1)
+	update := Permission(2)
+	p := &mockPrincipal{id: "bob", sid: "id", roleNames: []string{}}
+	rootACL := NewACL()
+	rootACL.AddACE(NewACE(WorldSid, read))
+	root := &mockResource{nativeId: "root", acl: rootACL}
+	folderACL := NewACL()
+	folderACL.AddACE(NewDenyACE("id", update))
+	folder := &mockResource{nativeId: "folder", acl: folderACL, parent: root, inheritACL: true}
+	document := &mockResource{nativeId: "document", acl: NewACL(), parent: folder, inheritACL: true, owner: "owner"}
+	aclService := NewAccessControlStrategy(nil, nil, false)
+
+	// when
+	decision, err := aclService.ExplainResourceAccess(p, read, document)
+
+	// then
+	assert.Nil(t, err)
+	assert.True(t, decision.Granted)
+	assert.Equal(t, WorldSidACERule, decision.Rule)
+	assert.Equal(t, WorldSid, decision.Sid)
+	assert.Equal(t, "document", decision.ResourceId)
+	assert.Equal(t, "root", decision.DecidingResourceId)
+	assert.True(t, decision.IsInherited())
+	assert.Equal(t, []string{"document", "folder", "root"}, decision.ResourcesConsulted)
+
+	decision, err = aclService.ExplainResourceAccess(p, update, document)
+	assert.Nil(t, err)
+	assert.False(t, decision.Granted)
+	assert.True(t, decision.Deny)
+	assert.Equal(t, SidACERule, decision.Rule)
+	assert.Equal(t, "folder", decision.DecidingResourceId)
+	assert.Equal(t, []string{"document", "folder"}, decision.ResourcesConsulted)
+
+	decision, err = aclService.ExplainResourceAccess(&mockPrincipal{sid: "owner"}, update, document)
+	assert.Nil(t, err)
+	assert.True(t, decision.Granted)
+	assert.Equal(t, OwnerRule, decision.Rule)
+	assert.Equal(t, 0, len(decision.ResourcesConsulted))
+
+	decision, err = aclService.ExplainResourceAccess(p, Permission(4), document)
+	assert.Nil(t, err)
+	assert.False(t, decision.Granted)
+	assert.Equal(t, NoMatchingRule, decision.Rule)
+	assert.False(t, decision.IsInherited())
+}
+
+func TestExplainResourceAccessByIdUsesACLEvaluator(t *testing.T) {
+	// given
+	read := Permission(1)
+	p := &mockPrincipal{id: "bob", sid: "id", roleNames: []string{"adminRole"}}
+	mockRoleRepo := new(mockRoleRepository)
+	mockRoleRepo.On("FindAll").Return([]Role{NewAdminRole("adminRole", EmptyPermissionMask)}, nil)
+	evaluatingRepo := new(mockACLEvaluatingRepository)
+	evaluatingRepo.On("EvaluateACL", "id", []string{}, read, "document").Return(&ACLEvaluation{
+		OwnerSid:           "owner",
+		Matched:            true,
+		Deny:               true,
+		Sid:                "id",
+		DecidingResourceId: "folder",
+		ResourcesConsulted: []string{"document", "folder"},
+	}, nil)
+	evaluatingRepo.On("EvaluateACL", "id", []string{}, read, "missing").Return((*ACLEvaluation)(nil), fmt.Errorf("missing: %w", ErrResourceNotFound))
+	evaluatingRepo.On("EvaluateACL", "owner", []string{}, read, "document").Return(&ACLEvaluation{OwnerSid: "owner", ResourcesConsulted: []string{"document"}}, nil)
+
+	// when
+	decision, err := NewAccessControlStrategy(evaluatingRepo, nil, false).ExplainResourceAccessById(p, read, "document")
+
+	// then
+	assert.Nil(t, err)
+	assert.False(t, decision.Granted)
+	assert.True(t, decision.Deny)
+	assert.Equal(t, SidACERule, decision.Rule)
+	assert.Equal(t, "folder", decision.DecidingResourceId)
+	assert.Equal(t, []string{"document", "folder"}, decision.ResourcesConsulted)
+	assert.True(t, decision.IsInherited())
+
+	decision, err = NewAccessControlStrategy(evaluatingRepo, mockRoleRepo, true).ExplainResourceAccessById(p, read, "document")
+	assert.Nil(t, err)
+	assert.True(t, decision.Granted)
+	assert.Equal(t, AdminRule, decision.Rule)
+
+	decision, err = NewAccessControlStrategy(evaluatingRepo, nil, false).ExplainResourceAccessById(&mockPrincipal{sid: "owner"}, read, "document")
+	assert.Nil(t, err)
+	assert.True(t, decision.Granted)
+	assert.Equal(t, OwnerRule, decision.Rule)
+
+	err = NewAccessControlStrategy(evaluatingRepo, nil, false).VerifyResourceAccessById(p, read, "missing")
+	assert.True(t, errors.Is(err, ErrResourceNotFound))
+	evaluatingRepo.AssertNotCalled(t, "FindResource", "document")
+}
+
+func TestFilterAuthorized(t *testing.T) {
+	// given
+	read := Permission(1)
+	p := &mockPrincipal{id: "bob", sid: "id", roleNames: []string{"adminRole"}}
+	rootACL := NewACL()
+	rootACL.AddACE(NewACE(WorldSid, read))
+	root := &countingResource{mockResource: mockResource{nativeId: "root", acl: rootACL}}
+	folder := &mockResource{nativeId: "folder", acl: NewACL(), parent: root, inheritACL: true}
+	deniedACL := NewACL()
+	deniedACL.AddACE(NewDenyACE("id", read))
+	resources := []SecureResource{
+		&mockResource{nativeId: "doc1", acl: NewACL(), parent: folder, inheritACL: true},
+		&mockResource{nativeId: "private", acl: NewACL(), parent: root},
+		&mockResource{nativeId: "doc2", acl: NewACL(), parent: &mockResource{nativeId: "folder", acl: NewACL(), parent: root, inheritACL: true}, inheritACL: true},
+		&mockResource{nativeId: "denied", acl: deniedACL, parent: folder, inheritACL: true},
+		&mockResource{nativeId: "owned", acl: NewACL(), owner: "id"},
+	}
+	mockRoleRepo := new(mockRoleRepository)
+	mockRoleRepo.On("FindAll").Return([]Role{NewAdminRole("adminRole", EmptyPermissionMask)}, nil)
+
+	// when
+	authorized, err := NewAccessControlStrategy(nil, nil, false).FilterAuthorized(p, read, resources)
+
+	// then
+	assert.Nil(t, err)
+	ids := make([]string, 0)
+	for _, resource := range authorized {
+		ids = append(ids, resource.GetNativeId())
+	}
+	assert.Equal(t, []string{"doc1", "doc2", "owned"}, ids)
+	assert.Equal(t, 1, root.aclLookups)
+
+	authorized, err = NewAccessControlStrategy(nil, mockRoleRepo, true).FilterAuthorized(p, read, resources)
+	assert.Nil(t, err)
+	assert.Equal(t, resources, authorized)
+	mockRoleRepo.AssertNumberOfCalls(t, "FindAll", 1)
+}
+
+func TestVerifyResourceAccessWithGroupSids(t *testing.T) {
+	// given
+	read := Permission(1)
+	update := Permission(2)
+	p := &mockGroupPrincipal{mockPrincipal: mockPrincipal{id: "bob", sid: "id"}, groupSids: []string{"finance-team", "id", WorldSid}}
+	folderACL := NewACL()
+	folderACL.AddACE(NewACE("finance-team", read|update))
+	folder := &mockResource{nativeId: "folder", acl: folderACL}
+	documentACL := NewACL()
+	documentACL.AddACE(NewACE("id", update))
+	documentACL.AddACE(NewDenyACE("contractors", update))
+	document := &mockResource{nativeId: "document", acl: documentACL, parent: folder, inheritACL: true}
+	resolver := &mockGroupResolver{groupSids: map[string][]string{"id": {"contractors"}}}
+	aclService := NewAccessControlStrategy(nil, nil, false)
+
+	// when
+	decision, err := aclService.ExplainResourceAccess(p, read, document)
+
+	// then
+	assert.Nil(t, err)
+	assert.True(t, decision.Granted)
+	assert.Equal(t, GroupSidACERule, decision.Rule)
+	assert.Equal(t, "finance-team", decision.Sid)
+	assert.Equal(t, "folder", decision.DecidingResourceId)
+	assert.NotNil(t, aclService.VerifyResourceAccess(&mockPrincipal{id: "alice", sid: "alice"}, read, document))
+	assert.Nil(t, aclService.VerifyResourceAccess(p, update, document))
+
+	resolvingService := NewAccessControlStrategy(nil, nil, false, WithGroupResolver(resolver))
+	decision, err = resolvingService.ExplainResourceAccess(p, update, document)
+	assert.Nil(t, err)
+	assert.False(t, decision.Granted)
+	assert.True(t, decision.Deny)
+	assert.Equal(t, "contractors", decision.Sid)
+	authorized, err := resolvingService.FilterAuthorized(p, read, []SecureResource{folder, document})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(authorized))
+
+	resolver.err = errors.New("directory unavailable")
+	err = resolvingService.VerifyResourceAccess(p, read, document)
+	assert.True(t, errors.Is(err, ErrRepository))
+	assert.True(t, errors.Is(err, resolver.err))
+}
+
+func TestVerifyResourceAccessWithGroupRepository(t *testing.T) {
+	// given
+	read := Permission(1)
+	groupRepo := NewMapBackedGroupRepository()
+	groupRepo.CreateGroup("finance")
+	groupRepo.CreateGroup("accounting")
+	groupRepo.AddMember("finance", "accounting")
+	groupRepo.AddMember("accounting", "id")
+	acl := NewACL()
+	acl.AddACE(NewACE("finance", read))
+	resource := &mockResource{nativeId: "report", acl: acl}
+	aclService := NewAccessControlStrategy(nil, nil, false, WithGroupRepository(groupRepo))
+
+	// when
+	decision, err := aclService.ExplainResourceAccess(&mockPrincipal{id: "bob", sid: "id"}, read, resource)
+
+	// then
+	assert.Nil(t, err)
+	assert.True(t, decision.Granted)
+	assert.Equal(t, GroupSidACERule, decision.Rule)
+	assert.Equal(t, "finance", decision.Sid)
+	assert.NotNil(t, aclService.VerifyResourceAccess(&mockPrincipal{id: "alice", sid: "alice"}, read, resource))
+}
+
+func TestVerifyAccessWithClock(t *testing.T) {
+	// given
+	read := Permission(1)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start.Add(-time.Minute)
+	clock := func() time.Time { return now }
+	acl := NewACL()
+	acl.AddACE(NewTimeBoundACE("id", read, start, start.Add(time.Hour)))
+	resource := &mockResource{nativeId: "document", acl: acl}
+	roleRepo := NewMapBackedRoleRepository()
+	roleRepo.CreateRole(NewRole("oncall", read))
+	membershipRepo := NewMapBackedRoleMembershipRepository()
+	membershipRepo.(TimeBoundRoleMembershipRepository).AddMemberBetween("oncall", "id", start, start.Add(time.Hour))
+	aclService := NewAccessControlStrategy(nil, roleRepo, false, WithRoleMembership(membershipRepo, false), WithClock(clock))
+	p := &mockPrincipal{id: "bob", sid: "id"}
+
+	// when
+	before := aclService.VerifyResourceAccess(p, read, resource)
+	beforeRole := aclService.VerifyRoleAccess(p, read)
+	now = start
+	during := aclService.VerifyResourceAccess(p, read, resource)
+	duringRole := aclService.VerifyRoleAccess(p, read)
+	now = start.Add(time.Hour)
+	after := aclService.VerifyResourceAccess(p, read, resource)
+	afterRole := aclService.VerifyRoleAccess(p, read)
+
+	// then
+	assert.True(t, errors.Is(before, ErrAccessDenied))
+	assert.True(t, errors.Is(beforeRole, ErrAccessDenied))
+	assert.Nil(t, during)
+	assert.Nil(t, duringRole)
+	assert.True(t, errors.Is(after, ErrAccessDenied))
+	assert.True(t, errors.Is(afterRole, ErrAccessDenied))
+}
+
+func TestVerifyRoleAccessErrors(t *testing.T) {
+	// given
+	create := Permission(1)
+	update := Permission(2)
+	repositoryErr := errors.New("connection refused")
+	mockRoleRepo := new(mockRoleRepository)
+	mockRoleRepo.On("FindAll").Return([]Role{NewRole("testRole", create)}, nil)
+	failingRoleRepo := new(mockRoleRepository)
+	failingRoleRepo.On("FindAll").Return([]Role{}, repositoryErr)
+	p := &mockPrincipal{id: "bob", roleNames: []string{"testRole"}}
+
+	// when
+	err := NewAccessControlStrategy(nil, mockRoleRepo, true).VerifyRoleAccess(p, update)
+
+	// then
+	assert.True(t, errors.Is(err, ErrAccessDenied))
+	assert.False(t, errors.Is(err, ErrRepository))
+	var deniedErr *AccessDeniedError
+	assert.True(t, errors.As(err, &deniedErr))
+	assert.Equal(t, "bob", deniedErr.PrincipalId)
+	assert.Equal(t, update, deniedErr.Permission)
+	assert.Equal(t, "", deniedErr.ResourceId)
+
+	err = NewAccessControlStrategy(nil, failingRoleRepo, true).VerifyRoleAccess(p, update)
+	assert.True(t, errors.Is(err, ErrRepository))
+	assert.True(t, errors.Is(err, repositoryErr))
+	assert.False(t, errors.Is(err, ErrAccessDenied))
+}
+
+func TestVerifyResourceAccessErrors(t *testing.T) {
+	// given
+	create := Permission(1)
+	repositoryErr := errors.New("connection refused")
+	p := &mockPrincipal{id: "bob", sid: "id", roleNames: []string{}}
+	resourceRepo := NewMapBackedSecureResourceRepository()
+	resourceRepo.CreateResource(NewSecureResource("id", "owner", nil, false))
+	failingResourceRepo := new(mockSecureResourceRepository)
+	failingResourceRepo.On("FindResource", "id").Return(&mockResource{}, repositoryErr)
+
+	// when
+	err := NewAccessControlStrategy(resourceRepo, nil, false).VerifyResourceAccessById(p, create, "id")
+
+	// then
+	var deniedErr *AccessDeniedError
+	assert.True(t, errors.As(err, &deniedErr))
+	assert.Equal(t, "id", deniedErr.ResourceId)
+	assert.True(t, errors.Is(err, ErrAccessDenied))
+
+	err = NewAccessControlStrategy(resourceRepo, nil, false).VerifyResourceAccessById(p, create, "missingId")
+	assert.True(t, errors.Is(err, ErrResourceNotFound))
+	assert.False(t, errors.Is(err, ErrRepository))
+
+	err = NewAccessControlStrategy(failingResourceRepo, nil, false).VerifyResourceAccessById(p, create, "id")
+	assert.True(t, errors.Is(err, ErrRepository))
+	assert.True(t, errors.Is(err, repositoryErr))
+}
+
+func TestVerifyHierarchicalRoleAccess(t *testing.T) {
+	// given
+	request := Permission(1)
+	approve := Permission(2)
+	confirm := Permission(4)
+	mockRoleRepo := new(mockRoleRepository)
+	mockRoleRepo.On("FindAll").Return([]Role{
+		NewRole("employee", request),
+		NewHierarchicalRole("manager", approve, "employee"),
+		NewHierarchicalRole("director", EmptyPermissionMask, "manager"),
+		NewRole("clerk", confirm),
+	}, nil)
+	p := &mockPrincipal{id: "bob", roleNames: []string{"director"}}
+	aclService := NewAccessControlStrategy(nil, mockRoleRepo, true)
+
+	// when
+	decision, err := aclService.ExplainRoleAccess(p, request)
+
+	// then
+	assert.Nil(t, err)
+	assert.True(t, decision.Granted)
+	assert.Equal(t, "employee", decision.RoleName)
+	assert.Equal(t, []string{"director", "manager", "employee"}, decision.RolesConsulted)
+	assert.Nil(t, aclService.VerifyRoleAccess(p, approve))
+	assert.NotNil(t, aclService.VerifyRoleAccess(p, confirm))
+}
+
 // mock principal
 type mockPrincipal struct {
 	id        string
@@ -214,6 +714,26 @@ func (this *mockPrincipal) GetRoleNames() []string {
 	return this.roleNames
 }
 
+// mock principal belonging to groups
+type mockGroupPrincipal struct {
+	mockPrincipal
+	groupSids []string
+}
+
+func (this *mockGroupPrincipal) GetGroupSids() []string {
+	return this.groupSids
+}
+
+// mock group resolver
+type mockGroupResolver struct {
+	groupSids map[string][]string
+	err       error
+}
+
+func (this *mockGroupResolver) ResolveGroupSids(ctx context.Context, principal Principal) ([]string, error) {
+	return this.groupSids[principal.GetSid()], this.err
+}
+
 // mock resource
 type mockResource struct {
 	nativeId   string
@@ -243,6 +763,17 @@ func (this *mockResource) InheritsParentACL() bool {
 	return this.inheritACL
 }
 
+// mock resource counting its acl lookups
+type countingResource struct {
+	mockResource
+	aclLookups int
+}
+
+func (this *countingResource) GetACL() (ACL, error) {
+	this.aclLookups++
+	return this.acl, nil
+}
+
 // mock role repository
 type mockRoleRepository struct {
 	mock.Mock
@@ -297,3 +828,13 @@ func (this *mockSecureResourceRepository) DeleteResource(nativeResourceId string
 	args := this.Mock.Called(nativeResourceId)
 	return args.Error(0)
 }
+
+// mock resource repository that evaluates acls itself
+type mockACLEvaluatingRepository struct {
+	mockSecureResourceRepository
+}
+
+func (this *mockACLEvaluatingRepository) EvaluateACL(ctx context.Context, sid string, groupSids []string, permission Permission, nativeResourceId string, at time.Time) (*ACLEvaluation, error) {
+	args := this.Mock.Called(sid, groupSids, permission, nativeResourceId)
+	return args.Get(0).(*ACLEvaluation), args.Error(1)
+}
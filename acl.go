@@ -16,8 +16,8 @@ package nogo
 
 import (
 	"errors"
-	"math"
 	"sync"
+	"time"
 )
 
 const (
@@ -34,10 +34,17 @@ type ACL interface {
 	AddACE(ace ACE) error
 	// Removes an access control entry from the list. Returns an error if the entry was not successfully removed, or if the entry could not be located.
 	RemoveACE(ace ACE) error
-	// Returns an access control entry associated with the sid. May return an empty value. Returns an error if the principal could not be looked up.
+	// Returns the allow access control entry associated with the sid. May return an empty value. Returns an error if the principal could not be looked up.
 	GetACEForSid(sid string) (ACE, error)
 }
 
+// Optionally implemented by an ACL that holds deny entries alongside its allow entries. ACLs that do not implement it are treated as holding allow entries only.
+type DenyACL interface {
+	ACL
+	// Returns the deny access control entry associated with the sid. May return an empty value. Returns an error if the principal could not be looked up.
+	GetDenyACEForSid(sid string) (ACE, error)
+}
+
 // An access control entry definition that can be referenced in resource ACLs.
 type ACE interface {
 	// Returns the SID of the principal that is granted access. Must not return an empty value.
@@ -48,6 +55,22 @@ type ACE interface {
 	HasPermission(permission Permission) (bool, error)
 }
 
+// Optionally implemented by an ACE that may deny, rather than grant, its permissions to the principal. ACEs that do not implement it grant their permissions.
+type DenyACE interface {
+	ACE
+	// Returns true if this entry denies, rather than grants, its permissions to the principal.
+	IsDeny() bool
+}
+
+// Optionally implemented by an ACE that only applies during a window of time, such as temporary access granted to an on-call engineer or auditor.
+type TimeBoundACE interface {
+	ACE
+	// Returns the time from which the entry applies. The zero time means the entry applies from the beginning of time.
+	GetNotBefore() time.Time
+	// Returns the time at which the entry expires. The zero time means the entry never expires.
+	GetNotAfter() time.Time
+}
+
 // A secure resource is defined as containing an access control list that restricts modes of access to itself.
 type SecureResource interface {
 	// returns the native (external) id for the resource.
@@ -62,41 +85,80 @@ type SecureResource interface {
 	InheritsParentACL() bool
 }
 
-// Creates a new access control list
+// Creates a new secure resource with an empty access control list. The parent may be nil if the resource does not have a parent.
+func NewSecureResource(nativeId string, ownerSid string, parent SecureResource, inheritParentACL bool) SecureResource {
+	return &defaultSecureResource{nativeId: nativeId, ownerSid: ownerSid, parent: parent, inheritParentACL: inheritParentACL, acl: NewACL()}
+}
+
+type defaultSecureResource struct {
+	nativeId         string
+	ownerSid         string
+	parent           SecureResource
+	inheritParentACL bool
+	acl              ACL
+}
+
+func (this *defaultSecureResource) GetNativeId() string {
+	return this.nativeId
+}
+
+func (this *defaultSecureResource) GetACL() (ACL, error) {
+	return this.acl, nil
+}
+
+func (this *defaultSecureResource) GetParentResource() SecureResource {
+	return this.parent
+}
+
+func (this *defaultSecureResource) GetOwnerSid() string {
+	return this.ownerSid
+}
+
+func (this *defaultSecureResource) InheritsParentACL() bool {
+	return this.inheritParentACL
+}
+
+// Creates a new access control list. The list holds at most one allow entry and one deny entry per sid.
 func NewACL() ACL {
-	return &defaultACL{aces: make(map[string]ACE), lock: &sync.RWMutex{}}
+	return &defaultACL{aces: make(map[string]ACE), denyAces: make(map[string]ACE), lock: &sync.RWMutex{}}
 }
 
 type defaultACL struct {
-	lock *sync.RWMutex
-	aces map[string]ACE
+	lock     *sync.RWMutex
+	aces     map[string]ACE
+	denyAces map[string]ACE
 }
 
 func (d *defaultACL) GetACEs() ([]ACE, error) {
 	d.lock.RLock()
 	defer d.lock.RUnlock()
-	ret := make([]ACE, 0, len(d.aces))
+	ret := make([]ACE, 0, len(d.aces)+len(d.denyAces))
 	for _, v := range d.aces {
 		ret = append(ret, v)
 	}
+	for _, v := range d.denyAces {
+		ret = append(ret, v)
+	}
 	return ret, nil
 }
 
 func (d *defaultACL) AddACE(ace ACE) error {
 	d.lock.Lock()
 	defer d.lock.Unlock()
-	if _, ok := d.aces[ace.GetSid()]; ok {
+	entries := d.entriesFor(ace)
+	if _, ok := entries[ace.GetSid()]; ok {
 		return errors.New("The entry already exists in this ACL.")
 	}
-	d.aces[ace.GetSid()] = ace
+	entries[ace.GetSid()] = ace
 	return nil
 }
 
 func (d *defaultACL) RemoveACE(ace ACE) error {
 	d.lock.Lock()
 	defer d.lock.Unlock()
-	if _, ok := d.aces[ace.GetSid()]; ok {
-		delete(d.aces, ace.GetSid())
+	entries := d.entriesFor(ace)
+	if _, ok := entries[ace.GetSid()]; ok {
+		delete(entries, ace.GetSid())
 		return nil
 	}
 	return errors.New("Error removing ACE.")
@@ -111,14 +173,49 @@ func (d *defaultACL) GetACEForSid(sid string) (ACE, error) {
 	return nil, nil
 }
 
-// Creates a control entry for the sid and set of permissions
+func (d *defaultACL) GetDenyACEForSid(sid string) (ACE, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if entry, ok := d.denyAces[sid]; ok {
+		return entry, nil
+	}
+	return nil, nil
+}
+
+// returns the entry map the ace belongs to. Callers must hold the lock.
+func (d *defaultACL) entriesFor(ace ACE) map[string]ACE {
+	if isDenyACE(ace) {
+		return d.denyAces
+	}
+	return d.aces
+}
+
+// Creates a control entry granting the sid a set of permissions
 func NewACE(sid string, mask Permission) ACE {
-	return &defaultACE{sid, mask}
+	return &defaultACE{sid: sid, permissionMask: mask}
+}
+
+// Creates a control entry denying the sid a set of permissions. When verifying access, a deny entry overrides any allow entries defined on the same resource.
+func NewDenyACE(sid string, mask Permission) ACE {
+	return &defaultACE{sid: sid, permissionMask: mask, deny: true}
+}
+
+// Creates a control entry granting the sid a set of permissions from notBefore until notAfter. A zero notBefore applies the entry immediately, while a zero notAfter never expires it.
+func NewTimeBoundACE(sid string, mask Permission, notBefore time.Time, notAfter time.Time) ACE {
+	return &defaultACE{sid: sid, permissionMask: mask, notBefore: notBefore, notAfter: notAfter}
+}
+
+// Creates a control entry denying the sid a set of permissions from notBefore until notAfter. A zero notBefore applies the entry immediately, while a zero notAfter never expires it.
+func NewTimeBoundDenyACE(sid string, mask Permission, notBefore time.Time, notAfter time.Time) ACE {
+	return &defaultACE{sid: sid, permissionMask: mask, deny: true, notBefore: notBefore, notAfter: notAfter}
 }
 
 type defaultACE struct {
 	sid            string
 	permissionMask Permission
+	deny           bool
+	notBefore      time.Time
+	notAfter       time.Time
 }
 
 func (this *defaultACE) GetSid() string {
@@ -127,12 +224,11 @@ func (this *defaultACE) GetSid() string {
 
 func (this *defaultACE) GetPermissions() []Permission {
 	permissions := make([]Permission, 0)
-	pos := Permission(1)
-	for pos < math.MaxInt32 {
-		if this.permissionMask&(pos) != 0 {
+	for i := uint(0); i < MaxPermissions; i++ {
+		pos := Permission(1) << i
+		if this.permissionMask&pos != 0 {
 			permissions = append(permissions, pos)
 		}
-		pos = pos << 1
 	}
 	return permissions
 }
@@ -141,3 +237,47 @@ func (this *defaultACE) HasPermission(permission Permission) (bool, error) {
 	val := (this.permissionMask&permission != 0)
 	return val, nil
 }
+
+func (this *defaultACE) IsDeny() bool {
+	return this.deny
+}
+
+func (this *defaultACE) GetNotBefore() time.Time {
+	return this.notBefore
+}
+
+func (this *defaultACE) GetNotAfter() time.Time {
+	return this.notAfter
+}
+
+// Returns true if the entry denies its permissions. Entries that do not implement DenyACE grant them.
+func isDenyACE(ace ACE) bool {
+	denyACE, ok := ace.(DenyACE)
+	return ok && denyACE.IsDeny()
+}
+
+// Returns the deny entry associated with the sid, or nil if the ACL does not hold deny entries.
+func denyACEForSid(acl ACL, sid string) (ACE, error) {
+	denyACL, ok := acl.(DenyACL)
+	if !ok {
+		return nil, nil
+	}
+	return denyACL.GetDenyACEForSid(sid)
+}
+
+// Returns true if the entry applies at the given time. Entries that are not time bound always apply.
+func isACEActive(ace ACE, at time.Time) bool {
+	timeBound, ok := ace.(TimeBoundACE)
+	if !ok {
+		return true
+	}
+	return isWithinWindow(at, timeBound.GetNotBefore(), timeBound.GetNotAfter())
+}
+
+// Returns true if at falls within the window, notBefore being inclusive and notAfter exclusive. Zero times leave the window open.
+func isWithinWindow(at time.Time, notBefore time.Time, notAfter time.Time) bool {
+	if !notBefore.IsZero() && at.Before(notBefore) {
+		return false
+	}
+	return notAfter.IsZero() || at.Before(notAfter)
+}
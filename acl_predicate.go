@@ -0,0 +1,66 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dakiva/dbx"
+	"github.com/lib/pq"
+)
+
+// Generates SQL predicates that restrict an application's own queries to the resources a principal has access to, evaluated by the database against the nogo schema.
+type ACLPredicateBuilder interface {
+	// Returns a predicate for a WHERE clause that is true when the column, holding a native resource id, refers to a resource the sid owns, or on which an entry for the sid, one of its group sids or the WorldSid grants the permission, either directly or inherited from an ancestor. Access is evaluated as with VerifyResourceAccess at the given time, excluding admin access. The column is inserted as is, so it must not contain untrusted input. The returned named args, prefixed with "nogo_", must be merged with the args of the query.
+	Predicate(column string, sid string, groupSids []string, permission Permission, at time.Time) (string, map[string]interface{})
+}
+
+var (
+	// matches the nogo tables referenced by a query
+	tableReferencePattern = regexp.MustCompile(`\b(FROM|JOIN) (secure_resource|acl_entry)\b`)
+	// matches the named args of a query, skipping :: casts
+	namedArgPattern = regexp.MustCompile(`([^:]):([a-z_]+)\b`)
+)
+
+type defaultACLPredicateBuilder struct {
+	subquery string
+}
+
+// Construct an ACLPredicateBuilder for the nogo tables in the given schema, using the AccessibleSecureResourceIds query of the query map. If the schema is empty, table names are left unqualified and resolved through the search path.
+func NewACLPredicateBuilder(queryMap dbx.QueryMap, schema string) ACLPredicateBuilder {
+	subquery := queryMap.Q("AccessibleSecureResourceIds")
+	if schema != "" {
+		subquery = tableReferencePattern.ReplaceAllString(subquery, "$1 "+strings.ReplaceAll(quoteIdentifier(schema), "$", "$$")+".$2")
+	}
+	// prefix the named args so that they do not collide with the args of the application's query
+	subquery = namedArgPattern.ReplaceAllString(subquery, "$1:nogo_$2")
+	return &defaultACLPredicateBuilder{subquery: subquery}
+}
+
+func (this *defaultACLPredicateBuilder) Predicate(column string, sid string, groupSids []string, permission Permission, at time.Time) (string, map[string]interface{}) {
+	args := map[string]interface{}{
+		"nogo_principal_sid":   sid,
+		"nogo_principal_sids":  pq.StringArray(entrySids(sid, groupSids)),
+		"nogo_permission_mask": permission,
+		"nogo_now":             at,
+	}
+	return column + " IN (" + this.subquery + ")", args
+}
+
+func quoteIdentifier(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
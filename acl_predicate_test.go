@@ -0,0 +1,86 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestACLPredicateQualifiesSchema(t *testing.T) {
+	// given
+	unqualified := NewACLPredicateBuilder(queryMap, "")
+	qualified := NewACLPredicateBuilder(queryMap, `my"schema$1`)
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// when
+	predicate, args := unqualified.Predicate("d.document_id", "sid", nil, 2, at)
+	qualifiedPredicate, _ := qualified.Predicate("d.document_id", "sid", nil, 2, at)
+
+	// then
+	assert.True(t, strings.HasPrefix(predicate, "d.document_id IN (WITH RECURSIVE "))
+	assert.True(t, strings.HasSuffix(predicate, ")"))
+	assert.Contains(t, predicate, " FROM acl_entry ")
+	assert.Contains(t, predicate, " FROM secure_resource ")
+	assert.Contains(t, qualifiedPredicate, ` FROM "my""schema$1".acl_entry `)
+	assert.Contains(t, qualifiedPredicate, ` FROM "my""schema$1".secure_resource `)
+	assert.NotContains(t, qualifiedPredicate, ` FROM secure_resource`)
+	assert.Contains(t, predicate, "principal_sid = ANY(:nogo_principal_sids)")
+	assert.Contains(t, predicate, "not_before <= :nogo_now")
+	assert.Contains(t, predicate, "owner_sid = :nogo_principal_sid ")
+	assert.Equal(t, strings.Count(queryMap.Q("AccessibleSecureResourceIds"), ":"), strings.Count(predicate, ":nogo_"))
+	assert.Equal(t, map[string]interface{}{"nogo_principal_sid": "sid", "nogo_principal_sids": pq.StringArray{"sid", WorldSid}, "nogo_permission_mask": Permission(2), "nogo_now": at}, args)
+}
+
+func TestACLPredicateFiltersQuery(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	createAccessibleResources(t, NewDBBackedSecureResourceRepository(tx, queryMap))
+	_, err := tx.Exec("CREATE TEMPORARY TABLE document (document_id text NOT NULL, title text NOT NULL) ON COMMIT DROP")
+	assert.Nil(t, err)
+	for _, id := range []string{"root", "folder", "doc", "shared", "private", "mine", "direct", "untracked"} {
+		_, err = tx.Exec(tx.Rebind("INSERT INTO document (document_id, title) VALUES (?, ?)"), id, "title "+id)
+		assert.Nil(t, err)
+	}
+	predicate, args := NewACLPredicateBuilder(queryMap, "").Predicate("document.document_id", "sid", nil, 1, time.Now())
+	args["title"] = "title mine"
+
+	// when
+	rows, err := tx.NamedQuery("SELECT document_id FROM document WHERE "+predicate+" ORDER BY document_id", args)
+	assert.Nil(t, err)
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	filtered, err := tx.NamedQuery("SELECT document_id FROM document WHERE title <> :title AND "+predicate, args)
+	assert.Nil(t, err)
+	count := 0
+	for filtered.Next() {
+		count++
+	}
+	filtered.Close()
+
+	// then
+	assert.Equal(t, []string{"direct", "mine", "root", "shared"}, ids)
+	assert.Equal(t, 3, count)
+}
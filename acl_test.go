@@ -15,9 +15,11 @@
 package nogo
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -97,6 +99,33 @@ func TestGetACEsForPrincipal(t *testing.T) {
 	assert.Nil(t, storedAce)
 }
 
+func TestAclAllowAndDenyACEsForSameSid(t *testing.T) {
+	create := Permission(1)
+	update := Permission(2)
+	ace := NewACE("id", create)
+	denyAce := NewDenyACE("id", update)
+	acl := NewACL()
+
+	assert.Nil(t, acl.AddACE(ace))
+	assert.Nil(t, acl.AddACE(denyAce))
+	assert.NotNil(t, acl.AddACE(NewDenyACE("id", create)))
+
+	aces, _ := acl.GetACEs()
+	assert.Equal(t, 2, len(aces))
+	storedAce, _ := acl.GetACEForSid("id")
+	assert.Equal(t, ace, storedAce)
+	storedAce, _ = acl.(DenyACL).GetDenyACEForSid("id")
+	assert.Equal(t, denyAce, storedAce)
+	assert.True(t, isDenyACE(storedAce))
+
+	err := acl.RemoveACE(denyAce)
+	assert.Nil(t, err)
+	storedAce, _ = acl.(DenyACL).GetDenyACEForSid("id")
+	assert.Nil(t, storedAce)
+	storedAce, _ = acl.GetACEForSid("id")
+	assert.Equal(t, ace, storedAce)
+}
+
 func TestAuthorized(t *testing.T) {
 	create := Permission(1)
 	update := Permission(2)
@@ -113,12 +142,12 @@ func TestAuthorized(t *testing.T) {
 
 func TestDefaultACEHasPermissionBoundaries(t *testing.T) {
 	ace := NewACE("00000000-0000-0000-0000-000000000000", Permission(1))
-	isAuth, err := ace.HasPermission(Permission(-1))
+	isAuth, err := ace.HasPermission(EmptyPermissionMask)
 	assert.False(t, isAuth, "should not have permissions")
 	assert.Nil(t, err, "should be no error")
 
 	ace = NewACE("00000000-0000-0000-0000-000000000000", Permission(math.MaxInt32))
-	isAuth, err = ace.HasPermission(Permission(-1))
+	isAuth, err = ace.HasPermission(EmptyPermissionMask)
 	assert.False(t, isAuth, "should not have permissions")
 	assert.Nil(t, err, "should be no error")
 
@@ -129,6 +158,15 @@ func TestDefaultACEHasPermissionBoundaries(t *testing.T) {
 	isAuth, err = ace.HasPermission(Permission(1<<31 - 1))
 	assert.True(t, isAuth, "should have permissions")
 	assert.Nil(t, err, "should be no error")
+
+	isAuth, err = ace.HasPermission(Permission(1 << 31))
+	assert.False(t, isAuth, "should not have permissions")
+	assert.Nil(t, err, "should be no error")
+
+	ace = NewACE("00000000-0000-0000-0000-000000000000", Permission(1<<63))
+	isAuth, err = ace.HasPermission(Permission(1 << 63))
+	assert.True(t, isAuth, "should have permissions")
+	assert.Nil(t, err, "should be no error")
 }
 
 func TestDefaultACEGetPermissions(t *testing.T) {
@@ -138,7 +176,12 @@ func TestDefaultACEGetPermissions(t *testing.T) {
 
 	ace = NewACE("00000000-0000-0000-0000-000000000000", Permission(math.MaxInt32))
 	perms = ace.GetPermissions()
-	assert.Equal(t, 31, len(perms), "should have maximum permissions")
+	assert.Equal(t, 31, len(perms), "should have 31 permissions")
+
+	ace = NewACE("00000000-0000-0000-0000-000000000000", ^EmptyPermissionMask)
+	perms = ace.GetPermissions()
+	assert.Equal(t, MaxPermissions, len(perms), "should have maximum permissions")
+	assert.Equal(t, Permission(1<<63), perms[MaxPermissions-1])
 }
 
 const N = 1000
@@ -201,3 +244,107 @@ func TestDefaultACEConcurrentRemove(t *testing.T) {
 	assert.Nil(t, err, "there should be no error")
 	assert.Equal(t, 0, len(aces), "all ACEs should be accounted for")
 }
+
+func TestTimeBoundACE(t *testing.T) {
+	// given
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	ace := NewTimeBoundACE("id", Permission(1), start, end)
+	denyAce := NewTimeBoundDenyACE("id", Permission(1), time.Time{}, end)
+
+	// when
+	timeBound := ace.(TimeBoundACE)
+
+	// then
+	assert.Equal(t, start, timeBound.GetNotBefore())
+	assert.Equal(t, end, timeBound.GetNotAfter())
+	assert.True(t, isDenyACE(denyAce))
+	assert.False(t, isACEActive(ace, start.Add(-time.Second)))
+	assert.True(t, isACEActive(ace, start))
+	assert.True(t, isACEActive(ace, end.Add(-time.Second)))
+	assert.False(t, isACEActive(ace, end))
+	assert.True(t, isACEActive(denyAce, start.Add(-time.Hour)))
+	assert.False(t, isACEActive(denyAce, end))
+	assert.True(t, isACEActive(NewACE("id", Permission(1)), end))
+}
+
+func TestAllowOnlyACL(t *testing.T) {
+	// given
+	ace := &allowOnlyACE{sid: "id", mask: Permission(1)}
+	acl := &allowOnlyACL{ace: ace}
+	resource := &defaultSecureResource{nativeId: "resource", acl: acl}
+
+	// when
+	denyAce, err := denyACEForSid(acl, "id")
+	decision, sid, evaluateErr := evaluateACL([]string{"id"}, Permission(1), time.Now(), resource)
+
+	// then
+	assert.Nil(t, err)
+	assert.Nil(t, denyAce)
+	assert.False(t, isDenyACE(ace))
+	assert.Nil(t, evaluateErr)
+	assert.Equal(t, aceAllowed, decision)
+	assert.Equal(t, "id", sid)
+}
+
+func TestDenyACEInAllowOnlyACL(t *testing.T) {
+	// given
+	read := Permission(1)
+	parentACL := NewACL()
+	parentACL.AddACE(NewACE(WorldSid, read))
+	parent := &defaultSecureResource{nativeId: "folder", acl: parentACL}
+	resource := &defaultSecureResource{nativeId: "document", parent: parent, inheritParentACL: true, acl: &allowOnlyACL{ace: NewDenyACE("id", read)}}
+	strategy := NewAccessControlStrategy(nil, NewMapBackedRoleRepository(), false)
+	p := &mockPrincipal{id: "bob", sid: "id"}
+
+	// when
+	err := strategy.VerifyResourceAccess(p, read, resource)
+	visible, filterErr := strategy.FilterAuthorized(p, read, []SecureResource{resource, parent})
+
+	// then
+	assert.True(t, errors.Is(err, ErrAccessDenied))
+	assert.Nil(t, filterErr)
+	assert.Equal(t, []SecureResource{parent}, visible)
+}
+
+// an ACL implemented outside of nogo, predating deny entries
+type allowOnlyACL struct {
+	ace ACE
+}
+
+func (this *allowOnlyACL) GetACEs() ([]ACE, error) {
+	return []ACE{this.ace}, nil
+}
+
+func (this *allowOnlyACL) AddACE(ace ACE) error {
+	return nil
+}
+
+func (this *allowOnlyACL) RemoveACE(ace ACE) error {
+	return nil
+}
+
+func (this *allowOnlyACL) GetACEForSid(sid string) (ACE, error) {
+	if sid == this.ace.GetSid() {
+		return this.ace, nil
+	}
+	return nil, nil
+}
+
+// an ACE implemented outside of nogo, predating deny entries
+type allowOnlyACE struct {
+	sid  string
+	mask Permission
+}
+
+func (this *allowOnlyACE) GetSid() string {
+	return this.sid
+}
+
+func (this *allowOnlyACE) GetPermissions() []Permission {
+	return []Permission{this.mask}
+}
+
+func (this *allowOnlyACE) HasPermission(permission Permission) (bool, error) {
+	return this.mask&permission != 0, nil
+}
@@ -0,0 +1,201 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// The kinds of checks recorded in an AuditEvent.
+const (
+	RoleAccessCheck         = "role"
+	ResourceAccessCheck     = "resource"
+	ResourceAccessByIdCheck = "resource by id"
+)
+
+// A record of a single access check.
+type AuditEvent struct {
+	// The time the check was made.
+	Timestamp time.Time `json:"timestamp"`
+	// The kind of check, one of RoleAccessCheck, ResourceAccessCheck or ResourceAccessByIdCheck.
+	Check string `json:"check"`
+	// The id of the principal being checked.
+	PrincipalId string `json:"principal_id"`
+	// The sid of the principal being checked.
+	PrincipalSid string `json:"principal_sid"`
+	// The permission being checked.
+	Permission Permission `json:"permission"`
+	// The native id of the resource being checked. Empty for role checks.
+	ResourceId string `json:"resource_id,omitempty"`
+	// True if access was granted.
+	Granted bool `json:"granted"`
+	// The name of the rule that decided the outcome. Empty if the check could not be performed.
+	Rule string `json:"rule,omitempty"`
+	// The error that prevented the check from being performed, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// A destination for audit events.
+type AuditSink interface {
+	// Records the event. Returns an error if the event could not be recorded.
+	WriteAuditEvent(ctx context.Context, event *AuditEvent) error
+}
+
+// An option that customizes an auditing access control strategy.
+type AuditOption func(*auditingAccessControlStrategy)
+
+// Records only a fraction of the checks that grant access, between 0 (none) and 1 (all). Denials and failed checks are always recorded.
+func WithAuditSampling(rate float64) AuditOption {
+	return func(strategy *auditingAccessControlStrategy) {
+		strategy.sampleRate = rate
+	}
+}
+
+// Records only denials and failed checks.
+func WithDenialsOnly() AuditOption {
+	return func(strategy *auditingAccessControlStrategy) {
+		strategy.denialsOnly = true
+	}
+}
+
+// Calls the handler when the sink fails to record an event. By default sink failures are ignored, and never change the outcome of a check.
+func WithAuditErrorHandler(handler func(event *AuditEvent, err error)) AuditOption {
+	return func(strategy *auditingAccessControlStrategy) {
+		strategy.errorHandler = handler
+	}
+}
+
+type auditingAccessControlStrategy struct {
+	ContextAccessControlStrategy
+	sink         AuditSink
+	sampleRate   float64
+	denialsOnly  bool
+	errorHandler func(event *AuditEvent, err error)
+	now          func() time.Time
+	random       func() float64
+}
+
+// Construct an AccessControlStrategy that records an AuditEvent with the sink for every VerifyRoleAccess, VerifyResourceAccess and VerifyResourceAccessById check made through the given strategy, including their context aware variants. The outcome of each check is that of the given strategy's Verify methods, and the deciding rule is the one reported through WithDecisionObserver by the same evaluation. Explain and filter calls are passed through without being recorded.
+func NewAuditingAccessControlStrategy(strategy AccessControlStrategy, sink AuditSink, options ...AuditOption) ContextAccessControlStrategy {
+	auditing := &auditingAccessControlStrategy{ContextAccessControlStrategy: AdaptAccessControlStrategy(strategy), sink: sink, sampleRate: 1, now: time.Now, random: rand.Float64}
+	for _, option := range options {
+		option(auditing)
+	}
+	return auditing
+}
+
+func (this *auditingAccessControlStrategy) VerifyRoleAccess(principal Principal, permission Permission) error {
+	return this.VerifyRoleAccessContext(context.Background(), principal, permission)
+}
+
+func (this *auditingAccessControlStrategy) VerifyResourceAccess(principal Principal, permission Permission, resource SecureResource) error {
+	return this.VerifyResourceAccessContext(context.Background(), principal, permission, resource)
+}
+
+func (this *auditingAccessControlStrategy) VerifyResourceAccessById(principal Principal, permission Permission, resourceId string) error {
+	return this.VerifyResourceAccessByIdContext(context.Background(), principal, permission, resourceId)
+}
+
+func (this *auditingAccessControlStrategy) VerifyRoleAccessContext(ctx context.Context, principal Principal, permission Permission) error {
+	event := this.newEvent(RoleAccessCheck, principal, permission, "")
+	var decision *Decision
+	err := this.ContextAccessControlStrategy.VerifyRoleAccessContext(observeInto(ctx, &decision), principal, permission)
+	return this.record(ctx, event, decision, err)
+}
+
+func (this *auditingAccessControlStrategy) VerifyResourceAccessContext(ctx context.Context, principal Principal, permission Permission, resource SecureResource) error {
+	event := this.newEvent(ResourceAccessCheck, principal, permission, "")
+	if resource != nil {
+		event.ResourceId = resource.GetNativeId()
+	}
+	var decision *Decision
+	err := this.ContextAccessControlStrategy.VerifyResourceAccessContext(observeInto(ctx, &decision), principal, permission, resource)
+	return this.record(ctx, event, decision, err)
+}
+
+func (this *auditingAccessControlStrategy) VerifyResourceAccessByIdContext(ctx context.Context, principal Principal, permission Permission, resourceId string) error {
+	event := this.newEvent(ResourceAccessByIdCheck, principal, permission, resourceId)
+	var decision *Decision
+	err := this.ContextAccessControlStrategy.VerifyResourceAccessByIdContext(observeInto(ctx, &decision), principal, permission, resourceId)
+	return this.record(ctx, event, decision, err)
+}
+
+// returns a context storing the decision reported by the check made with it.
+func observeInto(ctx context.Context, decision **Decision) context.Context {
+	return WithDecisionObserver(ctx, func(observed *Decision) {
+		*decision = observed
+	})
+}
+
+func (this *auditingAccessControlStrategy) newEvent(check string, principal Principal, permission Permission, resourceId string) *AuditEvent {
+	return &AuditEvent{
+		Timestamp:    this.now(),
+		Check:        check,
+		PrincipalId:  principal.GetId(),
+		PrincipalSid: principal.GetSid(),
+		Permission:   permission,
+		ResourceId:   resourceId,
+	}
+}
+
+// completes the event with the outcome of the check, writes it to the sink if it is selected and returns the error of the check unchanged. The deciding rule is taken from the decision the check reported, and left empty if it reported none.
+func (this *auditingAccessControlStrategy) record(ctx context.Context, event *AuditEvent, decision *Decision, err error) error {
+	if err == nil {
+		event.Granted = true
+	} else if !errors.Is(err, ErrAccessDenied) {
+		event.Error = err.Error()
+	}
+	if decision != nil && event.Error == "" {
+		event.Rule = decision.Rule.String()
+	}
+	if this.isRecorded(event) {
+		if sinkErr := this.sink.WriteAuditEvent(ctx, event); sinkErr != nil && this.errorHandler != nil {
+			this.errorHandler(event, sinkErr)
+		}
+	}
+	return err
+}
+
+func (this *auditingAccessControlStrategy) isRecorded(event *AuditEvent) bool {
+	if !event.Granted {
+		return true
+	}
+	if this.denialsOnly {
+		return false
+	}
+	return this.sampleRate >= 1 || this.random() < this.sampleRate
+}
+
+type writerAuditSink struct {
+	lock    *sync.Mutex
+	encoder *json.Encoder
+}
+
+// Construct an AuditSink that writes each event to the writer as a line of JSON. Writes are serialized, so the writer may be shared by concurrent checks.
+func NewWriterAuditSink(w io.Writer) AuditSink {
+	return &writerAuditSink{lock: &sync.Mutex{}, encoder: json.NewEncoder(w)}
+}
+
+func (this *writerAuditSink) WriteAuditEvent(ctx context.Context, event *AuditEvent) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.encoder.Encode(event)
+}
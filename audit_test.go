@@ -0,0 +1,199 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditingStrategyRecordsChecks(t *testing.T) {
+	// given
+	read := Permission(1)
+	update := Permission(2)
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	roleRepo := NewMapBackedRoleRepository()
+	roleRepo.CreateRole(NewRole("reader", read))
+	resourceRepo := NewMapBackedSecureResourceRepository()
+	resource := NewSecureResource("doc", "owner", nil, false)
+	acl, _ := resource.GetACL()
+	acl.AddACE(NewACE("id", read))
+	resourceRepo.CreateResource(resource)
+	sink := &recordingAuditSink{}
+	strategy := NewAuditingAccessControlStrategy(NewAccessControlStrategy(resourceRepo, roleRepo, false), sink).(*auditingAccessControlStrategy)
+	strategy.now = func() time.Time { return at }
+	p := &mockPrincipal{id: "bob", sid: "id", roleNames: []string{"reader"}}
+
+	// when
+	roleErr := strategy.VerifyRoleAccess(p, read)
+	resourceErr := strategy.VerifyResourceAccess(p, update, resource)
+	byIdErr := strategy.VerifyResourceAccessById(p, read, "doc")
+	missingErr := strategy.VerifyResourceAccessById(p, read, "missing")
+
+	// then
+	assert.Nil(t, roleErr)
+	assert.True(t, errors.Is(resourceErr, ErrAccessDenied))
+	assert.Nil(t, byIdErr)
+	assert.True(t, errors.Is(missingErr, ErrResourceNotFound))
+	assert.Equal(t, 4, len(sink.events))
+	assert.Equal(t, &AuditEvent{Timestamp: at, Check: RoleAccessCheck, PrincipalId: "bob", PrincipalSid: "id", Permission: read, Granted: true, Rule: "role"}, sink.events[0])
+	assert.Equal(t, &AuditEvent{Timestamp: at, Check: ResourceAccessCheck, PrincipalId: "bob", PrincipalSid: "id", Permission: update, ResourceId: "doc", Rule: "no matching rule"}, sink.events[1])
+	assert.Equal(t, &AuditEvent{Timestamp: at, Check: ResourceAccessByIdCheck, PrincipalId: "bob", PrincipalSid: "id", Permission: read, ResourceId: "doc", Granted: true, Rule: "sid ace"}, sink.events[2])
+	assert.False(t, sink.events[3].Granted)
+	assert.Equal(t, "", sink.events[3].Rule)
+	assert.Equal(t, missingErr.Error(), sink.events[3].Error)
+}
+
+func TestAuditingStrategyModes(t *testing.T) {
+	// given
+	read := Permission(1)
+	update := Permission(2)
+	roleRepo := NewMapBackedRoleRepository()
+	roleRepo.CreateRole(NewRole("reader", read))
+	p := &mockPrincipal{id: "bob", sid: "id", roleNames: []string{"reader"}}
+	denialsSink := &recordingAuditSink{}
+	denials := NewAuditingAccessControlStrategy(NewAccessControlStrategy(nil, roleRepo, false), denialsSink, WithDenialsOnly())
+	sampledSink := &recordingAuditSink{}
+	sampled := NewAuditingAccessControlStrategy(NewAccessControlStrategy(nil, roleRepo, false), sampledSink, WithAuditSampling(0.5)).(*auditingAccessControlStrategy)
+	draws := []float64{0.7, 0.2}
+	sampled.random = func() float64 {
+		draw := draws[0]
+		draws = draws[1:]
+		return draw
+	}
+
+	// when
+	denials.VerifyRoleAccess(p, read)
+	denials.VerifyRoleAccess(p, update)
+	sampled.VerifyRoleAccess(p, read)
+	sampled.VerifyRoleAccess(p, read)
+	sampled.VerifyRoleAccess(p, update)
+
+	// then
+	assert.Equal(t, 1, len(denialsSink.events))
+	assert.False(t, denialsSink.events[0].Granted)
+	assert.Equal(t, 2, len(sampledSink.events))
+	assert.True(t, sampledSink.events[0].Granted)
+	assert.False(t, sampledSink.events[1].Granted)
+}
+
+func TestAuditingStrategyIgnoresSinkFailures(t *testing.T) {
+	// given
+	read := Permission(1)
+	roleRepo := NewMapBackedRoleRepository()
+	roleRepo.CreateRole(NewRole("reader", read))
+	sinkErr := errors.New("disk full")
+	var handledErr error
+	strategy := NewAuditingAccessControlStrategy(NewAccessControlStrategy(nil, roleRepo, false), &recordingAuditSink{err: sinkErr},
+		WithAuditErrorHandler(func(event *AuditEvent, err error) {
+			handledErr = err
+		}))
+
+	// when
+	err := strategy.VerifyRoleAccess(&mockPrincipal{id: "bob", sid: "id", roleNames: []string{"reader"}}, read)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, sinkErr, handledErr)
+}
+
+func TestAuditingStrategyEnforcesVerify(t *testing.T) {
+	// given
+	read := Permission(1)
+	roleRepo := NewMapBackedRoleRepository()
+	roleRepo.CreateRole(NewRole("reader", read))
+	sink := &recordingAuditSink{}
+	strategy := NewAuditingAccessControlStrategy(&denyingStrategy{AdaptAccessControlStrategy(NewAccessControlStrategy(nil, roleRepo, false))}, sink)
+	p := &mockPrincipal{id: "bob", sid: "id", roleNames: []string{"reader"}}
+
+	// when
+	err := strategy.VerifyRoleAccess(p, read)
+
+	// then
+	assert.True(t, errors.Is(err, ErrAccessDenied))
+	assert.Equal(t, 1, len(sink.events))
+	assert.False(t, sink.events[0].Granted)
+	assert.Equal(t, "", sink.events[0].Rule)
+	assert.Equal(t, "", sink.events[0].Error)
+}
+
+func TestAuditingStrategyEvaluatesOnce(t *testing.T) {
+	// given
+	read := Permission(1)
+	roleRepo := new(mockRoleRepository)
+	roleRepo.On("FindAll").Return([]Role{NewRole("reader", read)}, nil)
+	sink := &recordingAuditSink{}
+	strategy := NewAuditingAccessControlStrategy(NewAccessControlStrategy(nil, roleRepo, false), sink)
+
+	// when
+	err := strategy.VerifyRoleAccess(&mockPrincipal{id: "bob", sid: "id", roleNames: []string{"reader"}}, read)
+
+	// then
+	assert.Nil(t, err)
+	roleRepo.AssertNumberOfCalls(t, "FindAll", 1)
+	assert.Equal(t, 1, len(sink.events))
+	assert.Equal(t, "role", sink.events[0].Rule)
+}
+
+func TestWriterAuditSink(t *testing.T) {
+	// given
+	buffer := &bytes.Buffer{}
+	sink := NewWriterAuditSink(buffer)
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// when
+	sink.WriteAuditEvent(context.Background(), &AuditEvent{Timestamp: at, Check: RoleAccessCheck, PrincipalId: "bob", PrincipalSid: "id", Permission: 1, Granted: true, Rule: "role"})
+	sink.WriteAuditEvent(context.Background(), &AuditEvent{Timestamp: at, Check: ResourceAccessByIdCheck, PrincipalId: "bob", PrincipalSid: "id", Permission: 2, ResourceId: "doc", Error: "connection refused"})
+
+	// then
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.Equal(t, `{"timestamp":"2020-01-01T00:00:00Z","check":"role","principal_id":"bob","principal_sid":"id","permission":1,"granted":true,"rule":"role"}`, lines[0])
+	event := &AuditEvent{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), event))
+	assert.Equal(t, "doc", event.ResourceId)
+	assert.Equal(t, "connection refused", event.Error)
+	assert.False(t, event.Granted)
+}
+
+// audit sink that keeps the events it records
+type recordingAuditSink struct {
+	events []*AuditEvent
+	err    error
+}
+
+func (this *recordingAuditSink) WriteAuditEvent(ctx context.Context, event *AuditEvent) error {
+	if this.err != nil {
+		return this.err
+	}
+	this.events = append(this.events, event)
+	return nil
+}
+
+// strategy whose role checks deny access while its explanations grant it
+type denyingStrategy struct {
+	ContextAccessControlStrategy
+}
+
+func (this *denyingStrategy) VerifyRoleAccessContext(ctx context.Context, principal Principal, permission Permission) error {
+	return &AccessDeniedError{PrincipalId: principal.GetId(), Permission: permission}
+}
@@ -0,0 +1,141 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A RoleRepository that caches the roles of another repository.
+type CachingRoleRepository interface {
+	ContextRoleRepository
+	// Discards all cached roles, forcing the next lookup to reload them from the underlying repository.
+	Invalidate()
+}
+
+type cachingRoleRepository struct {
+	delegate ContextRoleRepository
+	ttl      time.Duration
+	now      func() time.Time
+	lock     *sync.RWMutex
+	roles    []Role
+	roleMap  map[string]Role
+	loadedAt time.Time
+}
+
+// Construct a new CachingRoleRepository in front of the given repository. All roles are loaded with a single FindAll call and served from memory until the ttl elapses (a ttl of zero or less caches roles until invalidated). Creates, updates and deletes are written through to the underlying repository and invalidate the cache.
+func NewCachingRoleRepository(repo RoleRepository, ttl time.Duration) CachingRoleRepository {
+	return &cachingRoleRepository{delegate: AdaptRoleRepository(repo), ttl: ttl, now: time.Now, lock: &sync.RWMutex{}}
+}
+
+func (this *cachingRoleRepository) FindAll() ([]Role, error) {
+	return this.FindAllContext(context.Background())
+}
+
+func (this *cachingRoleRepository) FindRole(roleName string) (Role, error) {
+	return this.FindRoleContext(context.Background(), roleName)
+}
+
+func (this *cachingRoleRepository) CreateRole(role Role) error {
+	return this.CreateRoleContext(context.Background(), role)
+}
+
+func (this *cachingRoleRepository) UpdateRole(role Role) error {
+	return this.UpdateRoleContext(context.Background(), role)
+}
+
+func (this *cachingRoleRepository) DeleteRole(roleName string) error {
+	return this.DeleteRoleContext(context.Background(), roleName)
+}
+
+func (this *cachingRoleRepository) FindAllContext(ctx context.Context) ([]Role, error) {
+	roles, _, err := this.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]Role, len(roles))
+	copy(ret, roles)
+	return ret, nil
+}
+
+func (this *cachingRoleRepository) FindRoleContext(ctx context.Context, roleName string) (Role, error) {
+	_, roleMap, err := this.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if role, ok := roleMap[roleName]; ok {
+		return role, nil
+	}
+	// the role may have been created elsewhere since the cache was loaded
+	return this.delegate.FindRoleContext(ctx, roleName)
+}
+
+func (this *cachingRoleRepository) CreateRoleContext(ctx context.Context, role Role) error {
+	defer this.Invalidate()
+	return this.delegate.CreateRoleContext(ctx, role)
+}
+
+func (this *cachingRoleRepository) UpdateRoleContext(ctx context.Context, role Role) error {
+	defer this.Invalidate()
+	return this.delegate.UpdateRoleContext(ctx, role)
+}
+
+func (this *cachingRoleRepository) DeleteRoleContext(ctx context.Context, roleName string) error {
+	defer this.Invalidate()
+	return this.delegate.DeleteRoleContext(ctx, roleName)
+}
+
+func (this *cachingRoleRepository) Invalidate() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.roles = nil
+	this.roleMap = nil
+}
+
+// returns the cached roles, reloading them from the underlying repository if they are missing or expired.
+func (this *cachingRoleRepository) load(ctx context.Context) ([]Role, map[string]Role, error) {
+	this.lock.RLock()
+	if this.isFresh() {
+		defer this.lock.RUnlock()
+		return this.roles, this.roleMap, nil
+	}
+	this.lock.RUnlock()
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	// another caller may have reloaded the roles while waiting for the lock
+	if this.isFresh() {
+		return this.roles, this.roleMap, nil
+	}
+	roles, err := this.delegate.FindAllContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	roleMap := make(map[string]Role, len(roles))
+	for _, role := range roles {
+		roleMap[role.GetName()] = role
+	}
+	this.roles = roles
+	this.roleMap = roleMap
+	this.loadedAt = this.now()
+	return roles, roleMap, nil
+}
+
+// must be called while holding the lock.
+func (this *cachingRoleRepository) isFresh() bool {
+	return this.roleMap != nil && (this.ttl <= 0 || this.now().Sub(this.loadedAt) < this.ttl)
+}
@@ -0,0 +1,110 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachingRoleRepositoryServesFromCache(t *testing.T) {
+	// given
+	mockRoleRepo := new(mockRoleRepository)
+	mockRoleRepo.On("FindAll").Return([]Role{NewRole("reader", 1), NewAdminRole("admin", 0)}, nil)
+	repo := NewCachingRoleRepository(mockRoleRepo, time.Minute)
+	aclService := NewAccessControlStrategy(nil, repo, true)
+	p := &mockPrincipal{id: "bob", sid: "bob", roleNames: []string{"reader"}}
+
+	// when
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, aclService.VerifyRoleAccess(p, 1))
+	}
+	role, err := repo.FindRole("admin")
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", role.GetName())
+	mockRoleRepo.AssertNumberOfCalls(t, "FindAll", 1)
+}
+
+func TestCachingRoleRepositoryExpiry(t *testing.T) {
+	// given
+	now := time.Now()
+	mockRoleRepo := new(mockRoleRepository)
+	mockRoleRepo.On("FindAll").Return([]Role{NewRole("reader", 1)}, nil)
+	repo := NewCachingRoleRepository(mockRoleRepo, time.Minute).(*cachingRoleRepository)
+	repo.now = func() time.Time { return now }
+	repo.FindAll()
+
+	// when
+	now = now.Add(59 * time.Second)
+	repo.FindAll()
+	now = now.Add(time.Second)
+	repo.FindAll()
+
+	// then
+	mockRoleRepo.AssertNumberOfCalls(t, "FindAll", 2)
+}
+
+func TestCachingRoleRepositoryWritesThrough(t *testing.T) {
+	// given
+	role := NewRole("reader", 1)
+	mockRoleRepo := new(mockRoleRepository)
+	mockRoleRepo.On("FindAll").Return([]Role{role}, nil)
+	mockRoleRepo.On("CreateRole", role).Return(nil)
+	mockRoleRepo.On("UpdateRole", role).Return(nil)
+	mockRoleRepo.On("DeleteRole", "reader").Return(errors.New("failed"))
+	repo := NewCachingRoleRepository(mockRoleRepo, 0)
+
+	// when
+	repo.FindAll()
+	createErr := repo.CreateRole(role)
+	repo.FindAll()
+	updateErr := repo.UpdateRole(role)
+	repo.FindAll()
+	deleteErr := repo.DeleteRole("reader")
+	repo.FindAll()
+	repo.FindAll()
+
+	// then
+	assert.Nil(t, createErr)
+	assert.Nil(t, updateErr)
+	assert.NotNil(t, deleteErr)
+	mockRoleRepo.AssertExpectations(t)
+	mockRoleRepo.AssertNumberOfCalls(t, "FindAll", 4)
+}
+
+func TestCachingRoleRepositoryInvalidate(t *testing.T) {
+	// given
+	mockRoleRepo := new(mockRoleRepository)
+	mockRoleRepo.On("FindAll").Return([]Role{}, errors.New("failed")).Once()
+	mockRoleRepo.On("FindAll").Return([]Role{NewRole("reader", 1)}, nil)
+	repo := NewCachingRoleRepository(mockRoleRepo, 0)
+
+	// when
+	_, failed := repo.FindAll()
+	roles, err := repo.FindAll()
+	repo.Invalidate()
+	repo.FindAll()
+
+	// then
+	assert.NotNil(t, failed)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(roles))
+	mockRoleRepo.AssertNumberOfCalls(t, "FindAll", 3)
+}
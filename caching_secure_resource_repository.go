@@ -0,0 +1,196 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A SecureResourceRepository that caches the resources of another repository.
+type CachingSecureResourceRepository interface {
+	ContextSecureResourceRepository
+	// Discards the cached resource along with every cached resource that inherits from it.
+	Invalidate(nativeResourceId string)
+	// Discards all cached resources.
+	InvalidateAll()
+}
+
+// a cached resource, including its hydrated ACL and parent chain
+type cachedResource struct {
+	resource    SecureResource
+	ancestorIds []string
+	loadedAt    time.Time
+}
+
+type cachingSecureResourceRepository struct {
+	delegate ContextSecureResourceRepository
+	ttl      time.Duration
+	now      func() time.Time
+	lock     *sync.Mutex
+	cache    map[string]*cachedResource
+	// native ids of the cached resources whose parent chain includes the keyed resource
+	descendants map[string]map[string]bool
+	// incremented on every invalidation so that lookups racing with a write do not cache stale resources
+	generation uint64
+}
+
+// Construct a new CachingSecureResourceRepository in front of the given repository. Found resources, along with their ACLs and parent chains, are served from memory until the ttl elapses (a ttl of zero or less caches resources until invalidated). Updates and deletes are written through to the underlying repository and invalidate the resource and all of its cached descendants. Resources are copied when cached and again when served, so changes to a found resource or its ACL are only visible after calling UpdateResource. ACLs implemented outside of nogo are shared rather than copied, and must not be changed in place.
+func NewCachingSecureResourceRepository(repo SecureResourceRepository, ttl time.Duration) CachingSecureResourceRepository {
+	return &cachingSecureResourceRepository{
+		delegate:    AdaptSecureResourceRepository(repo),
+		ttl:         ttl,
+		now:         time.Now,
+		lock:        &sync.Mutex{},
+		cache:       make(map[string]*cachedResource),
+		descendants: make(map[string]map[string]bool),
+	}
+}
+
+func (this *cachingSecureResourceRepository) FindResource(nativeResourceId string) (SecureResource, error) {
+	return this.FindResourceContext(context.Background(), nativeResourceId)
+}
+
+func (this *cachingSecureResourceRepository) CreateResource(resource SecureResource) error {
+	return this.CreateResourceContext(context.Background(), resource)
+}
+
+func (this *cachingSecureResourceRepository) UpdateResource(resource SecureResource) error {
+	return this.UpdateResourceContext(context.Background(), resource)
+}
+
+func (this *cachingSecureResourceRepository) DeleteResource(nativeResourceId string) error {
+	return this.DeleteResourceContext(context.Background(), nativeResourceId)
+}
+
+func (this *cachingSecureResourceRepository) FindResourceContext(ctx context.Context, nativeResourceId string) (SecureResource, error) {
+	this.lock.Lock()
+	if cached, ok := this.cache[nativeResourceId]; ok {
+		if this.ttl <= 0 || this.now().Sub(cached.loadedAt) < this.ttl {
+			this.lock.Unlock()
+			return cloneResource(cached.resource)
+		}
+		this.invalidate(nativeResourceId)
+	}
+	generation := this.generation
+	this.lock.Unlock()
+
+	resource, err := this.delegate.FindResourceContext(ctx, nativeResourceId)
+	if err != nil || resource == nil {
+		return resource, err
+	}
+	snapshot, err := cloneResource(resource)
+	if err != nil {
+		return nil, err
+	}
+	cached := &cachedResource{resource: snapshot, loadedAt: this.now()}
+	for parent := resource.GetParentResource(); parent != nil; parent = parent.GetParentResource() {
+		cached.ancestorIds = append(cached.ancestorIds, parent.GetNativeId())
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if generation != this.generation {
+		return resource, nil
+	}
+	this.invalidate(nativeResourceId)
+	this.cache[nativeResourceId] = cached
+	for _, ancestorId := range cached.ancestorIds {
+		if this.descendants[ancestorId] == nil {
+			this.descendants[ancestorId] = make(map[string]bool)
+		}
+		this.descendants[ancestorId][nativeResourceId] = true
+	}
+	return resource, nil
+}
+
+func (this *cachingSecureResourceRepository) CreateResourceContext(ctx context.Context, resource SecureResource) error {
+	return this.delegate.CreateResourceContext(ctx, resource)
+}
+
+func (this *cachingSecureResourceRepository) UpdateResourceContext(ctx context.Context, resource SecureResource) error {
+	defer this.Invalidate(resource.GetNativeId())
+	return this.delegate.UpdateResourceContext(ctx, resource)
+}
+
+func (this *cachingSecureResourceRepository) DeleteResourceContext(ctx context.Context, nativeResourceId string) error {
+	defer this.Invalidate(nativeResourceId)
+	return this.delegate.DeleteResourceContext(ctx, nativeResourceId)
+}
+
+func (this *cachingSecureResourceRepository) Invalidate(nativeResourceId string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.generation++
+	this.invalidate(nativeResourceId)
+	for descendantId := range this.descendants[nativeResourceId] {
+		this.invalidate(descendantId)
+	}
+	delete(this.descendants, nativeResourceId)
+}
+
+func (this *cachingSecureResourceRepository) InvalidateAll() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.generation++
+	this.cache = make(map[string]*cachedResource)
+	this.descendants = make(map[string]map[string]bool)
+}
+
+// removes a single cached resource and its entries in the descendant index. Must be called while holding the lock.
+func (this *cachingSecureResourceRepository) invalidate(nativeResourceId string) {
+	cached, ok := this.cache[nativeResourceId]
+	if !ok {
+		return
+	}
+	delete(this.cache, nativeResourceId)
+	for _, ancestorId := range cached.ancestorIds {
+		delete(this.descendants[ancestorId], nativeResourceId)
+		if len(this.descendants[ancestorId]) == 0 {
+			delete(this.descendants, ancestorId)
+		}
+	}
+}
+
+// copies the resource, its ACL and its parent chain so that the copy can be changed without affecting the original. Entries are shared rather than copied, keeping any optional interfaces they implement. Only nogo's own ACLs are copied; other ACL implementations are shared as-is so that their behaviour is preserved, and must not be changed in place.
+func cloneResource(resource SecureResource) (SecureResource, error) {
+	if resource == nil {
+		return nil, nil
+	}
+	acl, err := resource.GetACL()
+	if err != nil {
+		return nil, err
+	}
+	clone := &defaultSecureResource{nativeId: resource.GetNativeId(), ownerSid: resource.GetOwnerSid(), inheritParentACL: resource.InheritsParentACL(), acl: acl}
+	if acl == nil {
+		clone.acl = NewACL()
+	} else if _, ok := acl.(*defaultACL); ok {
+		clone.acl = NewACL()
+		aces, err := acl.GetACEs()
+		if err != nil {
+			return nil, err
+		}
+		for _, ace := range aces {
+			if err := clone.acl.AddACE(ace); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if clone.parent, err = cloneResource(resource.GetParentResource()); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
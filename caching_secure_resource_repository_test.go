@@ -0,0 +1,182 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachingResourceRepositoryServesFromCache(t *testing.T) {
+	// given
+	repo, counter := newCachingHierarchy(t, 0)
+	aclService := NewAccessControlStrategy(repo, nil, false)
+	p := &mockPrincipal{id: "bob", sid: "bob"}
+
+	// when
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, aclService.VerifyResourceAccessById(p, 1, "child"))
+	}
+
+	// then
+	assert.Equal(t, 1, counter.finds["child"])
+}
+
+func TestCachingResourceRepositoryInvalidatesDescendants(t *testing.T) {
+	// given
+	repo, counter := newCachingHierarchy(t, 0)
+	aclService := NewAccessControlStrategy(repo, nil, false)
+	p := &mockPrincipal{id: "bob", sid: "bob"}
+	repo.FindResource("parent")
+	repo.FindResource("child")
+	repo.FindResource("sibling")
+
+	// when
+	root := NewSecureResource("root", "owner", nil, false)
+	err := repo.UpdateResource(root)
+
+	// then
+	assert.Nil(t, err)
+	assert.NotNil(t, aclService.VerifyResourceAccessById(p, 1, "child"))
+	repo.FindResource("parent")
+	repo.FindResource("sibling")
+	assert.Equal(t, 2, counter.finds["child"])
+	assert.Equal(t, 2, counter.finds["parent"])
+	assert.Equal(t, 2, counter.finds["sibling"])
+}
+
+func TestCachingResourceRepositoryInheritanceChange(t *testing.T) {
+	// given
+	repo, counter := newCachingHierarchy(t, 0)
+	aclService := NewAccessControlStrategy(repo, nil, false)
+	p := &mockPrincipal{id: "bob", sid: "bob"}
+	assert.Nil(t, aclService.VerifyResourceAccessById(p, 1, "child"))
+	root, _ := repo.FindResource("root")
+
+	// when
+	err := repo.UpdateResource(NewSecureResource("parent", "owner", root, false))
+
+	// then
+	assert.Nil(t, err)
+	assert.NotNil(t, aclService.VerifyResourceAccessById(p, 1, "child"))
+	assert.Equal(t, 2, counter.finds["child"])
+}
+
+func TestCachingResourceRepositoryExpiryAndDeletion(t *testing.T) {
+	// given
+	now := time.Now()
+	repo, counter := newCachingHierarchy(t, time.Minute)
+	repo.(*cachingSecureResourceRepository).now = func() time.Time { return now }
+	repo.FindResource("sibling")
+
+	// when
+	repo.FindResource("sibling")
+	now = now.Add(time.Minute)
+	repo.FindResource("sibling")
+	err := repo.DeleteResource("sibling")
+	found, findErr := repo.FindResource("sibling")
+
+	// then
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+	assert.True(t, errors.Is(findErr, ErrResourceNotFound))
+	assert.Equal(t, 3, counter.finds["sibling"])
+}
+
+func TestCachingResourceRepositoryCopiesResources(t *testing.T) {
+	// given
+	repo, _ := newCachingHierarchy(t, 0)
+	history := NewMapBackedChangeHistory()
+	tracking := NewChangeTrackingSecureResourceRepository(repo, history)
+	repo.FindResource("child")
+	found, _ := tracking.FindResource("child")
+	acl, _ := found.GetACL()
+	acl.AddACE(NewACE("alice", 1))
+
+	// when
+	cached, _ := repo.FindResource("child")
+	err := tracking.UpdateResource(found)
+
+	// then
+	cachedACL, _ := cached.GetACL()
+	cachedACE, _ := cachedACL.GetACEForSid("alice")
+	assert.Nil(t, cachedACE)
+	assert.Nil(t, err)
+	changes, _ := history.FindChanges(context.Background(), ChangeQuery{Operations: []ChangeOperation{AddACEChange}})
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, "alice", changes[0].Sid)
+	updated, _ := repo.FindResource("child")
+	updatedACL, _ := updated.GetACL()
+	updatedACE, _ := updatedACL.GetACEForSid("alice")
+	assert.NotNil(t, updatedACE)
+}
+
+func TestCachingResourceRepositoryPreservesCustomACLs(t *testing.T) {
+	// given
+	read := Permission(1)
+	expired := NewTimeBoundACE("alice", read, time.Time{}, time.Now().Add(-time.Hour))
+	parentACL := NewACL()
+	parentACL.AddACE(NewACE(WorldSid, read))
+	parentACL.AddACE(expired)
+	parent := &defaultSecureResource{nativeId: "folder", acl: parentACL}
+	resource := &defaultSecureResource{nativeId: "document", parent: parent, inheritParentACL: true, acl: &allowOnlyACL{ace: NewDenyACE("bob", read)}}
+	delegate := new(mockSecureResourceRepository)
+	delegate.On("FindResource", "document").Return(resource, nil).Once()
+	repo := NewCachingSecureResourceRepository(delegate, 0)
+	strategy := NewAccessControlStrategy(nil, NewMapBackedRoleRepository(), false)
+	repo.FindResource("document")
+
+	// when
+	cached, err := repo.FindResource("document")
+
+	// then
+	assert.Nil(t, err)
+	delegate.AssertExpectations(t)
+	acl, _ := cached.GetACL()
+	assert.IsType(t, &allowOnlyACL{}, acl)
+	assert.True(t, errors.Is(strategy.VerifyResourceAccess(&mockPrincipal{id: "bob", sid: "bob"}, read, cached), ErrAccessDenied))
+	cachedParentACL, _ := cached.GetParentResource().GetACL()
+	ace, _ := cachedParentACL.GetACEForSid("alice")
+	assert.Same(t, expired, ace)
+}
+
+// creates root <- parent <- child and root <- sibling, granting bob read access on root
+func newCachingHierarchy(t *testing.T, ttl time.Duration) (CachingSecureResourceRepository, *countingResourceRepository) {
+	counter := &countingResourceRepository{SecureResourceRepository: NewMapBackedSecureResourceRepository(), finds: make(map[string]int)}
+	root := NewSecureResource("root", "owner", nil, false)
+	acl, _ := root.GetACL()
+	acl.AddACE(NewACE("bob", 1))
+	parent := NewSecureResource("parent", "owner", root, true)
+	assert.Nil(t, counter.CreateResource(root))
+	assert.Nil(t, counter.CreateResource(parent))
+	assert.Nil(t, counter.CreateResource(NewSecureResource("child", "owner", parent, true)))
+	assert.Nil(t, counter.CreateResource(NewSecureResource("sibling", "owner", root, true)))
+	return NewCachingSecureResourceRepository(counter, ttl), counter
+}
+
+// counts the lookups made against a repository
+type countingResourceRepository struct {
+	SecureResourceRepository
+	finds map[string]int
+}
+
+func (this *countingResourceRepository) FindResource(nativeResourceId string) (SecureResource, error) {
+	this.finds[nativeResourceId]++
+	return this.SecureResourceRepository.FindResource(nativeResourceId)
+}
@@ -0,0 +1,238 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Identifies an administrative change recorded in a ChangeHistory.
+type ChangeOperation string
+
+const (
+	CreateRoleChange     ChangeOperation = "CreateRole"
+	UpdateRoleChange     ChangeOperation = "UpdateRole"
+	DeleteRoleChange     ChangeOperation = "DeleteRole"
+	CreateResourceChange ChangeOperation = "CreateResource"
+	UpdateResourceChange ChangeOperation = "UpdateResource"
+	DeleteResourceChange ChangeOperation = "DeleteResource"
+	// An entry was added to a resource's ACL, either when the resource was created or updated.
+	AddACEChange ChangeOperation = "AddACE"
+	// An entry was removed from a resource's ACL, either when the resource was updated or deleted. Changing the permissions of an entry is recorded as the removal of the old entry followed by the addition of the new one.
+	RemoveACEChange ChangeOperation = "RemoveACE"
+)
+
+// A record of a single administrative change. Before is nil for creations and additions, and After is nil for deletions and removals.
+type ChangeRecord struct {
+	// The time the change was made.
+	Timestamp time.Time
+	// The actor who made the change, as passed with WithActor. Empty if unknown.
+	Actor string
+	// The kind of change.
+	Operation ChangeOperation
+	// The name of the changed role. Empty for resource and entry changes.
+	RoleName string
+	// The native id of the changed resource. Empty for role changes.
+	ResourceId string
+	// The sid of the changed entry. Empty for role and resource changes.
+	Sid string
+	// The state before the change.
+	Before *ChangeState
+	// The state after the change.
+	After *ChangeState
+}
+
+// A snapshot of a role, resource or entry, depending on the operation of the change record.
+type ChangeState struct {
+	Role     *RoleState     `json:"role,omitempty"`
+	Resource *ResourceState `json:"resource,omitempty"`
+	ACE      *ACEState      `json:"ace,omitempty"`
+}
+
+// A snapshot of a role.
+type RoleState struct {
+	Name            string     `json:"name"`
+	Permissions     Permission `json:"permissions"`
+	Admin           bool       `json:"admin"`
+	ParentRoleNames []string   `json:"parent_role_names,omitempty"`
+}
+
+// A snapshot of a secure resource and its ACL.
+type ResourceState struct {
+	NativeId         string     `json:"native_id"`
+	ParentId         string     `json:"parent_id,omitempty"`
+	OwnerSid         string     `json:"owner_sid"`
+	InheritParentACL bool       `json:"inherit_parent_acl"`
+	ACEs             []ACEState `json:"aces"`
+}
+
+// A snapshot of an access control entry. Zero times leave the window of a time bound entry open.
+type ACEState struct {
+	Sid         string     `json:"sid"`
+	Permissions Permission `json:"permissions"`
+	Deny        bool       `json:"deny"`
+	NotBefore   time.Time  `json:"not_before"`
+	NotAfter    time.Time  `json:"not_after"`
+}
+
+// Selects change records. Empty fields match all records.
+type ChangeQuery struct {
+	Operations []ChangeOperation
+	Actor      string
+	RoleName   string
+	ResourceId string
+	Sid        string
+	// Only matches entry changes whose entry includes any of the permissions.
+	Permission Permission
+	// Only matches changes made at or after this time.
+	Since time.Time
+	// Only matches changes made before this time.
+	Until time.Time
+	// The maximum number of records returned. Zero or less returns all matching records.
+	Limit int
+}
+
+// An append-only history of administrative changes, answering questions such as "who gave Bob Delete on project X and when".
+type ChangeHistory interface {
+	// Appends the record to the history. Returns an error if the record could not be stored.
+	AppendChange(ctx context.Context, record *ChangeRecord) error
+	// Returns the records matching the query, oldest first. May return an empty value. Returns an error if the records could not be retrieved.
+	FindChanges(ctx context.Context, query ChangeQuery) ([]*ChangeRecord, error)
+}
+
+// A ChangeHistory that can append several records at once, used by change tracking repositories so that a change and the entry changes it implies are recorded together.
+type BatchChangeHistory interface {
+	ChangeHistory
+	// Appends the records to the history in order. Either every record is stored or none is. Returns an error if the records could not be stored.
+	AppendChanges(ctx context.Context, records []*ChangeRecord) error
+}
+
+// appends the records using AppendChanges if the history supports it, otherwise one at a time.
+func appendChanges(ctx context.Context, history ChangeHistory, records []*ChangeRecord) error {
+	if batch, ok := history.(BatchChangeHistory); ok {
+		return batch.AppendChanges(ctx, records)
+	}
+	for _, record := range records {
+		if err := history.AppendChange(ctx, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type actorContextKey struct{}
+
+// Returns a context carrying the actor recorded with changes made through change tracking repositories.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+// Returns the actor stored by WithActor, or an empty value if the context does not carry one.
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorContextKey{}).(string)
+	return actor
+}
+
+// Returns true if the record matches the query, ignoring its limit.
+func (this *ChangeQuery) matches(record *ChangeRecord) bool {
+	if len(this.Operations) > 0 {
+		found := false
+		for _, operation := range this.Operations {
+			if operation == record.Operation {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if (this.Actor != "" && this.Actor != record.Actor) ||
+		(this.RoleName != "" && this.RoleName != record.RoleName) ||
+		(this.ResourceId != "" && this.ResourceId != record.ResourceId) ||
+		(this.Sid != "" && this.Sid != record.Sid) {
+		return false
+	}
+	if this.Permission != EmptyPermissionMask && record.changedACE().Permissions&this.Permission == 0 {
+		return false
+	}
+	if record.Timestamp.Before(this.Since) || (!this.Until.IsZero() && !record.Timestamp.Before(this.Until)) {
+		return false
+	}
+	return true
+}
+
+// returns the entry added or removed by the change, or an empty entry for role and resource changes.
+func (this *ChangeRecord) changedACE() ACEState {
+	for _, state := range []*ChangeState{this.After, this.Before} {
+		if state != nil && state.ACE != nil {
+			return *state.ACE
+		}
+	}
+	return ACEState{}
+}
+
+// Returns a snapshot of the role, probing each permission as the Role interface does not expose its mask.
+func newRoleState(role Role) (*RoleState, error) {
+	state := &RoleState{Name: role.GetName(), Admin: role.IsAdmin(), ParentRoleNames: parentRoleNames(role)}
+	for i := 0; i < MaxPermissions; i++ {
+		permission := Permission(1) << uint(i)
+		if granted, err := role.HasPermission(permission); err != nil {
+			return nil, err
+		} else if granted {
+			state.Permissions |= permission
+		}
+	}
+	return state, nil
+}
+
+// Returns a snapshot of the resource, with its entries ordered by sid and deny entries after allow entries.
+func newResourceState(resource SecureResource) (*ResourceState, error) {
+	state := &ResourceState{NativeId: resource.GetNativeId(), OwnerSid: resource.GetOwnerSid(), InheritParentACL: resource.InheritsParentACL(), ACEs: make([]ACEState, 0)}
+	if parent := resource.GetParentResource(); parent != nil {
+		state.ParentId = parent.GetNativeId()
+	}
+	acl, err := resource.GetACL()
+	if err != nil {
+		return nil, err
+	}
+	if acl == nil {
+		return state, nil
+	}
+	aces, err := acl.GetACEs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ace := range aces {
+		aceState := ACEState{Sid: ace.GetSid(), Deny: isDenyACE(ace)}
+		for _, permission := range ace.GetPermissions() {
+			aceState.Permissions |= permission
+		}
+		if timeBound, ok := ace.(TimeBoundACE); ok {
+			aceState.NotBefore = timeBound.GetNotBefore()
+			aceState.NotAfter = timeBound.GetNotAfter()
+		}
+		state.ACEs = append(state.ACEs, aceState)
+	}
+	sort.Slice(state.ACEs, func(i, j int) bool {
+		if state.ACEs[i].Sid != state.ACEs[j].Sid {
+			return state.ACEs[i].Sid < state.ACEs[j].Sid
+		}
+		return !state.ACEs[i].Deny && state.ACEs[j].Deny
+	})
+	return state, nil
+}
@@ -0,0 +1,224 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type changeTrackingRoleRepository struct {
+	ContextRoleRepository
+	history ChangeHistory
+	now     func() time.Time
+}
+
+// Construct a RoleRepository that records every role created, updated or deleted through it in the history, along with the actor carried by the context (see WithActor) and the state of the role before and after the change. A change is recorded once the underlying repository has made it; if the record could not be stored the change is kept and a ChangeNotRecordedError is returned. To record changes atomically with the writes, back the repository and the history with the same *sqlx.Tx.
+func NewChangeTrackingRoleRepository(repo RoleRepository, history ChangeHistory) ContextRoleRepository {
+	return &changeTrackingRoleRepository{ContextRoleRepository: AdaptRoleRepository(repo), history: history, now: time.Now}
+}
+
+func (this *changeTrackingRoleRepository) CreateRole(role Role) error {
+	return this.CreateRoleContext(context.Background(), role)
+}
+
+func (this *changeTrackingRoleRepository) UpdateRole(role Role) error {
+	return this.UpdateRoleContext(context.Background(), role)
+}
+
+func (this *changeTrackingRoleRepository) DeleteRole(roleName string) error {
+	return this.DeleteRoleContext(context.Background(), roleName)
+}
+
+func (this *changeTrackingRoleRepository) CreateRoleContext(ctx context.Context, role Role) error {
+	after, err := newRoleState(role)
+	if err != nil {
+		return err
+	}
+	if err = this.ContextRoleRepository.CreateRoleContext(ctx, role); err != nil {
+		return err
+	}
+	return this.append(ctx, CreateRoleChange, role.GetName(), nil, after)
+}
+
+func (this *changeTrackingRoleRepository) UpdateRoleContext(ctx context.Context, role Role) error {
+	before, err := this.findRoleState(ctx, role.GetName())
+	if err != nil {
+		return err
+	}
+	after, err := newRoleState(role)
+	if err != nil {
+		return err
+	}
+	if err = this.ContextRoleRepository.UpdateRoleContext(ctx, role); err != nil {
+		return err
+	}
+	return this.append(ctx, UpdateRoleChange, role.GetName(), before, after)
+}
+
+func (this *changeTrackingRoleRepository) DeleteRoleContext(ctx context.Context, roleName string) error {
+	before, err := this.findRoleState(ctx, roleName)
+	if err != nil {
+		return err
+	}
+	if err = this.ContextRoleRepository.DeleteRoleContext(ctx, roleName); err != nil {
+		return err
+	}
+	return this.append(ctx, DeleteRoleChange, roleName, before, nil)
+}
+
+// returns a snapshot of the stored role, or nil if it does not exist.
+func (this *changeTrackingRoleRepository) findRoleState(ctx context.Context, roleName string) (*RoleState, error) {
+	role, err := this.FindRoleContext(ctx, roleName)
+	if err != nil || role == nil {
+		return nil, err
+	}
+	return newRoleState(role)
+}
+
+func (this *changeTrackingRoleRepository) append(ctx context.Context, operation ChangeOperation, roleName string, before *RoleState, after *RoleState) error {
+	record := &ChangeRecord{Timestamp: this.now(), Actor: ActorFromContext(ctx), Operation: operation, RoleName: roleName}
+	if before != nil {
+		record.Before = &ChangeState{Role: before}
+	}
+	if after != nil {
+		record.After = &ChangeState{Role: after}
+	}
+	if err := this.history.AppendChange(ctx, record); err != nil {
+		return &ChangeNotRecordedError{Operation: operation, Err: err}
+	}
+	return nil
+}
+
+type changeTrackingSecureResourceRepository struct {
+	ContextSecureResourceRepository
+	history ChangeHistory
+	now     func() time.Time
+}
+
+// Construct a SecureResourceRepository that records every resource created, updated or deleted through it in the history, along with the actor carried by the context (see WithActor) and the state of the resource before and after the change. Each entry added to or removed from a resource's ACL is also recorded as a separate AddACE or RemoveACE change. Changes are recorded once the underlying repository has made them, together if the history is a BatchChangeHistory; if they could not be stored the change is kept and a ChangeNotRecordedError is returned. To record changes atomically with the writes, back the repository and the history with the same *sqlx.Tx.
+func NewChangeTrackingSecureResourceRepository(repo SecureResourceRepository, history ChangeHistory) ContextSecureResourceRepository {
+	return &changeTrackingSecureResourceRepository{ContextSecureResourceRepository: AdaptSecureResourceRepository(repo), history: history, now: time.Now}
+}
+
+func (this *changeTrackingSecureResourceRepository) CreateResource(resource SecureResource) error {
+	return this.CreateResourceContext(context.Background(), resource)
+}
+
+func (this *changeTrackingSecureResourceRepository) UpdateResource(resource SecureResource) error {
+	return this.UpdateResourceContext(context.Background(), resource)
+}
+
+func (this *changeTrackingSecureResourceRepository) DeleteResource(nativeResourceId string) error {
+	return this.DeleteResourceContext(context.Background(), nativeResourceId)
+}
+
+func (this *changeTrackingSecureResourceRepository) CreateResourceContext(ctx context.Context, resource SecureResource) error {
+	after, err := newResourceState(resource)
+	if err != nil {
+		return err
+	}
+	if err = this.ContextSecureResourceRepository.CreateResourceContext(ctx, resource); err != nil {
+		return err
+	}
+	return this.append(ctx, CreateResourceChange, nil, after)
+}
+
+func (this *changeTrackingSecureResourceRepository) UpdateResourceContext(ctx context.Context, resource SecureResource) error {
+	before, err := this.findResourceState(ctx, resource.GetNativeId())
+	if err != nil {
+		return err
+	}
+	after, err := newResourceState(resource)
+	if err != nil {
+		return err
+	}
+	if err = this.ContextSecureResourceRepository.UpdateResourceContext(ctx, resource); err != nil {
+		return err
+	}
+	return this.append(ctx, UpdateResourceChange, before, after)
+}
+
+func (this *changeTrackingSecureResourceRepository) DeleteResourceContext(ctx context.Context, nativeResourceId string) error {
+	before, err := this.findResourceState(ctx, nativeResourceId)
+	if err != nil {
+		return err
+	}
+	if err = this.ContextSecureResourceRepository.DeleteResourceContext(ctx, nativeResourceId); err != nil {
+		return err
+	}
+	return this.append(ctx, DeleteResourceChange, before, nil)
+}
+
+// returns a snapshot of the stored resource, or nil if it does not exist.
+func (this *changeTrackingSecureResourceRepository) findResourceState(ctx context.Context, nativeResourceId string) (*ResourceState, error) {
+	resource, err := this.FindResourceContext(ctx, nativeResourceId)
+	if errors.Is(err, ErrResourceNotFound) {
+		return nil, nil
+	}
+	if err != nil || resource == nil {
+		return nil, err
+	}
+	return newResourceState(resource)
+}
+
+// appends the resource change followed by a change for each entry removed from and added to its ACL.
+func (this *changeTrackingSecureResourceRepository) append(ctx context.Context, operation ChangeOperation, before *ResourceState, after *ResourceState) error {
+	record := &ChangeRecord{Timestamp: this.now(), Actor: ActorFromContext(ctx), Operation: operation}
+	beforeACEs, afterACEs := []ACEState{}, []ACEState{}
+	if before != nil {
+		record.ResourceId = before.NativeId
+		record.Before = &ChangeState{Resource: before}
+		beforeACEs = before.ACEs
+	}
+	if after != nil {
+		record.ResourceId = after.NativeId
+		record.After = &ChangeState{Resource: after}
+		afterACEs = after.ACEs
+	}
+	records := []*ChangeRecord{record}
+	for _, ace := range diffACEs(beforeACEs, afterACEs) {
+		aceRecord := &ChangeRecord{Timestamp: record.Timestamp, Actor: record.Actor, Operation: RemoveACEChange, ResourceId: record.ResourceId, Sid: ace.Sid, Before: &ChangeState{ACE: ace}}
+		records = append(records, aceRecord)
+	}
+	for _, ace := range diffACEs(afterACEs, beforeACEs) {
+		aceRecord := &ChangeRecord{Timestamp: record.Timestamp, Actor: record.Actor, Operation: AddACEChange, ResourceId: record.ResourceId, Sid: ace.Sid, After: &ChangeState{ACE: ace}}
+		records = append(records, aceRecord)
+	}
+	if err := appendChanges(ctx, this.history, records); err != nil {
+		return &ChangeNotRecordedError{Operation: operation, Err: err}
+	}
+	return nil
+}
+
+// returns the entries of from that are not in to.
+func diffACEs(from []ACEState, to []ACEState) []*ACEState {
+	ret := make([]*ACEState, 0)
+	for i := range from {
+		found := false
+		for j := range to {
+			if from[i].Sid == to[j].Sid && from[i].Deny == to[j].Deny && from[i].Permissions == to[j].Permissions &&
+				from[i].NotBefore.Equal(to[j].NotBefore) && from[i].NotAfter.Equal(to[j].NotAfter) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ret = append(ret, &from[i])
+		}
+	}
+	return ret
+}
@@ -0,0 +1,181 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangeTrackingRoleRepository(t *testing.T) {
+	// given
+	history := NewMapBackedChangeHistory()
+	repo := NewChangeTrackingRoleRepository(NewMapBackedRoleRepository(), history)
+	ctx := WithActor(context.Background(), "alice")
+	repo.CreateRole(NewRole("base", 4))
+
+	// when
+	repo.CreateRoleContext(ctx, NewRole("reader", 1))
+	repo.UpdateRoleContext(ctx, NewHierarchicalRole("reader", 3, "base"))
+	failedErr := repo.UpdateRoleContext(ctx, NewRole("missing", 1))
+
+	// then
+	assert.NotNil(t, failedErr)
+	records, err := history.FindChanges(context.Background(), ChangeQuery{RoleName: "reader"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, CreateRoleChange, records[0].Operation)
+	assert.Equal(t, "alice", records[0].Actor)
+	assert.Nil(t, records[0].Before)
+	assert.Equal(t, &RoleState{Name: "reader", Permissions: 1}, records[0].After.Role)
+	assert.Equal(t, UpdateRoleChange, records[1].Operation)
+	assert.Equal(t, &RoleState{Name: "reader", Permissions: 1}, records[1].Before.Role)
+	assert.Equal(t, &RoleState{Name: "reader", Permissions: 3, ParentRoleNames: []string{"base"}}, records[1].After.Role)
+	all, _ := history.FindChanges(context.Background(), ChangeQuery{})
+	assert.Equal(t, 3, len(all))
+	assert.Equal(t, "", all[0].Actor)
+}
+
+func TestChangeTrackingResourceRepository(t *testing.T) {
+	// given
+	read := Permission(1)
+	remove := Permission(4)
+	history := NewMapBackedChangeHistory()
+	repo := NewChangeTrackingSecureResourceRepository(NewMapBackedSecureResourceRepository(), history).(*changeTrackingSecureResourceRepository)
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo.now = func() time.Time { return at }
+	project := NewSecureResource("project", "owner", nil, false)
+	acl, _ := project.GetACL()
+	acl.AddACE(NewACE("bob", read))
+	acl.AddACE(NewACE("carol", read))
+
+	// when
+	repo.CreateResourceContext(WithActor(context.Background(), "alice"), project)
+	at = at.Add(time.Hour)
+	stored, _ := repo.FindResource("project")
+	acl, _ = stored.GetACL()
+	bob, _ := acl.GetACEForSid("bob")
+	acl.RemoveACE(bob)
+	acl.AddACE(NewACE("bob", read|remove))
+	repo.UpdateResourceContext(WithActor(context.Background(), "mallory"), stored)
+	at = at.Add(time.Hour)
+	repo.DeleteResourceContext(WithActor(context.Background(), "alice"), "project")
+
+	// then
+	records, _ := history.FindChanges(context.Background(), ChangeQuery{})
+	operations := make([]ChangeOperation, 0)
+	for _, record := range records {
+		operations = append(operations, record.Operation)
+	}
+	assert.Equal(t, []ChangeOperation{
+		CreateResourceChange, AddACEChange, AddACEChange,
+		UpdateResourceChange, RemoveACEChange, AddACEChange,
+		DeleteResourceChange, RemoveACEChange, RemoveACEChange,
+	}, operations)
+	assert.Equal(t, 2, len(records[3].Before.Resource.ACEs))
+	assert.Equal(t, Permission(read|remove), records[3].After.Resource.ACEs[0].Permissions)
+
+	// who gave bob Delete on the project, and when
+	granted, err := history.FindChanges(context.Background(), ChangeQuery{Operations: []ChangeOperation{AddACEChange}, ResourceId: "project", Sid: "bob", Permission: remove})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(granted))
+	assert.Equal(t, "mallory", granted[0].Actor)
+	assert.Equal(t, time.Date(2020, 1, 1, 1, 0, 0, 0, time.UTC), granted[0].Timestamp)
+	assert.Equal(t, &ACEState{Sid: "bob", Permissions: read | remove}, granted[0].After.ACE)
+	assert.Nil(t, granted[0].Before)
+}
+
+func TestChangeQuery(t *testing.T) {
+	// given
+	history := NewMapBackedChangeHistory()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, actor := range []string{"alice", "bob", "alice", "carol"} {
+		history.AppendChange(context.Background(), &ChangeRecord{Timestamp: start.Add(time.Duration(i) * time.Hour), Actor: actor, Operation: AddACEChange, ResourceId: "doc", Sid: "sid", After: &ChangeState{ACE: &ACEState{Sid: "sid", Permissions: Permission(1 << uint(i))}}})
+	}
+
+	// when
+	byActor, _ := history.FindChanges(context.Background(), ChangeQuery{Actor: "alice"})
+	byPermission, _ := history.FindChanges(context.Background(), ChangeQuery{Permission: 2 | 8})
+	byTime, _ := history.FindChanges(context.Background(), ChangeQuery{Since: start.Add(time.Hour), Until: start.Add(3 * time.Hour)})
+	limited, _ := history.FindChanges(context.Background(), ChangeQuery{Limit: 3})
+	byOperation, _ := history.FindChanges(context.Background(), ChangeQuery{Operations: []ChangeOperation{RemoveACEChange}})
+
+	// then
+	assert.Equal(t, 2, len(byActor))
+	assert.Equal(t, []string{"bob", "carol"}, []string{byPermission[0].Actor, byPermission[1].Actor})
+	assert.Equal(t, []string{"bob", "alice"}, []string{byTime[0].Actor, byTime[1].Actor})
+	assert.Equal(t, 3, len(limited))
+	assert.Equal(t, 0, len(byOperation))
+}
+
+func TestChangeTrackingReportsUnrecordedChanges(t *testing.T) {
+	// given
+	history := &failingChangeHistory{ChangeHistory: NewMapBackedChangeHistory(), err: errors.New("unavailable")}
+	resources := NewMapBackedSecureResourceRepository()
+	repo := NewChangeTrackingSecureResourceRepository(resources, history)
+	roles := NewChangeTrackingRoleRepository(NewMapBackedRoleRepository(), history)
+	project := NewSecureResource("project", "owner", nil, false)
+	acl, _ := project.GetACL()
+	acl.AddACE(NewACE("bob", 1))
+
+	// when
+	err := repo.CreateResource(project)
+	roleErr := roles.CreateRole(NewRole("reader", 1))
+
+	// then
+	assert.True(t, errors.Is(err, ErrChangeNotRecorded))
+	assert.True(t, errors.Is(err, history.err))
+	assert.Equal(t, "The CreateResource change was made but could not be recorded: unavailable", err.Error())
+	assert.True(t, errors.Is(roleErr, ErrChangeNotRecorded))
+	created, _ := resources.FindResource("project")
+	assert.NotNil(t, created)
+	records, _ := history.FindChanges(context.Background(), ChangeQuery{})
+	assert.Equal(t, 0, len(records))
+}
+
+func TestMapBackedChangeHistoryCopiesRecords(t *testing.T) {
+	// given
+	history := NewMapBackedChangeHistory()
+	record := &ChangeRecord{Operation: UpdateRoleChange, RoleName: "editor", After: &ChangeState{Role: &RoleState{Name: "editor", Permissions: 1, ParentRoleNames: []string{"reader"}}}}
+	history.AppendChange(context.Background(), record)
+	record.After.Role.Permissions = 3
+
+	// when
+	found, _ := history.FindChanges(context.Background(), ChangeQuery{})
+	found[0].After.Role.ParentRoleNames[0] = "admin"
+	found[0].Actor = "mallory"
+	again, _ := history.FindChanges(context.Background(), ChangeQuery{})
+
+	// then
+	assert.Equal(t, &ChangeRecord{Operation: UpdateRoleChange, RoleName: "editor", After: &ChangeState{Role: &RoleState{Name: "editor", Permissions: 1, ParentRoleNames: []string{"reader"}}}}, again[0])
+}
+
+// a change history whose batch appends fail with err, storing nothing
+type failingChangeHistory struct {
+	ChangeHistory
+	err error
+}
+
+func (this *failingChangeHistory) AppendChange(ctx context.Context, record *ChangeRecord) error {
+	return this.AppendChanges(ctx, []*ChangeRecord{record})
+}
+
+func (this *failingChangeHistory) AppendChanges(ctx context.Context, records []*ChangeRecord) error {
+	return this.err
+}
@@ -0,0 +1,395 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"time"
+)
+
+// An AccessControlStrategy whose checks accept a context, allowing repository lookups to be cancelled and to carry deadlines and tracing information.
+type ContextAccessControlStrategy interface {
+	AccessControlStrategy
+	// Context aware variant of VerifyRoleAccess.
+	VerifyRoleAccessContext(ctx context.Context, principal Principal, permission Permission) error
+	// Context aware variant of VerifyResourceAccess.
+	VerifyResourceAccessContext(ctx context.Context, principal Principal, permission Permission, secure SecureResource) error
+	// Context aware variant of VerifyResourceAccessById.
+	VerifyResourceAccessByIdContext(ctx context.Context, principal Principal, permission Permission, resourceId string) error
+	// Context aware variant of ExplainRoleAccess.
+	ExplainRoleAccessContext(ctx context.Context, principal Principal, permission Permission) (*Decision, error)
+	// Context aware variant of ExplainResourceAccess.
+	ExplainResourceAccessContext(ctx context.Context, principal Principal, permission Permission, secure SecureResource) (*Decision, error)
+	// Context aware variant of ExplainResourceAccessById.
+	ExplainResourceAccessByIdContext(ctx context.Context, principal Principal, permission Permission, resourceId string) (*Decision, error)
+	// Context aware variant of FilterAuthorized.
+	FilterAuthorizedContext(ctx context.Context, principal Principal, permission Permission, resources []SecureResource) ([]SecureResource, error)
+}
+
+// A RoleRepository whose operations accept a context.
+type ContextRoleRepository interface {
+	RoleRepository
+	// Context aware variant of FindAll.
+	FindAllContext(ctx context.Context) ([]Role, error)
+	// Context aware variant of FindRole.
+	FindRoleContext(ctx context.Context, roleName string) (Role, error)
+	// Context aware variant of CreateRole.
+	CreateRoleContext(ctx context.Context, role Role) error
+	// Context aware variant of UpdateRole.
+	UpdateRoleContext(ctx context.Context, role Role) error
+	// Context aware variant of DeleteRole.
+	DeleteRoleContext(ctx context.Context, roleName string) error
+}
+
+// A SecureResourceRepository whose operations accept a context.
+type ContextSecureResourceRepository interface {
+	SecureResourceRepository
+	// Context aware variant of FindResource.
+	FindResourceContext(ctx context.Context, nativeResourceId string) (SecureResource, error)
+	// Context aware variant of CreateResource.
+	CreateResourceContext(ctx context.Context, resource SecureResource) error
+	// Context aware variant of UpdateResource.
+	UpdateResourceContext(ctx context.Context, resource SecureResource) error
+	// Context aware variant of DeleteResource.
+	DeleteResourceContext(ctx context.Context, nativeResourceId string) error
+}
+
+// A RoleMembershipRepository whose operations accept a context.
+type ContextRoleMembershipRepository interface {
+	RoleMembershipRepository
+	// Context aware variant of AddMember.
+	AddMemberContext(ctx context.Context, roleName string, principalSid string) error
+	// Context aware variant of RemoveMember.
+	RemoveMemberContext(ctx context.Context, roleName string, principalSid string) error
+	// Context aware variant of FindRolesForSid.
+	FindRolesForSidContext(ctx context.Context, principalSid string) ([]string, error)
+	// Context aware variant of FindMembersOfRole.
+	FindMembersOfRoleContext(ctx context.Context, roleName string) ([]string, error)
+}
+
+// A TimeBoundRoleMembershipRepository whose operations accept a context.
+type ContextTimeBoundRoleMembershipRepository interface {
+	TimeBoundRoleMembershipRepository
+	ContextRoleMembershipRepository
+	// Context aware variant of AddMemberBetween.
+	AddMemberBetweenContext(ctx context.Context, roleName string, principalSid string, notBefore time.Time, notAfter time.Time) error
+	// Context aware variant of FindRolesForSidAt.
+	FindRolesForSidAtContext(ctx context.Context, principalSid string, at time.Time) ([]string, error)
+	// Context aware variant of PurgeExpiredMembers.
+	PurgeExpiredMembersContext(ctx context.Context, before time.Time) (int, error)
+}
+
+// A GroupRepository whose operations accept a context.
+type ContextGroupRepository interface {
+	GroupRepository
+	// Context aware variant of CreateGroup.
+	CreateGroupContext(ctx context.Context, groupSid string) error
+	// Context aware variant of DeleteGroup.
+	DeleteGroupContext(ctx context.Context, groupSid string) error
+	// Context aware variant of AddMember.
+	AddMemberContext(ctx context.Context, groupSid string, memberSid string) error
+	// Context aware variant of RemoveMember.
+	RemoveMemberContext(ctx context.Context, groupSid string, memberSid string) error
+	// Context aware variant of FindMembers.
+	FindMembersContext(ctx context.Context, groupSid string) ([]string, error)
+	// Context aware variant of FindGroupSidsForSid.
+	FindGroupSidsForSidContext(ctx context.Context, sid string) ([]string, error)
+}
+
+// Returns the strategy as a ContextAccessControlStrategy. Strategies that do not support contexts are adapted so that each check fails fast if the context is already done, and otherwise delegates to the non context method. Returns nil if the strategy is nil.
+func AdaptAccessControlStrategy(strategy AccessControlStrategy) ContextAccessControlStrategy {
+	if strategy == nil {
+		return nil
+	}
+	if contextStrategy, ok := strategy.(ContextAccessControlStrategy); ok {
+		return contextStrategy
+	}
+	return &contextStrategyAdapter{strategy}
+}
+
+// Returns the repository as a ContextRoleRepository. Repositories that do not support contexts are adapted so that each operation fails fast if the context is already done, and otherwise delegates to the non context method. Returns nil if the repository is nil.
+func AdaptRoleRepository(repo RoleRepository) ContextRoleRepository {
+	if repo == nil {
+		return nil
+	}
+	if contextRepo, ok := repo.(ContextRoleRepository); ok {
+		return contextRepo
+	}
+	return &contextRoleRepositoryAdapter{repo}
+}
+
+// Returns the repository as a ContextSecureResourceRepository. Repositories that do not support contexts are adapted so that each operation fails fast if the context is already done, and otherwise delegates to the non context method. Returns nil if the repository is nil.
+func AdaptSecureResourceRepository(repo SecureResourceRepository) ContextSecureResourceRepository {
+	if repo == nil {
+		return nil
+	}
+	if contextRepo, ok := repo.(ContextSecureResourceRepository); ok {
+		return contextRepo
+	}
+	return &contextSecureResourceRepositoryAdapter{repo}
+}
+
+// Returns the repository as a ContextRoleMembershipRepository. Repositories that do not support contexts are adapted so that each operation fails fast if the context is already done, and otherwise delegates to the non context method. Adapted repositories implementing TimeBoundRoleMembershipRepository also implement ContextTimeBoundRoleMembershipRepository. Returns nil if the repository is nil.
+func AdaptRoleMembershipRepository(repo RoleMembershipRepository) ContextRoleMembershipRepository {
+	if repo == nil {
+		return nil
+	}
+	if contextRepo, ok := repo.(ContextRoleMembershipRepository); ok {
+		return contextRepo
+	}
+	if timeBoundRepo, ok := repo.(TimeBoundRoleMembershipRepository); ok {
+		return &contextTimeBoundRoleMembershipRepositoryAdapter{timeBoundRepo, contextRoleMembershipRepositoryAdapter{timeBoundRepo}}
+	}
+	return &contextRoleMembershipRepositoryAdapter{repo}
+}
+
+// Returns the repository as a ContextGroupRepository. Repositories that do not support contexts are adapted so that each operation fails fast if the context is already done, and otherwise delegates to the non context method. Returns nil if the repository is nil.
+func AdaptGroupRepository(repo GroupRepository) ContextGroupRepository {
+	if repo == nil {
+		return nil
+	}
+	if contextRepo, ok := repo.(ContextGroupRepository); ok {
+		return contextRepo
+	}
+	return &contextGroupRepositoryAdapter{repo}
+}
+
+type contextStrategyAdapter struct {
+	AccessControlStrategy
+}
+
+func (this *contextStrategyAdapter) VerifyRoleAccessContext(ctx context.Context, principal Principal, permission Permission) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.VerifyRoleAccess(principal, permission)
+}
+
+func (this *contextStrategyAdapter) VerifyResourceAccessContext(ctx context.Context, principal Principal, permission Permission, secure SecureResource) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.VerifyResourceAccess(principal, permission, secure)
+}
+
+func (this *contextStrategyAdapter) VerifyResourceAccessByIdContext(ctx context.Context, principal Principal, permission Permission, resourceId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.VerifyResourceAccessById(principal, permission, resourceId)
+}
+
+func (this *contextStrategyAdapter) ExplainRoleAccessContext(ctx context.Context, principal Principal, permission Permission) (*Decision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return this.ExplainRoleAccess(principal, permission)
+}
+
+func (this *contextStrategyAdapter) ExplainResourceAccessContext(ctx context.Context, principal Principal, permission Permission, secure SecureResource) (*Decision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return this.ExplainResourceAccess(principal, permission, secure)
+}
+
+func (this *contextStrategyAdapter) ExplainResourceAccessByIdContext(ctx context.Context, principal Principal, permission Permission, resourceId string) (*Decision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return this.ExplainResourceAccessById(principal, permission, resourceId)
+}
+
+func (this *contextStrategyAdapter) FilterAuthorizedContext(ctx context.Context, principal Principal, permission Permission, resources []SecureResource) ([]SecureResource, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return this.FilterAuthorized(principal, permission, resources)
+}
+
+type contextRoleRepositoryAdapter struct {
+	RoleRepository
+}
+
+func (this *contextRoleRepositoryAdapter) FindAllContext(ctx context.Context) ([]Role, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return this.FindAll()
+}
+
+func (this *contextRoleRepositoryAdapter) FindRoleContext(ctx context.Context, roleName string) (Role, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return this.FindRole(roleName)
+}
+
+func (this *contextRoleRepositoryAdapter) CreateRoleContext(ctx context.Context, role Role) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.CreateRole(role)
+}
+
+func (this *contextRoleRepositoryAdapter) UpdateRoleContext(ctx context.Context, role Role) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.UpdateRole(role)
+}
+
+func (this *contextRoleRepositoryAdapter) DeleteRoleContext(ctx context.Context, roleName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.DeleteRole(roleName)
+}
+
+type contextSecureResourceRepositoryAdapter struct {
+	SecureResourceRepository
+}
+
+func (this *contextSecureResourceRepositoryAdapter) FindResourceContext(ctx context.Context, nativeResourceId string) (SecureResource, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return this.FindResource(nativeResourceId)
+}
+
+func (this *contextSecureResourceRepositoryAdapter) CreateResourceContext(ctx context.Context, resource SecureResource) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.CreateResource(resource)
+}
+
+func (this *contextSecureResourceRepositoryAdapter) UpdateResourceContext(ctx context.Context, resource SecureResource) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.UpdateResource(resource)
+}
+
+func (this *contextSecureResourceRepositoryAdapter) DeleteResourceContext(ctx context.Context, nativeResourceId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.DeleteResource(nativeResourceId)
+}
+
+type contextRoleMembershipRepositoryAdapter struct {
+	RoleMembershipRepository
+}
+
+func (this *contextRoleMembershipRepositoryAdapter) AddMemberContext(ctx context.Context, roleName string, principalSid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.AddMember(roleName, principalSid)
+}
+
+func (this *contextRoleMembershipRepositoryAdapter) RemoveMemberContext(ctx context.Context, roleName string, principalSid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.RemoveMember(roleName, principalSid)
+}
+
+func (this *contextRoleMembershipRepositoryAdapter) FindRolesForSidContext(ctx context.Context, principalSid string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return this.FindRolesForSid(principalSid)
+}
+
+func (this *contextRoleMembershipRepositoryAdapter) FindMembersOfRoleContext(ctx context.Context, roleName string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return this.FindMembersOfRole(roleName)
+}
+
+type contextTimeBoundRoleMembershipRepositoryAdapter struct {
+	TimeBoundRoleMembershipRepository
+	contextRoleMembershipRepositoryAdapter
+}
+
+func (this *contextTimeBoundRoleMembershipRepositoryAdapter) AddMemberBetweenContext(ctx context.Context, roleName string, principalSid string, notBefore time.Time, notAfter time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.AddMemberBetween(roleName, principalSid, notBefore, notAfter)
+}
+
+func (this *contextTimeBoundRoleMembershipRepositoryAdapter) FindRolesForSidAtContext(ctx context.Context, principalSid string, at time.Time) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return this.FindRolesForSidAt(principalSid, at)
+}
+
+func (this *contextTimeBoundRoleMembershipRepositoryAdapter) PurgeExpiredMembersContext(ctx context.Context, before time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return this.PurgeExpiredMembers(before)
+}
+
+type contextGroupRepositoryAdapter struct {
+	GroupRepository
+}
+
+func (this *contextGroupRepositoryAdapter) CreateGroupContext(ctx context.Context, groupSid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.CreateGroup(groupSid)
+}
+
+func (this *contextGroupRepositoryAdapter) DeleteGroupContext(ctx context.Context, groupSid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.DeleteGroup(groupSid)
+}
+
+func (this *contextGroupRepositoryAdapter) AddMemberContext(ctx context.Context, groupSid string, memberSid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.AddMember(groupSid, memberSid)
+}
+
+func (this *contextGroupRepositoryAdapter) RemoveMemberContext(ctx context.Context, groupSid string, memberSid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.RemoveMember(groupSid, memberSid)
+}
+
+func (this *contextGroupRepositoryAdapter) FindMembersContext(ctx context.Context, groupSid string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return this.FindMembers(groupSid)
+}
+
+func (this *contextGroupRepositoryAdapter) FindGroupSidsForSidContext(ctx context.Context, sid string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return this.FindGroupSidsForSid(sid)
+}
@@ -0,0 +1,212 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptRoleRepository(t *testing.T) {
+	// given
+	create := Permission(1)
+	repo := AdaptRoleRepository(NewMapBackedRoleRepository())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// when
+	err := repo.CreateRoleContext(ctx, NewRole("testRole", create))
+
+	// then
+	assert.Nil(t, err)
+	roles, err := repo.FindAllContext(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(roles))
+
+	cancel()
+	_, err = repo.FindAllContext(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Nil(t, AdaptRoleRepository(nil))
+}
+
+func TestAdaptSecureResourceRepository(t *testing.T) {
+	// given
+	repo := AdaptSecureResourceRepository(NewMapBackedSecureResourceRepository())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// when
+	err := repo.CreateResourceContext(ctx, NewSecureResource("id", "owner", nil, false))
+
+	// then
+	assert.Nil(t, err)
+	resource, err := repo.FindResourceContext(ctx, "id")
+	assert.Nil(t, err)
+	assert.Equal(t, "id", resource.GetNativeId())
+
+	cancel()
+	_, err = repo.FindResourceContext(ctx, "id")
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Nil(t, AdaptSecureResourceRepository(nil))
+}
+
+func TestAdaptRoleMembershipRepository(t *testing.T) {
+	// given
+	repo := AdaptRoleMembershipRepository(NewMapBackedRoleMembershipRepository())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// when
+	err := repo.AddMemberContext(ctx, "testRole", "sid")
+
+	// then
+	assert.Nil(t, err)
+	roleNames, err := repo.FindRolesForSidContext(ctx, "sid")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"testRole"}, roleNames)
+	timeBound, ok := repo.(ContextTimeBoundRoleMembershipRepository)
+	assert.True(t, ok)
+	roleNames, err = timeBound.FindRolesForSidAtContext(ctx, "sid", time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"testRole"}, roleNames)
+
+	cancel()
+	_, err = repo.FindRolesForSidContext(ctx, "sid")
+	assert.True(t, errors.Is(err, context.Canceled))
+	_, err = timeBound.FindRolesForSidAtContext(ctx, "sid", time.Now())
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Nil(t, AdaptRoleMembershipRepository(nil))
+}
+
+func TestAdaptGroupRepository(t *testing.T) {
+	// given
+	repo := AdaptGroupRepository(NewMapBackedGroupRepository())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// when
+	err := repo.CreateGroupContext(ctx, "finance")
+
+	// then
+	assert.Nil(t, err)
+	assert.Nil(t, repo.AddMemberContext(ctx, "finance", "sid"))
+	groupSids, err := repo.FindGroupSidsForSidContext(ctx, "sid")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"finance"}, groupSids)
+
+	cancel()
+	_, err = repo.FindGroupSidsForSidContext(ctx, "sid")
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Nil(t, AdaptGroupRepository(nil))
+}
+
+func TestGroupResolutionUsesCheckContext(t *testing.T) {
+	// given
+	groupRepo := &contextRecordingGroupRepository{ContextGroupRepository: AdaptGroupRepository(NewMapBackedGroupRepository())}
+	groupRepo.CreateGroup("finance")
+	groupRepo.AddMember("finance", "sid")
+	resource := NewSecureResource("id", "owner", nil, false)
+	acl, _ := resource.GetACL()
+	acl.AddACE(NewACE("finance", 1))
+	aclService := AdaptAccessControlStrategy(NewAccessControlStrategy(nil, nil, false, WithGroupRepository(groupRepo)))
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "check")
+
+	// when
+	err := aclService.VerifyResourceAccessContext(ctx, &mockPrincipal{id: "sid", sid: "sid"}, 1, resource)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "check", groupRepo.ctx.Value(key{}))
+}
+
+func TestVerifyRoleAccessContextWithMembership(t *testing.T) {
+	// given
+	create := Permission(1)
+	mockRoleRepo := new(mockRoleRepository)
+	mockRoleRepo.On("FindAll").Return([]Role{NewRole("testRole", create)}, nil)
+	membershipRepo := NewMapBackedRoleMembershipRepository()
+	membershipRepo.AddMember("testRole", "sid")
+	p := &mockPrincipal{sid: "sid"}
+	aclService := AdaptAccessControlStrategy(NewAccessControlStrategy(nil, mockRoleRepo, true, WithRoleMembership(membershipRepo, false)))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// when
+	err := aclService.VerifyRoleAccessContext(ctx, p, create)
+
+	// then
+	assert.Nil(t, err)
+
+	cancel()
+	err = aclService.VerifyRoleAccessContext(ctx, p, create)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestVerifyRoleAccessContext(t *testing.T) {
+	// given
+	create := Permission(1)
+	mockRoleRepo := new(mockRoleRepository)
+	mockRoleRepo.On("FindAll").Return([]Role{NewRole("testRole", create)}, nil)
+	p := &mockPrincipal{roleNames: []string{"testRole"}}
+	aclService := AdaptAccessControlStrategy(NewAccessControlStrategy(nil, mockRoleRepo, true))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// when
+	err := aclService.VerifyRoleAccessContext(ctx, p, create)
+
+	// then
+	assert.Nil(t, err)
+
+	cancel()
+	err = aclService.VerifyRoleAccessContext(ctx, p, create)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, errors.Is(err, ErrRepository))
+}
+
+func TestVerifyResourceAccessContext(t *testing.T) {
+	// given
+	create := Permission(1)
+	p := &mockPrincipal{sid: "id", roleNames: []string{}}
+	resourceRepo := NewMapBackedSecureResourceRepository()
+	resource := NewSecureResource("id", "owner", nil, false)
+	acl, _ := resource.GetACL()
+	acl.AddACE(NewACE("id", create))
+	resourceRepo.CreateResource(resource)
+	aclService := AdaptAccessControlStrategy(NewAccessControlStrategy(resourceRepo, nil, false))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// when
+	err := aclService.VerifyResourceAccessByIdContext(ctx, p, create, "id")
+
+	// then
+	assert.Nil(t, err)
+
+	cancel()
+	err = aclService.VerifyResourceAccessByIdContext(ctx, p, create, "id")
+	assert.True(t, errors.Is(err, context.Canceled))
+	err = aclService.VerifyResourceAccessContext(ctx, p, create, resource)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+// records the context group lookups are made with
+type contextRecordingGroupRepository struct {
+	ContextGroupRepository
+	ctx context.Context
+}
+
+func (this *contextRecordingGroupRepository) FindGroupSidsForSidContext(ctx context.Context, sid string) ([]string, error) {
+	this.ctx = ctx
+	return this.ContextGroupRepository.FindGroupSidsForSidContext(ctx, sid)
+}
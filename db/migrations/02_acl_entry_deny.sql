@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE acl_entry ADD COLUMN is_deny boolean NOT NULL DEFAULT false;
+
+DROP INDEX ix_acl_entry_secure_resource_id_principal_sid;
+
+CREATE UNIQUE INDEX ix_acl_entry_secure_resource_id_principal_sid_is_deny ON acl_entry (
+       secure_resource_id,
+       principal_sid,
+       is_deny
+);
@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE role_parent (
+       role_id            bigint NOT NULL,
+       parent_role_id     bigint NOT NULL,
+       CONSTRAINT pk_role_parent PRIMARY KEY(role_id, parent_role_id),
+       CONSTRAINT fk_role_parent_role_id FOREIGN KEY(role_id) REFERENCES role(role_id) ON DELETE CASCADE,
+       CONSTRAINT fk_role_parent_parent_role_id FOREIGN KEY(parent_role_id) REFERENCES role(role_id) ON DELETE CASCADE
+);
+
+CREATE INDEX ix_role_parent_parent_role_id ON role_parent (
+       parent_role_id
+);
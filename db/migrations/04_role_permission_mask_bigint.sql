@@ -0,0 +1,3 @@
+-- +goose Up
+-- Widens role permission masks to match acl_entry, allowing up to 64 permissions. Existing masks are preserved as is.
+ALTER TABLE role ALTER COLUMN permission_mask TYPE bigint;
@@ -0,0 +1,21 @@
+-- +goose Up
+CREATE TABLE principal_group (
+       principal_group_id bigserial,
+       group_sid          text NOT NULL,
+       CONSTRAINT pk_principal_group PRIMARY KEY(principal_group_id)
+);
+
+CREATE UNIQUE INDEX ix_principal_group_group_sid ON principal_group (
+       group_sid
+);
+
+CREATE TABLE principal_group_member (
+       principal_group_id bigint NOT NULL,
+       member_sid         text NOT NULL,
+       CONSTRAINT pk_principal_group_member PRIMARY KEY(principal_group_id, member_sid),
+       CONSTRAINT fk_principal_group_member_principal_group_id FOREIGN KEY(principal_group_id) REFERENCES principal_group(principal_group_id) ON DELETE CASCADE
+);
+
+CREATE INDEX ix_principal_group_member_member_sid ON principal_group_member (
+       member_sid
+);
@@ -0,0 +1,14 @@
+-- +goose Up
+ALTER TABLE acl_entry ADD COLUMN not_before timestamptz;
+ALTER TABLE acl_entry ADD COLUMN not_after timestamptz;
+
+CREATE INDEX ix_acl_entry_not_after ON acl_entry (
+       not_after
+) WHERE not_after IS NOT NULL;
+
+ALTER TABLE role_member ADD COLUMN not_before timestamptz;
+ALTER TABLE role_member ADD COLUMN not_after timestamptz;
+
+CREATE INDEX ix_role_member_not_after ON role_member (
+       not_after
+) WHERE not_after IS NOT NULL;
@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE access_audit_event (
+       access_audit_event_id bigserial,
+       occurred_at           timestamptz NOT NULL,
+       check_kind            text NOT NULL,
+       principal_id          text NOT NULL,
+       principal_sid         text NOT NULL,
+       permission_mask       bigint NOT NULL,
+       resource_id           text NOT NULL,
+       granted               boolean NOT NULL,
+       rule                  text NOT NULL,
+       error                 text NOT NULL,
+       CONSTRAINT pk_access_audit_event PRIMARY KEY(access_audit_event_id)
+);
+
+CREATE INDEX ix_access_audit_event_occurred_at ON access_audit_event (
+       occurred_at
+);
+
+CREATE INDEX ix_access_audit_event_principal_sid ON access_audit_event (
+       principal_sid
+);
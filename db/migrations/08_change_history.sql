@@ -0,0 +1,27 @@
+-- +goose Up
+CREATE TABLE change_history (
+       change_history_id bigserial,
+       occurred_at       timestamptz NOT NULL,
+       actor             text NOT NULL,
+       operation         text NOT NULL,
+       role_name         text NOT NULL,
+       resource_id       text NOT NULL,
+       sid               text NOT NULL,
+       permission_mask   bigint NOT NULL,
+       before_state      jsonb,
+       after_state       jsonb,
+       CONSTRAINT pk_change_history PRIMARY KEY(change_history_id)
+);
+
+CREATE INDEX ix_change_history_resource_id_sid ON change_history (
+       resource_id,
+       sid
+);
+
+CREATE INDEX ix_change_history_role_name ON change_history (
+       role_name
+);
+
+CREATE INDEX ix_change_history_occurred_at ON change_history (
+       occurred_at
+);
@@ -0,0 +1,47 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+
+	"github.com/dakiva/dbx"
+)
+
+type dbBackedAuditSink struct {
+	ctx      dbx.DBContext
+	queryMap dbx.QueryMap
+}
+
+// Construct a new DB backed AuditSink, appending events to the access_audit_event table.
+func NewDBBackedAuditSink(ctx dbx.DBContext, queryMap dbx.QueryMap) AuditSink {
+	return &dbBackedAuditSink{ctx: ctx, queryMap: queryMap}
+}
+
+func (this *dbBackedAuditSink) WriteAuditEvent(ctx context.Context, event *AuditEvent) error {
+	args := map[string]interface{}{
+		"occurred_at":     event.Timestamp,
+		"check_kind":      event.Check,
+		"principal_id":    event.PrincipalId,
+		"principal_sid":   event.PrincipalSid,
+		"permission_mask": event.Permission,
+		"resource_id":     event.ResourceId,
+		"granted":         event.Granted,
+		"rule":            event.Rule,
+		"error":           event.Error,
+	}
+	_, err := namedExecContext(ctx, this.ctx, this.queryMap.Q("InsertAccessAuditEvent"), args)
+	return err
+}
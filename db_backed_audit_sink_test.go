@@ -0,0 +1,51 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditEventPersistence(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	sink := NewDBBackedAuditSink(tx, queryMap)
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// when
+	err := sink.WriteAuditEvent(context.Background(), &AuditEvent{Timestamp: at, Check: ResourceAccessByIdCheck, PrincipalId: "bob", PrincipalSid: "id", Permission: Permission(1 << 63), ResourceId: "doc", Rule: "no matching rule"})
+
+	// then
+	assert.Nil(t, err)
+	var stored struct {
+		OccurredAt     time.Time  `db:"occurred_at"`
+		PermissionMask Permission `db:"permission_mask"`
+		ResourceId     string     `db:"resource_id"`
+		Granted        bool       `db:"granted"`
+		Rule           string     `db:"rule"`
+	}
+	err = tx.Get(&stored, "SELECT occurred_at, permission_mask, resource_id, granted, rule FROM access_audit_event WHERE principal_sid = 'id'")
+	assert.Nil(t, err)
+	assert.True(t, at.Equal(stored.OccurredAt))
+	assert.Equal(t, Permission(1<<63), stored.PermissionMask)
+	assert.Equal(t, "doc", stored.ResourceId)
+	assert.False(t, stored.Granted)
+	assert.Equal(t, "no matching rule", stored.Rule)
+}
@@ -0,0 +1,150 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/dakiva/dbx"
+	"github.com/lib/pq"
+)
+
+type dbBackedChangeHistory struct {
+	ctx      dbx.DBContext
+	queryMap dbx.QueryMap
+}
+
+// Construct a new DB backed ChangeHistory, appending records to the change_history table. States are stored as jsonb.
+func NewDBBackedChangeHistory(ctx dbx.DBContext, queryMap dbx.QueryMap) ChangeHistory {
+	return &dbBackedChangeHistory{ctx: ctx, queryMap: queryMap}
+}
+
+type dbChangeRecord struct {
+	OccurredAt  time.Time `db:"occurred_at"`
+	Actor       string    `db:"actor"`
+	Operation   string    `db:"operation"`
+	RoleName    string    `db:"role_name"`
+	ResourceId  string    `db:"resource_id"`
+	Sid         string    `db:"sid"`
+	BeforeState []byte    `db:"before_state"`
+	AfterState  []byte    `db:"after_state"`
+}
+
+func (this *dbBackedChangeHistory) AppendChange(ctx context.Context, record *ChangeRecord) error {
+	return appendChangeRecord(ctx, this.ctx, this.queryMap, record)
+}
+
+func (this *dbBackedChangeHistory) AppendChanges(ctx context.Context, records []*ChangeRecord) error {
+	return inTransaction(ctx, this.ctx, func(tx dbx.DBContext) error {
+		for _, record := range records {
+			if err := appendChangeRecord(ctx, tx, this.queryMap, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// inserts the record into the change_history table.
+func appendChangeRecord(ctx context.Context, db dbx.DBContext, queryMap dbx.QueryMap, record *ChangeRecord) error {
+	before, err := marshalChangeState(record.Before)
+	if err != nil {
+		return err
+	}
+	after, err := marshalChangeState(record.After)
+	if err != nil {
+		return err
+	}
+	args := map[string]interface{}{
+		"occurred_at":     record.Timestamp,
+		"actor":           record.Actor,
+		"operation":       string(record.Operation),
+		"role_name":       record.RoleName,
+		"resource_id":     record.ResourceId,
+		"sid":             record.Sid,
+		"permission_mask": record.changedACE().Permissions,
+		"before_state":    before,
+		"after_state":     after,
+	}
+	_, err = namedExecContext(ctx, db, queryMap.Q("InsertChangeHistory"), args)
+	return err
+}
+
+func (this *dbBackedChangeHistory) FindChanges(ctx context.Context, query ChangeQuery) ([]*ChangeRecord, error) {
+	operations := make(pq.StringArray, 0, len(query.Operations))
+	for _, operation := range query.Operations {
+		operations = append(operations, string(operation))
+	}
+	args := map[string]interface{}{
+		"operations":      operations,
+		"actor":           query.Actor,
+		"role_name":       query.RoleName,
+		"resource_id":     query.ResourceId,
+		"sid":             query.Sid,
+		"permission_mask": query.Permission,
+		"since":           query.Since,
+		"until":           nullableTime(query.Until),
+		"limit":           nil,
+	}
+	if query.Limit > 0 {
+		args["limit"] = query.Limit
+	}
+	rows, err := namedQueryContext(ctx, this.ctx, this.queryMap.Q("FindChangeHistory"), args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := make([]*ChangeRecord, 0)
+	for rows.Next() {
+		row := &dbChangeRecord{}
+		if err = rows.StructScan(row); err != nil {
+			return nil, err
+		}
+		record := &ChangeRecord{Timestamp: row.OccurredAt, Actor: row.Actor, Operation: ChangeOperation(row.Operation), RoleName: row.RoleName, ResourceId: row.ResourceId, Sid: row.Sid}
+		if record.Before, err = unmarshalChangeState(row.BeforeState); err != nil {
+			return nil, err
+		}
+		if record.After, err = unmarshalChangeState(row.AfterState); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// returns the state as json, or nil if the state is nil.
+func marshalChangeState(state *ChangeState) (interface{}, error) {
+	if state == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func unmarshalChangeState(data []byte) (*ChangeState, error) {
+	if data == nil {
+		return nil, nil
+	}
+	state := &ChangeState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangeHistoryPersistence(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	history := NewDBBackedChangeHistory(tx, queryMap)
+	repo := NewChangeTrackingSecureResourceRepository(NewDBBackedSecureResourceRepository(tx, queryMap), history)
+	roleRepo := NewChangeTrackingRoleRepository(NewDBBackedRoleRepository(tx, queryMap), history)
+	ctx := WithActor(context.Background(), "alice")
+	project := NewSecureResource("project", "owner", nil, false)
+	acl, _ := project.GetACL()
+	acl.AddACE(NewACE("bob", 1))
+	high := Permission(1 << 63)
+
+	// when
+	err := repo.CreateResourceContext(ctx, project)
+	assert.Nil(t, err)
+	acl.AddACE(NewDenyACE("bob", high))
+	err = repo.UpdateResourceContext(WithActor(context.Background(), "mallory"), project)
+	assert.Nil(t, err)
+	err = roleRepo.CreateRoleContext(ctx, NewAdminRole("admin", high))
+	assert.Nil(t, err)
+	err = roleRepo.DeleteRole("admin")
+	assert.Nil(t, err)
+
+	// then
+	all, err := history.FindChanges(context.Background(), ChangeQuery{})
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(all))
+	assert.Equal(t, "project", all[0].After.Resource.NativeId)
+	assert.Nil(t, all[0].Before)
+	assert.Equal(t, 1, len(all[2].Before.Resource.ACEs))
+	assert.Equal(t, 2, len(all[2].After.Resource.ACEs))
+	assert.Equal(t, &RoleState{Name: "admin", Permissions: high, Admin: true}, all[4].After.Role)
+	assert.Equal(t, DeleteRoleChange, all[5].Operation)
+	assert.Equal(t, "", all[5].Actor)
+	assert.Equal(t, high, all[5].Before.Role.Permissions)
+	assert.Nil(t, all[5].After)
+	granted, err := history.FindChanges(context.Background(), ChangeQuery{Operations: []ChangeOperation{AddACEChange}, ResourceId: "project", Sid: "bob", Permission: high})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(granted))
+	assert.Equal(t, "mallory", granted[0].Actor)
+	assert.True(t, granted[0].After.ACE.Deny)
+	assert.True(t, granted[0].After.ACE.NotAfter.IsZero())
+	limited, _ := history.FindChanges(context.Background(), ChangeQuery{Actor: "alice", Limit: 1, Until: time.Now().Add(time.Hour)})
+	assert.Equal(t, 1, len(limited))
+	assert.Equal(t, CreateResourceChange, limited[0].Operation)
+}
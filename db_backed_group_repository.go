@@ -0,0 +1,120 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package nogo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dakiva/dbx"
+)
+
+type dbBackedGroupRepository struct {
+	ctx      dbx.DBContext
+	queryMap dbx.QueryMap
+}
+
+// Construct a new DB backed GroupRepository, using the principal_group and principal_group_member tables. The returned repository also implements ContextGroupRepository.
+func NewDBBackedGroupRepository(ctx dbx.DBContext, queryMap dbx.QueryMap) GroupRepository {
+	return &dbBackedGroupRepository{ctx: ctx, queryMap: queryMap}
+}
+
+func (this *dbBackedGroupRepository) CreateGroup(groupSid string) error {
+	return this.CreateGroupContext(context.Background(), groupSid)
+}
+
+func (this *dbBackedGroupRepository) DeleteGroup(groupSid string) error {
+	return this.DeleteGroupContext(context.Background(), groupSid)
+}
+
+func (this *dbBackedGroupRepository) AddMember(groupSid string, memberSid string) error {
+	return this.AddMemberContext(context.Background(), groupSid, memberSid)
+}
+
+func (this *dbBackedGroupRepository) RemoveMember(groupSid string, memberSid string) error {
+	return this.RemoveMemberContext(context.Background(), groupSid, memberSid)
+}
+
+func (this *dbBackedGroupRepository) FindMembers(groupSid string) ([]string, error) {
+	return this.FindMembersContext(context.Background(), groupSid)
+}
+
+func (this *dbBackedGroupRepository) FindGroupSidsForSid(sid string) ([]string, error) {
+	return this.FindGroupSidsForSidContext(context.Background(), sid)
+}
+
+func (this *dbBackedGroupRepository) CreateGroupContext(ctx context.Context, groupSid string) error {
+	_, err := namedExecContext(ctx, this.ctx, this.queryMap.Q("InsertPrincipalGroup"), map[string]interface{}{"group_sid": groupSid})
+	return err
+}
+
+func (this *dbBackedGroupRepository) DeleteGroupContext(ctx context.Context, groupSid string) error {
+	_, err := namedExecContext(ctx, this.ctx, this.queryMap.Q("DeletePrincipalGroupMemberships"), map[string]interface{}{"member_sid": groupSid})
+	if err != nil {
+		return err
+	}
+	result, err := namedExecContext(ctx, this.ctx, this.queryMap.Q("DeletePrincipalGroup"), map[string]interface{}{"group_sid": groupSid})
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return errors.New(fmt.Sprintf("Error deleting group. Group %v does not exist.", groupSid))
+	}
+	return nil
+}
+
+func (this *dbBackedGroupRepository) AddMemberContext(ctx context.Context, groupSid string, memberSid string) error {
+	// the member would contain the group if the group already belongs to it
+	groupSids, err := this.FindGroupSidsForSidContext(ctx, groupSid)
+	if err != nil {
+		return err
+	}
+	if memberSid == groupSid || containsString(groupSids, memberSid) {
+		return errors.New(fmt.Sprintf("Error adding member. Group %v cannot be a member of itself.", groupSid))
+	}
+	result, err := namedExecContext(ctx, this.ctx, this.queryMap.Q("InsertPrincipalGroupMember"), map[string]interface{}{"group_sid": groupSid, "member_sid": memberSid})
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return errors.New(fmt.Sprintf("Error adding member. Group %v does not exist.", groupSid))
+	}
+	return nil
+}
+
+func (this *dbBackedGroupRepository) RemoveMemberContext(ctx context.Context, groupSid string, memberSid string) error {
+	result, err := namedExecContext(ctx, this.ctx, this.queryMap.Q("DeletePrincipalGroupMember"), map[string]interface{}{"group_sid": groupSid, "member_sid": memberSid})
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return errors.New(fmt.Sprintf("Error removing member. Sid %v is not a member of group %v.", memberSid, groupSid))
+	}
+	return nil
+}
+
+func (this *dbBackedGroupRepository) FindMembersContext(ctx context.Context, groupSid string) ([]string, error) {
+	return namedQueryStrings(ctx, this.ctx, this.queryMap.Q("FindPrincipalGroupMembers"), map[string]interface{}{"group_sid": groupSid})
+}
+
+func (this *dbBackedGroupRepository) FindGroupSidsForSidContext(ctx context.Context, sid string) ([]string, error) {
+	return namedQueryStrings(ctx, this.ctx, this.queryMap.Q("FindGroupSidsForSid"), map[string]interface{}{"member_sid": sid})
+}
@@ -0,0 +1,113 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package nogo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupNesting(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedGroupRepository(tx, queryMap)
+	assert.Nil(t, repo.CreateGroup("finance"))
+	assert.Nil(t, repo.CreateGroup("accounting"))
+	assert.Nil(t, repo.CreateGroup("payroll"))
+
+	// when
+	assert.Nil(t, repo.AddMember("finance", "accounting"))
+	assert.Nil(t, repo.AddMember("accounting", "payroll"))
+	assert.Nil(t, repo.AddMember("payroll", "sid"))
+	assert.Nil(t, repo.AddMember("finance", "sid"))
+
+	// then
+	groupSids, err := repo.FindGroupSidsForSid("sid")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"accounting", "finance", "payroll"}, groupSids)
+	members, err := repo.FindMembers("finance")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"accounting", "sid"}, members)
+}
+
+func TestGroupCycleDetection(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedGroupRepository(tx, queryMap)
+	repo.CreateGroup("finance")
+	repo.CreateGroup("accounting")
+	repo.CreateGroup("payroll")
+	repo.AddMember("finance", "accounting")
+	repo.AddMember("accounting", "payroll")
+
+	// when
+	cycleErr := repo.AddMember("payroll", "finance")
+	selfErr := repo.AddMember("finance", "finance")
+	missingErr := repo.AddMember("missing", "sid")
+
+	// then
+	assert.NotNil(t, cycleErr)
+	assert.NotNil(t, selfErr)
+	assert.NotNil(t, missingErr)
+}
+
+func TestGroupDeletion(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedGroupRepository(tx, queryMap)
+	repo.CreateGroup("finance")
+	repo.CreateGroup("accounting")
+	repo.AddMember("finance", "accounting")
+	repo.AddMember("accounting", "sid")
+
+	// when
+	err := repo.DeleteGroup("accounting")
+
+	// then
+	assert.Nil(t, err)
+	groupSids, _ := repo.FindGroupSidsForSid("sid")
+	assert.Equal(t, 0, len(groupSids))
+	members, _ := repo.FindMembers("finance")
+	assert.Equal(t, 0, len(members))
+	assert.NotNil(t, repo.DeleteGroup("accounting"))
+	assert.NotNil(t, repo.RemoveMember("finance", "accounting"))
+}
+
+func TestGroupContext(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedGroupRepository(tx, queryMap).(ContextGroupRepository)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// when
+	err := repo.CreateGroupContext(ctx, "finance")
+
+	// then
+	assert.Nil(t, err)
+	assert.Nil(t, repo.AddMemberContext(ctx, "finance", "sid"))
+	groupSids, err := repo.FindGroupSidsForSidContext(ctx, "sid")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"finance"}, groupSids)
+
+	cancel()
+	_, err = repo.FindGroupSidsForSidContext(ctx, "sid")
+	assert.True(t, errors.Is(err, context.Canceled))
+}
@@ -0,0 +1,123 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dakiva/dbx"
+)
+
+type dbBackedRoleMembershipRepository struct {
+	ctx      dbx.DBContext
+	queryMap dbx.QueryMap
+}
+
+// Construct a new DB backed RoleMembershipRepository. Memberships reference roles stored by the DB backed RoleRepository. The returned repository also implements ContextTimeBoundRoleMembershipRepository.
+func NewDBBackedRoleMembershipRepository(ctx dbx.DBContext, queryMap dbx.QueryMap) RoleMembershipRepository {
+	return &dbBackedRoleMembershipRepository{ctx: ctx, queryMap: queryMap}
+}
+
+func (this *dbBackedRoleMembershipRepository) AddMember(roleName string, principalSid string) error {
+	return this.AddMemberContext(context.Background(), roleName, principalSid)
+}
+
+func (this *dbBackedRoleMembershipRepository) AddMemberBetween(roleName string, principalSid string, notBefore time.Time, notAfter time.Time) error {
+	return this.AddMemberBetweenContext(context.Background(), roleName, principalSid, notBefore, notAfter)
+}
+
+func (this *dbBackedRoleMembershipRepository) RemoveMember(roleName string, principalSid string) error {
+	return this.RemoveMemberContext(context.Background(), roleName, principalSid)
+}
+
+func (this *dbBackedRoleMembershipRepository) FindRolesForSid(principalSid string) ([]string, error) {
+	return this.FindRolesForSidContext(context.Background(), principalSid)
+}
+
+func (this *dbBackedRoleMembershipRepository) FindRolesForSidAt(principalSid string, at time.Time) ([]string, error) {
+	return this.FindRolesForSidAtContext(context.Background(), principalSid, at)
+}
+
+func (this *dbBackedRoleMembershipRepository) PurgeExpiredMembers(before time.Time) (int, error) {
+	return this.PurgeExpiredMembersContext(context.Background(), before)
+}
+
+func (this *dbBackedRoleMembershipRepository) FindMembersOfRole(roleName string) ([]string, error) {
+	return this.FindMembersOfRoleContext(context.Background(), roleName)
+}
+
+func (this *dbBackedRoleMembershipRepository) AddMemberContext(ctx context.Context, roleName string, principalSid string) error {
+	return this.addMember(ctx, "InsertRoleMember", map[string]interface{}{"role_name": roleName, "principal_sid": principalSid})
+}
+
+func (this *dbBackedRoleMembershipRepository) AddMemberBetweenContext(ctx context.Context, roleName string, principalSid string, notBefore time.Time, notAfter time.Time) error {
+	args := map[string]interface{}{
+		"role_name":     roleName,
+		"principal_sid": principalSid,
+		"not_before":    nullableTime(notBefore),
+		"not_after":     nullableTime(notAfter),
+	}
+	return this.addMember(ctx, "InsertTimeBoundRoleMember", args)
+}
+
+func (this *dbBackedRoleMembershipRepository) addMember(ctx context.Context, queryName string, args map[string]interface{}) error {
+	result, err := namedExecContext(ctx, this.ctx, this.queryMap.Q(queryName), args)
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return errors.New(fmt.Sprintf("Error adding member. Role %v does not exist.", args["role_name"]))
+	}
+	return nil
+}
+
+func (this *dbBackedRoleMembershipRepository) RemoveMemberContext(ctx context.Context, roleName string, principalSid string) error {
+	result, err := namedExecContext(ctx, this.ctx, this.queryMap.Q("DeleteRoleMember"), map[string]interface{}{"role_name": roleName, "principal_sid": principalSid})
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return errors.New(fmt.Sprintf("Error removing member. Principal %v is not a member of role %v.", principalSid, roleName))
+	}
+	return nil
+}
+
+func (this *dbBackedRoleMembershipRepository) FindRolesForSidContext(ctx context.Context, principalSid string) ([]string, error) {
+	return this.FindRolesForSidAtContext(ctx, principalSid, time.Now())
+}
+
+func (this *dbBackedRoleMembershipRepository) FindRolesForSidAtContext(ctx context.Context, principalSid string, at time.Time) ([]string, error) {
+	return namedQueryStrings(ctx, this.ctx, this.queryMap.Q("FindRolesForSid"), map[string]interface{}{"principal_sid": principalSid, "now": at})
+}
+
+func (this *dbBackedRoleMembershipRepository) PurgeExpiredMembersContext(ctx context.Context, before time.Time) (int, error) {
+	result, err := namedExecContext(ctx, this.ctx, this.queryMap.Q("DeleteExpiredRoleMembers"), map[string]interface{}{"before": before})
+	if err != nil {
+		return 0, err
+	}
+	count, err := result.RowsAffected()
+	return int(count), err
+}
+
+func (this *dbBackedRoleMembershipRepository) FindMembersOfRoleContext(ctx context.Context, roleName string) ([]string, error) {
+	return namedQueryStrings(ctx, this.ctx, this.queryMap.Q("FindMembersOfRole"), map[string]interface{}{"role_name": roleName})
+}
@@ -0,0 +1,137 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleMemberAddition(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	roleRepo := NewDBBackedRoleRepository(tx, queryMap)
+	repo := NewDBBackedRoleMembershipRepository(tx, queryMap)
+	roleRepo.CreateRole(NewRole("role1", 16))
+	roleRepo.CreateRole(NewRole("role2", 16))
+
+	// when
+	err := repo.AddMember("role1", "sid")
+	assert.Nil(t, err)
+	err = repo.AddMember("role2", "sid")
+	assert.Nil(t, err)
+	err = repo.AddMember("role1", "sid2")
+	assert.Nil(t, err)
+
+	// then
+	roleNames, err := repo.FindRolesForSid("sid")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"role1", "role2"}, roleNames)
+	members, err := repo.FindMembersOfRole("role1")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sid", "sid2"}, members)
+}
+
+func TestRoleMemberAdditionForMissingRole(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedRoleMembershipRepository(tx, queryMap)
+
+	// when
+	err := repo.AddMember("role", "sid")
+
+	// then
+	assert.NotNil(t, err)
+}
+
+func TestRoleMemberRemoval(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	roleRepo := NewDBBackedRoleRepository(tx, queryMap)
+	repo := NewDBBackedRoleMembershipRepository(tx, queryMap)
+	roleRepo.CreateRole(NewRole("role", 16))
+	repo.AddMember("role", "sid")
+
+	// when
+	err := repo.RemoveMember("role", "sid")
+
+	// then
+	assert.Nil(t, err)
+	roleNames, err := repo.FindRolesForSid("sid")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(roleNames))
+	err = repo.RemoveMember("role", "sid")
+	assert.NotNil(t, err)
+}
+
+func TestTimeBoundRoleMember(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	roleRepo := NewDBBackedRoleRepository(tx, queryMap)
+	repo := NewDBBackedRoleMembershipRepository(tx, queryMap).(TimeBoundRoleMembershipRepository)
+	roleRepo.CreateRole(NewRole("permanent", 16))
+	roleRepo.CreateRole(NewRole("oncall", 16))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	repo.AddMember("permanent", "sid")
+
+	// when
+	err := repo.AddMemberBetween("oncall", "sid", start, end)
+
+	// then
+	assert.Nil(t, err)
+	roleNames, _ := repo.FindRolesForSidAt("sid", start.Add(-time.Second))
+	assert.Equal(t, []string{"permanent"}, roleNames)
+	roleNames, _ = repo.FindRolesForSidAt("sid", start)
+	assert.Equal(t, []string{"oncall", "permanent"}, roleNames)
+	roleNames, _ = repo.FindRolesForSid("sid")
+	assert.Equal(t, []string{"permanent"}, roleNames)
+	count, err := repo.PurgeExpiredMembers(end)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	members, _ := repo.FindMembersOfRole("oncall")
+	assert.Equal(t, 0, len(members))
+}
+
+func TestRoleMemberContext(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	roleRepo := NewDBBackedRoleRepository(tx, queryMap)
+	repo := NewDBBackedRoleMembershipRepository(tx, queryMap).(ContextTimeBoundRoleMembershipRepository)
+	roleRepo.CreateRole(NewRole("role1", 16))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// when
+	err := repo.AddMemberContext(ctx, "role1", "sid")
+
+	// then
+	assert.Nil(t, err)
+	roleNames, err := repo.FindRolesForSidAtContext(ctx, "sid", time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"role1"}, roleNames)
+
+	cancel()
+	_, err = repo.FindRolesForSidContext(ctx, "sid")
+	assert.True(t, errors.Is(err, context.Canceled))
+}
@@ -14,25 +14,55 @@
 
 package nogo
 
-import "github.com/dakiva/dbx"
+import (
+	"context"
+
+	"github.com/dakiva/dbx"
+)
 
 type dbBackedRoleRepository struct {
 	ctx      dbx.DBContext
 	queryMap dbx.QueryMap
 }
 
-// Construct a new DB backed RoleRepository
+// Construct a new DB backed RoleRepository. The returned repository also implements ContextRoleRepository.
 func NewDBBackedRoleRepository(ctx dbx.DBContext, queryMap dbx.QueryMap) RoleRepository {
 	return &dbBackedRoleRepository{ctx: ctx, queryMap: queryMap}
 }
 
 func (this *dbBackedRoleRepository) FindAll() ([]Role, error) {
-	rows, err := this.ctx.NamedQuery(this.queryMap.Q("FindAllRoles"), map[string]interface{}{})
+	return this.FindAllContext(context.Background())
+}
+
+func (this *dbBackedRoleRepository) FindRole(roleName string) (Role, error) {
+	return this.FindRoleContext(context.Background(), roleName)
+}
+
+func (this *dbBackedRoleRepository) CreateRole(role Role) error {
+	return this.CreateRoleContext(context.Background(), role)
+}
+
+func (this *dbBackedRoleRepository) UpdateRole(role Role) error {
+	return this.UpdateRoleContext(context.Background(), role)
+}
+
+func (this *dbBackedRoleRepository) DeleteRole(roleName string) error {
+	return this.DeleteRoleContext(context.Background(), roleName)
+}
+
+type dbRoleParent struct {
+	RoleName       string `db:"role_name"`
+	ParentRoleName string `db:"parent_role_name"`
+}
+
+func (this *dbBackedRoleRepository) FindAllContext(ctx context.Context) ([]Role, error) {
+	rows, err := namedQueryContext(ctx, this.ctx, this.queryMap.Q("FindAllRoles"), map[string]interface{}{})
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	roles := make([]Role, 0)
+	roleMap := make(map[string]*defaultRole)
 	for rows.Next() {
 		role := &defaultRole{}
 		err = rows.StructScan(role)
@@ -40,12 +70,23 @@ func (this *dbBackedRoleRepository) FindAll() ([]Role, error) {
 			return nil, err
 		}
 		roles = append(roles, role)
+		roleMap[role.RoleName] = role
+	}
+	rows.Close()
+	parents, err := this.findParents(ctx, "FindAllRoleParents", map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	for _, parent := range parents {
+		if role, ok := roleMap[parent.RoleName]; ok {
+			role.ParentRoleNames = append(role.ParentRoleNames, parent.ParentRoleName)
+		}
 	}
 	return roles, nil
 }
 
-func (this *dbBackedRoleRepository) FindRole(roleName string) (Role, error) {
-	rows, err := this.ctx.NamedQuery(this.queryMap.Q("FindRole"), map[string]interface{}{"role_name": roleName})
+func (this *dbBackedRoleRepository) FindRoleContext(ctx context.Context, roleName string) (Role, error) {
+	rows, err := namedQueryContext(ctx, this.ctx, this.queryMap.Q("FindRole"), map[string]interface{}{"role_name": roleName})
 	if err != nil {
 		return nil, err
 	}
@@ -56,31 +97,92 @@ func (this *dbBackedRoleRepository) FindRole(roleName string) (Role, error) {
 		if err != nil {
 			return nil, err
 		}
+		rows.Close()
+		parents, err := this.findParents(ctx, "FindRoleParents", map[string]interface{}{"role_name": roleName})
+		if err != nil {
+			return nil, err
+		}
+		for _, parent := range parents {
+			role.ParentRoleNames = append(role.ParentRoleNames, parent.ParentRoleName)
+		}
 		return role, nil
 	}
 	return nil, nil
 }
 
-func (this *dbBackedRoleRepository) CreateRole(role Role) error {
-	_, err := this.ctx.NamedExec(this.queryMap.Q("InsertRole"), role)
+func (this *dbBackedRoleRepository) CreateRoleContext(ctx context.Context, role Role) error {
+	if err := this.validateParents(ctx, role); err != nil {
+		return err
+	}
+	_, err := namedExecContext(ctx, this.ctx, this.queryMap.Q("InsertRole"), role)
 	if err != nil {
 		return err
 	}
-	return nil
+	return this.insertParents(ctx, role)
 }
 
-func (this *dbBackedRoleRepository) UpdateRole(role Role) error {
-	_, err := this.ctx.NamedExec(this.queryMap.Q("UpdateRole"), role)
+func (this *dbBackedRoleRepository) UpdateRoleContext(ctx context.Context, role Role) error {
+	if err := this.validateParents(ctx, role); err != nil {
+		return err
+	}
+	_, err := namedExecContext(ctx, this.ctx, this.queryMap.Q("UpdateRole"), role)
+	if err != nil {
+		return err
+	}
+	_, err = namedExecContext(ctx, this.ctx, this.queryMap.Q("DeleteRoleParents"), map[string]interface{}{"role_name": role.GetName()})
+	if err != nil {
+		return err
+	}
+	return this.insertParents(ctx, role)
+}
+
+func (this *dbBackedRoleRepository) DeleteRoleContext(ctx context.Context, roleName string) error {
+	_, err := namedExecContext(ctx, this.ctx, this.queryMap.Q("DeleteRole"), map[string]interface{}{"role_name": roleName})
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (this *dbBackedRoleRepository) DeleteRole(roleName string) error {
-	_, err := this.ctx.NamedExec(this.queryMap.Q("DeleteRole"), map[string]interface{}{"role_name": roleName})
+func (this *dbBackedRoleRepository) findParents(ctx context.Context, queryName string, args map[string]interface{}) ([]*dbRoleParent, error) {
+	rows, err := namedQueryContext(ctx, this.ctx, this.queryMap.Q(queryName), args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	parents := make([]*dbRoleParent, 0)
+	for rows.Next() {
+		parent := &dbRoleParent{}
+		if err = rows.StructScan(parent); err != nil {
+			return nil, err
+		}
+		parents = append(parents, parent)
+	}
+	return parents, nil
+}
+
+// ensures the role's parents exist and do not form a cycle. Roles without parents are not validated.
+func (this *dbBackedRoleRepository) validateParents(ctx context.Context, role Role) error {
+	if len(parentRoleNames(role)) == 0 {
+		return nil
+	}
+	roles, err := this.FindAllContext(ctx)
 	if err != nil {
 		return err
 	}
+	roleMap := make(map[string]Role)
+	for _, stored := range roles {
+		roleMap[stored.GetName()] = stored
+	}
+	return validateRoleParents(role, roleMap)
+}
+
+func (this *dbBackedRoleRepository) insertParents(ctx context.Context, role Role) error {
+	for _, parentName := range parentRoleNames(role) {
+		args := map[string]interface{}{"role_name": role.GetName(), "parent_role_name": parentName}
+		if _, err := namedExecContext(ctx, this.ctx, this.queryMap.Q("InsertRoleParent"), args); err != nil {
+			return err
+		}
+	}
 	return nil
 }
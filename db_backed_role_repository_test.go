@@ -15,6 +15,8 @@
 package nogo
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/dakiva/dbx"
@@ -90,3 +92,83 @@ func TestRoleDeletion(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Nil(t, role)
 }
+
+func TestRoleQueriesHonorContext(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := AdaptRoleRepository(NewDBBackedRoleRepository(tx, queryMap))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// when
+	_, err := repo.FindAllContext(ctx)
+
+	// then
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestRoleHierarchyPersistence(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedRoleRepository(tx, queryMap)
+	repo.CreateRole(NewRole("employee", 1))
+	repo.CreateRole(NewRole("auditor", 2))
+
+	// when
+	err := repo.CreateRole(NewHierarchicalRole("manager", 4, "employee", "auditor"))
+
+	// then
+	assert.Nil(t, err)
+	role, err := repo.FindRole("manager")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"auditor", "employee"}, role.(HierarchicalRole).GetParentRoleNames())
+	roles, err := repo.FindAll()
+	assert.Nil(t, err)
+	for _, role := range roles {
+		if role.GetName() == "manager" {
+			assert.Equal(t, []string{"auditor", "employee"}, role.(HierarchicalRole).GetParentRoleNames())
+		}
+	}
+	err = repo.UpdateRole(NewHierarchicalRole("manager", 4, "employee"))
+	assert.Nil(t, err)
+	role, _ = repo.FindRole("manager")
+	assert.Equal(t, []string{"employee"}, role.(HierarchicalRole).GetParentRoleNames())
+}
+
+func TestRoleHierarchyCycleDetection(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedRoleRepository(tx, queryMap)
+	repo.CreateRole(NewRole("employee", 1))
+	repo.CreateRole(NewHierarchicalRole("manager", 4, "employee"))
+
+	// when
+	err := repo.UpdateRole(NewHierarchicalRole("employee", 1, "manager"))
+
+	// then
+	assert.NotNil(t, err)
+	assert.NotNil(t, repo.CreateRole(NewHierarchicalRole("director", 8, "missing")))
+}
+
+func TestRoleHighPermissionRoundTrip(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedRoleRepository(tx, queryMap)
+	high := Permission(1 << 63)
+
+	// when
+	err := repo.CreateRole(NewRole("role", high|1))
+
+	// then
+	assert.Nil(t, err)
+	role, err := repo.FindRole("role")
+	assert.Nil(t, err)
+	val, _ := role.HasPermission(high)
+	assert.True(t, val)
+	val, _ = role.HasPermission(2)
+	assert.False(t, val)
+}
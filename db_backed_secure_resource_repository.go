@@ -0,0 +1,413 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dakiva/dbx"
+	"github.com/lib/pq"
+)
+
+type dbBackedSecureResourceRepository struct {
+	ctx      dbx.DBContext
+	queryMap dbx.QueryMap
+}
+
+// Construct a new DB backed SecureResourceRepository. The returned repository also implements ContextSecureResourceRepository, ACLEvaluator and AccessibleResourceFinder using recursive queries.
+func NewDBBackedSecureResourceRepository(ctx dbx.DBContext, queryMap dbx.QueryMap) SecureResourceRepository {
+	return &dbBackedSecureResourceRepository{ctx: ctx, queryMap: queryMap}
+}
+
+// a secure resource row along with its hydrated acl and parent chain
+type dbSecureResource struct {
+	SecureResourceId       int64         `db:"secure_resource_id"`
+	NativeResourceId       string        `db:"native_resource_id"`
+	ParentSecureResourceId sql.NullInt64 `db:"parent_secure_resource_id"`
+	OwnerSid               string        `db:"owner_sid"`
+	InheritParentACL       bool          `db:"inherit_parent_acl"`
+	acl                    ACL
+	parent                 SecureResource
+}
+
+func (this *dbSecureResource) GetNativeId() string {
+	return this.NativeResourceId
+}
+
+func (this *dbSecureResource) GetACL() (ACL, error) {
+	return this.acl, nil
+}
+
+func (this *dbSecureResource) GetParentResource() SecureResource {
+	return this.parent
+}
+
+func (this *dbSecureResource) GetOwnerSid() string {
+	return this.OwnerSid
+}
+
+func (this *dbSecureResource) InheritsParentACL() bool {
+	return this.InheritParentACL
+}
+
+type dbACLEntry struct {
+	PrincipalSid   string       `db:"principal_sid"`
+	PermissionMask Permission   `db:"permission_mask"`
+	IsDeny         bool         `db:"is_deny"`
+	NotBefore      sql.NullTime `db:"not_before"`
+	NotAfter       sql.NullTime `db:"not_after"`
+}
+
+// a resource in the inherited chain joined with one of its matching acl entries, if any
+type dbACLEvaluationRow struct {
+	NativeResourceId string         `db:"native_resource_id"`
+	OwnerSid         string         `db:"owner_sid"`
+	Depth            int            `db:"depth"`
+	PrincipalSid     sql.NullString `db:"principal_sid"`
+	IsDeny           sql.NullBool   `db:"is_deny"`
+}
+
+func (this *dbBackedSecureResourceRepository) FindResource(nativeResourceId string) (SecureResource, error) {
+	return this.FindResourceContext(context.Background(), nativeResourceId)
+}
+
+func (this *dbBackedSecureResourceRepository) CreateResource(resource SecureResource) error {
+	return this.CreateResourceContext(context.Background(), resource)
+}
+
+func (this *dbBackedSecureResourceRepository) UpdateResource(resource SecureResource) error {
+	return this.UpdateResourceContext(context.Background(), resource)
+}
+
+func (this *dbBackedSecureResourceRepository) DeleteResource(nativeResourceId string) error {
+	return this.DeleteResourceContext(context.Background(), nativeResourceId)
+}
+
+func (this *dbBackedSecureResourceRepository) FindResourceContext(ctx context.Context, nativeResourceId string) (SecureResource, error) {
+	resource, err := this.findResourceRow(ctx, "FindSecureResource", map[string]interface{}{"native_resource_id": nativeResourceId})
+	if err != nil {
+		return nil, err
+	}
+	if resource == nil {
+		return nil, fmt.Errorf("Could not find resource %v: %w", nativeResourceId, ErrResourceNotFound)
+	}
+	// hydrate the parent chain, guarding against cycles in the stored hierarchy
+	visited := map[int64]bool{resource.SecureResourceId: true}
+	current := resource
+	for current.ParentSecureResourceId.Valid {
+		parentId := current.ParentSecureResourceId.Int64
+		if visited[parentId] {
+			return nil, errors.New(fmt.Sprintf("Resource %v has a cyclic parent hierarchy", nativeResourceId))
+		}
+		visited[parentId] = true
+		parent, err := this.findResourceRow(ctx, "FindSecureResourceById", map[string]interface{}{"secure_resource_id": parentId})
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil {
+			return nil, errors.New(fmt.Sprintf("Could not find parent resource for %v", current.NativeResourceId))
+		}
+		current.parent = parent
+		current = parent
+	}
+	return resource, nil
+}
+
+func (this *dbBackedSecureResourceRepository) CreateResourceContext(ctx context.Context, resource SecureResource) error {
+	existing, err := this.findResourceRow(ctx, "FindSecureResource", map[string]interface{}{"native_resource_id": resource.GetNativeId()})
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return errors.New(fmt.Sprintf("Error creating resource. Resource %v already exists.", resource.GetNativeId()))
+	}
+	args, err := this.resourceArgs(ctx, resource)
+	if err != nil {
+		return err
+	}
+	// the resource row and its entries are inserted together so a failure never leaves a resource with a partial ACL
+	return inTransaction(ctx, this.ctx, func(tx dbx.DBContext) error {
+		rows, err := namedQueryContext(ctx, tx, this.queryMap.Q("InsertSecureResource"), args)
+		if err != nil {
+			return err
+		}
+		var secureResourceId int64
+		if rows.Next() {
+			err = rows.Scan(&secureResourceId)
+		} else if err = rows.Err(); err == nil {
+			err = errors.New(fmt.Sprintf("Error creating resource. No id was returned for resource %v.", resource.GetNativeId()))
+		}
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		return this.insertACLEntries(ctx, tx, secureResourceId, resource)
+	})
+}
+
+func (this *dbBackedSecureResourceRepository) UpdateResourceContext(ctx context.Context, resource SecureResource) error {
+	existing, err := this.findResourceRow(ctx, "FindSecureResource", map[string]interface{}{"native_resource_id": resource.GetNativeId()})
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return errors.New(fmt.Sprintf("Error updating resource. Resource %v does not exist.", resource.GetNativeId()))
+	}
+	args, err := this.resourceArgs(ctx, resource)
+	if err != nil {
+		return err
+	}
+	// the entries are replaced within a transaction so a failure leaves the previous ACL in place
+	return inTransaction(ctx, this.ctx, func(tx dbx.DBContext) error {
+		if _, err := namedExecContext(ctx, tx, this.queryMap.Q("UpdateSecureResource"), args); err != nil {
+			return err
+		}
+		if _, err := namedExecContext(ctx, tx, this.queryMap.Q("DeleteACLEntries"), map[string]interface{}{"secure_resource_id": existing.SecureResourceId}); err != nil {
+			return err
+		}
+		return this.insertACLEntries(ctx, tx, existing.SecureResourceId, resource)
+	})
+}
+
+func (this *dbBackedSecureResourceRepository) DeleteResourceContext(ctx context.Context, nativeResourceId string) error {
+	result, err := namedExecContext(ctx, this.ctx, this.queryMap.Q("DeleteSecureResource"), map[string]interface{}{"native_resource_id": nativeResourceId})
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return errors.New(fmt.Sprintf("Error deleting resource. Resource %v does not exist.", nativeResourceId))
+	}
+	return nil
+}
+
+// loads a single resource row and its acl entries. Returns nil if the row does not exist.
+func (this *dbBackedSecureResourceRepository) findResourceRow(ctx context.Context, queryName string, args map[string]interface{}) (*dbSecureResource, error) {
+	rows, err := namedQueryContext(ctx, this.ctx, this.queryMap.Q(queryName), args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, nil
+	}
+	resource := &dbSecureResource{}
+	if err = rows.StructScan(resource); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	acl, err := this.findACL(ctx, resource.SecureResourceId)
+	if err != nil {
+		return nil, err
+	}
+	resource.acl = acl
+	return resource, nil
+}
+
+func (this *dbBackedSecureResourceRepository) findACL(ctx context.Context, secureResourceId int64) (ACL, error) {
+	rows, err := namedQueryContext(ctx, this.ctx, this.queryMap.Q("FindACLEntries"), map[string]interface{}{"secure_resource_id": secureResourceId})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	acl := NewACL()
+	for rows.Next() {
+		entry := &dbACLEntry{}
+		if err = rows.StructScan(entry); err != nil {
+			return nil, err
+		}
+		var ace ACE
+		if entry.NotBefore.Valid || entry.NotAfter.Valid {
+			if entry.IsDeny {
+				ace = NewTimeBoundDenyACE(entry.PrincipalSid, entry.PermissionMask, entry.NotBefore.Time, entry.NotAfter.Time)
+			} else {
+				ace = NewTimeBoundACE(entry.PrincipalSid, entry.PermissionMask, entry.NotBefore.Time, entry.NotAfter.Time)
+			}
+		} else if entry.IsDeny {
+			ace = NewDenyACE(entry.PrincipalSid, entry.PermissionMask)
+		} else {
+			ace = NewACE(entry.PrincipalSid, entry.PermissionMask)
+		}
+		if err = acl.AddACE(ace); err != nil {
+			return nil, err
+		}
+	}
+	return acl, nil
+}
+
+// builds the named arguments for inserting or updating a secure resource row, resolving the parent by its native id.
+func (this *dbBackedSecureResourceRepository) resourceArgs(ctx context.Context, resource SecureResource) (map[string]interface{}, error) {
+	args := map[string]interface{}{
+		"native_resource_id":        resource.GetNativeId(),
+		"parent_secure_resource_id": nil,
+		"owner_sid":                 resource.GetOwnerSid(),
+		"inherit_parent_acl":        resource.InheritsParentACL(),
+	}
+	if parent := resource.GetParentResource(); parent != nil {
+		rows, err := namedQueryContext(ctx, this.ctx, this.queryMap.Q("FindSecureResourceId"), map[string]interface{}{"native_resource_id": parent.GetNativeId()})
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		if !rows.Next() {
+			return nil, errors.New(fmt.Sprintf("Parent resource %v does not exist.", parent.GetNativeId()))
+		}
+		var parentId int64
+		if err = rows.Scan(&parentId); err != nil {
+			return nil, err
+		}
+		args["parent_secure_resource_id"] = parentId
+	}
+	return args, nil
+}
+
+func (this *dbBackedSecureResourceRepository) insertACLEntries(ctx context.Context, tx dbx.DBContext, secureResourceId int64, resource SecureResource) error {
+	acl, err := resource.GetACL()
+	if err != nil {
+		return err
+	}
+	if acl == nil {
+		return nil
+	}
+	aces, err := acl.GetACEs()
+	if err != nil {
+		return err
+	}
+	for _, ace := range aces {
+		mask := EmptyPermissionMask
+		for _, permission := range ace.GetPermissions() {
+			mask |= permission
+		}
+		args := map[string]interface{}{
+			"secure_resource_id": secureResourceId,
+			"principal_sid":      ace.GetSid(),
+			"permission_mask":    mask,
+			"is_deny":            isDenyACE(ace),
+			"not_before":         nil,
+			"not_after":          nil,
+		}
+		if timeBound, ok := ace.(TimeBoundACE); ok {
+			args["not_before"] = nullableTime(timeBound.GetNotBefore())
+			args["not_after"] = nullableTime(timeBound.GetNotAfter())
+		}
+		if _, err = namedExecContext(ctx, tx, this.queryMap.Q("InsertACLEntry"), args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *dbBackedSecureResourceRepository) EvaluateACL(ctx context.Context, sid string, groupSids []string, permission Permission, nativeResourceId string, at time.Time) (*ACLEvaluation, error) {
+	sids := entrySids(sid, groupSids)
+	args := map[string]interface{}{
+		"native_resource_id": nativeResourceId,
+		"principal_sids":     pq.StringArray(sids),
+		"permission_mask":    permission,
+		"now":                at,
+	}
+	rows, err := namedQueryContext(ctx, this.ctx, this.queryMap.Q("EvaluateSecureResourceACL"), args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	evaluation := &ACLEvaluation{ResourcesConsulted: make([]string, 0)}
+	// rows are ordered by depth, so the first resource with a matching entry decides. Its entries are ranked
+	// deny before allow, and then in the order of precedence of their sids.
+	found := false
+	decidingDepth, bestRank := -1, 0
+	for rows.Next() {
+		row := &dbACLEvaluationRow{}
+		if err = rows.StructScan(row); err != nil {
+			return nil, err
+		}
+		if decidingDepth >= 0 && row.Depth > decidingDepth {
+			break
+		}
+		if !found {
+			found = true
+			evaluation.OwnerSid = row.OwnerSid
+		}
+		consulted := evaluation.ResourcesConsulted
+		if len(consulted) == 0 || consulted[len(consulted)-1] != row.NativeResourceId {
+			evaluation.ResourcesConsulted = append(consulted, row.NativeResourceId)
+		}
+		if !row.PrincipalSid.Valid {
+			continue
+		}
+		rank := len(sids)
+		for i, entrySid := range sids {
+			if entrySid == row.PrincipalSid.String {
+				rank -= i
+				break
+			}
+		}
+		if row.IsDeny.Bool {
+			rank += len(sids)
+		}
+		if rank > bestRank {
+			decidingDepth, bestRank = row.Depth, rank
+			evaluation.Matched = true
+			evaluation.Deny = row.IsDeny.Bool
+			evaluation.Sid = row.PrincipalSid.String
+			evaluation.DecidingResourceId = row.NativeResourceId
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("Could not find resource %v: %w", nativeResourceId, ErrResourceNotFound)
+	}
+	return evaluation, nil
+}
+
+func (this *dbBackedSecureResourceRepository) FindAccessibleResourceIds(ctx context.Context, sid string, groupSids []string, permission Permission, afterResourceId string, limit int, at time.Time) ([]string, error) {
+	args := map[string]interface{}{
+		"principal_sid":     sid,
+		"principal_sids":    pq.StringArray(entrySids(sid, groupSids)),
+		"permission_mask":   permission,
+		"after_resource_id": afterResourceId,
+		"limit":             nil,
+		"now":               at,
+	}
+	if limit > 0 {
+		args["limit"] = limit
+	}
+	// the accessible ids are computed by the same query ACL predicates are built from, and paged through here
+	query := "SELECT native_resource_id FROM (" + this.queryMap.Q("AccessibleSecureResourceIds") + ") accessible WHERE native_resource_id > :after_resource_id ORDER BY native_resource_id LIMIT :limit"
+	return namedQueryStrings(ctx, this.ctx, query, args)
+}
+
+func (this *dbBackedSecureResourceRepository) PurgeExpiredACEs(ctx context.Context, before time.Time) (int, error) {
+	result, err := namedExecContext(ctx, this.ctx, this.queryMap.Q("DeleteExpiredACLEntries"), map[string]interface{}{"before": before})
+	if err != nil {
+		return 0, err
+	}
+	count, err := result.RowsAffected()
+	return int(count), err
+}
+
+// returns nil for the zero time, which is stored as an open bound.
+func nullableTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
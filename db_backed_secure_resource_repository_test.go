@@ -0,0 +1,340 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dakiva/dbx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceCreation(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedSecureResourceRepository(tx, queryMap)
+	acl := NewACL()
+	acl.AddACE(NewACE("sid", 3))
+	acl.AddACE(NewACE(WorldSid, 1))
+	resource := &mockResource{nativeId: "resource", acl: acl, owner: "owner"}
+
+	// when
+	err := repo.CreateResource(resource)
+
+	// then
+	assert.Nil(t, err)
+	found, err := repo.FindResource("resource")
+	assert.Nil(t, err)
+	assert.Equal(t, "resource", found.GetNativeId())
+	assert.Equal(t, "owner", found.GetOwnerSid())
+	assert.False(t, found.InheritsParentACL())
+	assert.Nil(t, found.GetParentResource())
+	foundACL, err := found.GetACL()
+	assert.Nil(t, err)
+	aces, err := foundACL.GetACEs()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(aces))
+	ace, err := foundACL.GetACEForSid("sid")
+	assert.Nil(t, err)
+	val, err := ace.HasPermission(2)
+	assert.Nil(t, err)
+	assert.True(t, val)
+}
+
+func TestResourceDenyEntryRoundTrip(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedSecureResourceRepository(tx, queryMap)
+	acl := NewACL()
+	acl.AddACE(NewACE("sid", 3))
+	acl.AddACE(NewDenyACE("sid", 2))
+
+	// when
+	err := repo.CreateResource(&mockResource{nativeId: "resource", acl: acl, owner: "owner"})
+
+	// then
+	assert.Nil(t, err)
+	found, err := repo.FindResource("resource")
+	assert.Nil(t, err)
+	foundACL, _ := found.GetACL()
+	ace, _ := foundACL.GetACEForSid("sid")
+	assert.False(t, isDenyACE(ace))
+	ace, _ = foundACL.(DenyACL).GetDenyACEForSid("sid")
+	assert.True(t, isDenyACE(ace))
+	val, _ := ace.HasPermission(2)
+	assert.True(t, val)
+}
+
+func TestDuplicateResourceCreation(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedSecureResourceRepository(tx, queryMap)
+	repo.CreateResource(&mockResource{nativeId: "resource", acl: NewACL(), owner: "owner"})
+
+	// when
+	err := repo.CreateResource(&mockResource{nativeId: "resource", acl: NewACL(), owner: "owner"})
+
+	// then
+	assert.NotNil(t, err)
+}
+
+func TestResourceParentHydration(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedSecureResourceRepository(tx, queryMap)
+	grandparent := &mockResource{nativeId: "grandparent", acl: NewACL(), owner: "owner"}
+	parent := &mockResource{nativeId: "parent", acl: NewACL(), owner: "owner", parent: grandparent, inheritACL: true}
+	child := &mockResource{nativeId: "child", acl: NewACL(), owner: "owner", parent: parent, inheritACL: true}
+	repo.CreateResource(grandparent)
+	repo.CreateResource(parent)
+
+	// when
+	err := repo.CreateResource(child)
+
+	// then
+	assert.Nil(t, err)
+	found, err := repo.FindResource("child")
+	assert.Nil(t, err)
+	assert.True(t, found.InheritsParentACL())
+	assert.Equal(t, "parent", found.GetParentResource().GetNativeId())
+	assert.Equal(t, "grandparent", found.GetParentResource().GetParentResource().GetNativeId())
+	assert.Nil(t, found.GetParentResource().GetParentResource().GetParentResource())
+}
+
+func TestResourceCreationWithMissingParent(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedSecureResourceRepository(tx, queryMap)
+	parent := &mockResource{nativeId: "parent", acl: NewACL(), owner: "owner"}
+
+	// when
+	err := repo.CreateResource(&mockResource{nativeId: "child", acl: NewACL(), owner: "owner", parent: parent})
+
+	// then
+	assert.NotNil(t, err)
+}
+
+func TestResourceUpdate(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedSecureResourceRepository(tx, queryMap)
+	acl := NewACL()
+	acl.AddACE(NewACE("sid", 1))
+	repo.CreateResource(&mockResource{nativeId: "resource", acl: acl, owner: "owner"})
+
+	// when
+	updatedACL := NewACL()
+	updatedACL.AddACE(NewACE("sid2", 4))
+	err := repo.UpdateResource(&mockResource{nativeId: "resource", acl: updatedACL, owner: "owner2", inheritACL: true})
+
+	// then
+	assert.Nil(t, err)
+	found, err := repo.FindResource("resource")
+	assert.Nil(t, err)
+	assert.Equal(t, "owner2", found.GetOwnerSid())
+	assert.True(t, found.InheritsParentACL())
+	foundACL, _ := found.GetACL()
+	ace, _ := foundACL.GetACEForSid("sid")
+	assert.Nil(t, ace)
+	ace, _ = foundACL.GetACEForSid("sid2")
+	assert.NotNil(t, ace)
+}
+
+func TestNonExistentResourceUpdate(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedSecureResourceRepository(tx, queryMap)
+
+	// when
+	err := repo.UpdateResource(&mockResource{nativeId: "resource", acl: NewACL(), owner: "owner"})
+
+	// then
+	assert.NotNil(t, err)
+}
+
+func TestResourceDeletion(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedSecureResourceRepository(tx, queryMap)
+	acl := NewACL()
+	acl.AddACE(NewACE("sid", 1))
+	repo.CreateResource(&mockResource{nativeId: "resource", acl: acl, owner: "owner"})
+
+	// when
+	err := repo.DeleteResource("resource")
+
+	// then
+	assert.Nil(t, err)
+	found, err := repo.FindResource("resource")
+	assert.NotNil(t, err)
+	assert.Nil(t, found)
+	err = repo.DeleteResource("resource")
+	assert.NotNil(t, err)
+}
+
+func TestResourceACLEvaluation(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedSecureResourceRepository(tx, queryMap)
+	rootACL := NewACL()
+	rootACL.AddACE(NewACE(WorldSid, 1))
+	rootACL.AddACE(NewACE("sid", 2))
+	root := &mockResource{nativeId: "root", acl: rootACL, owner: "owner"}
+	folderACL := NewACL()
+	folderACL.AddACE(NewACE("sid", 2))
+	folderACL.AddACE(NewDenyACE(WorldSid, 2))
+	folderACL.AddACE(NewACE("group", 4))
+	folder := &mockResource{nativeId: "folder", acl: folderACL, owner: "owner", parent: root, inheritACL: true}
+	document := &mockResource{nativeId: "document", acl: NewACL(), owner: "documentOwner", parent: folder, inheritACL: true}
+	detached := &mockResource{nativeId: "detached", acl: NewACL(), owner: "owner", parent: root}
+	for _, resource := range []SecureResource{root, folder, document, detached} {
+		assert.Nil(t, repo.CreateResource(resource))
+	}
+	evaluator := repo.(ACLEvaluator)
+
+	// when
+	worldRead, worldErr := evaluator.EvaluateACL(context.Background(), "sid", nil, 1, "document", time.Now())
+	deniedUpdate, _ := evaluator.EvaluateACL(context.Background(), "sid", nil, 2, "document", time.Now())
+	noMatch, _ := evaluator.EvaluateACL(context.Background(), "sid", nil, 4, "document", time.Now())
+	notInherited, _ := evaluator.EvaluateACL(context.Background(), "sid", nil, 1, "detached", time.Now())
+	_, missingErr := evaluator.EvaluateACL(context.Background(), "sid", nil, 1, "missing", time.Now())
+	groupUpdate, _ := evaluator.EvaluateACL(context.Background(), "member", []string{"group"}, 4, "document", time.Now())
+
+	// then
+	assert.Nil(t, worldErr)
+	assert.Equal(t, "documentOwner", worldRead.OwnerSid)
+	assert.True(t, worldRead.Matched)
+	assert.False(t, worldRead.Deny)
+	assert.Equal(t, WorldSid, worldRead.Sid)
+	assert.Equal(t, "root", worldRead.DecidingResourceId)
+	assert.Equal(t, []string{"document", "folder", "root"}, worldRead.ResourcesConsulted)
+	assert.True(t, deniedUpdate.Matched)
+	assert.True(t, deniedUpdate.Deny)
+	assert.Equal(t, WorldSid, deniedUpdate.Sid)
+	assert.Equal(t, "folder", deniedUpdate.DecidingResourceId)
+	assert.Equal(t, []string{"document", "folder"}, deniedUpdate.ResourcesConsulted)
+	assert.False(t, noMatch.Matched)
+	assert.Equal(t, []string{"document", "folder", "root"}, noMatch.ResourcesConsulted)
+	assert.False(t, notInherited.Matched)
+	assert.Equal(t, []string{"detached"}, notInherited.ResourcesConsulted)
+	assert.True(t, errors.Is(missingErr, ErrResourceNotFound))
+	assert.True(t, groupUpdate.Matched)
+	assert.Equal(t, "group", groupUpdate.Sid)
+	assert.Equal(t, "folder", groupUpdate.DecidingResourceId)
+}
+
+func TestFindAccessibleResourceIds(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedSecureResourceRepository(tx, queryMap)
+	createAccessibleResources(t, repo)
+	finder := repo.(AccessibleResourceFinder)
+
+	// when
+	all, err := finder.FindAccessibleResourceIds(context.Background(), "sid", nil, 1, "", 0, time.Now())
+	page, _ := finder.FindAccessibleResourceIds(context.Background(), "sid", nil, 1, "direct", 2, time.Now())
+	owned, _ := finder.FindAccessibleResourceIds(context.Background(), "sid", nil, 2, "", 0, time.Now())
+	grouped, _ := finder.FindAccessibleResourceIds(context.Background(), "sid", []string{"group"}, 2, "", 0, time.Now())
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"direct", "mine", "root", "shared"}, all)
+	assert.Equal(t, []string{"mine", "root"}, page)
+	assert.Equal(t, []string{"mine"}, owned)
+	assert.Equal(t, []string{"doc", "folder", "mine"}, grouped)
+}
+
+func TestTimeBoundACLEntries(t *testing.T) {
+	// given
+	tx, _ := testdb.Beginx()
+	defer tx.Rollback()
+	repo := NewDBBackedSecureResourceRepository(tx, queryMap)
+	now := time.Now()
+	resource := NewSecureResource("document", "owner", nil, false)
+	acl, _ := resource.GetACL()
+	acl.AddACE(NewACE("permanent", 1))
+	acl.AddACE(NewTimeBoundACE("oncall", 1, now.Add(-time.Hour), now.Add(time.Hour)))
+	acl.AddACE(NewTimeBoundACE("expired", 1, time.Time{}, now.Add(-time.Hour)))
+	acl.AddACE(NewTimeBoundDenyACE("oncall", 2, now.Add(time.Hour), time.Time{}))
+
+	// when
+	err := repo.CreateResource(resource)
+
+	// then
+	assert.Nil(t, err)
+	stored, _ := repo.FindResource("document")
+	acl, _ = stored.GetACL()
+	ace, _ := acl.GetACEForSid("oncall")
+	assert.True(t, now.Add(-time.Hour).Equal(ace.(TimeBoundACE).GetNotBefore()))
+	assert.True(t, now.Add(time.Hour).Equal(ace.(TimeBoundACE).GetNotAfter()))
+	ace, _ = acl.(DenyACL).GetDenyACEForSid("oncall")
+	assert.True(t, ace.(TimeBoundACE).GetNotAfter().IsZero())
+	ace, _ = acl.GetACEForSid("permanent")
+	_, ok := ace.(TimeBoundACE)
+	assert.False(t, ok)
+
+	evaluator := repo.(ACLEvaluator)
+	active, _ := evaluator.EvaluateACL(context.Background(), "oncall", nil, 1, "document", now)
+	assert.True(t, active.Matched)
+	later, _ := evaluator.EvaluateACL(context.Background(), "oncall", nil, 1, "document", now.Add(2*time.Hour))
+	assert.False(t, later.Matched)
+	expired, _ := evaluator.EvaluateACL(context.Background(), "expired", nil, 1, "document", now)
+	assert.False(t, expired.Matched)
+	scheduledDeny, _ := evaluator.EvaluateACL(context.Background(), "oncall", nil, 2, "document", now)
+	assert.False(t, scheduledDeny.Matched)
+	ids, _ := repo.(AccessibleResourceFinder).FindAccessibleResourceIds(context.Background(), "expired", nil, 1, "", 0, now)
+	assert.Equal(t, 0, len(ids))
+	ids, _ = repo.(AccessibleResourceFinder).FindAccessibleResourceIds(context.Background(), "oncall", nil, 1, "", 0, now.Add(2*time.Hour))
+	assert.Equal(t, 0, len(ids))
+
+	count, err := repo.(ExpiredACEPurger).PurgeExpiredACEs(context.Background(), now)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	stored, _ = repo.FindResource("document")
+	acl, _ = stored.GetACL()
+	aces, _ := acl.GetACEs()
+	assert.Equal(t, 3, len(aces))
+}
+
+func TestInTransactionRollsBackOnFailure(t *testing.T) {
+	// given
+	failure := errors.New("failed")
+
+	// when
+	err := inTransaction(context.Background(), testdb, func(tx dbx.DBContext) error {
+		if err := NewDBBackedRoleRepository(tx, queryMap).CreateRole(NewRole("rolled back", 1)); err != nil {
+			return err
+		}
+		return failure
+	})
+
+	// then
+	assert.Equal(t, failure, err)
+	_, err = NewDBBackedRoleRepository(testdb, queryMap).FindRole("rolled back")
+	assert.NotNil(t, err)
+}
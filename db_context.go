@@ -0,0 +1,80 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/dakiva/dbx"
+	"github.com/jmoiron/sqlx"
+)
+
+// Runs a named query using the context. Both *sqlx.DB and *sqlx.Tx honor the context, other DBContext implementations only fail fast if the context is already done.
+func namedQueryContext(ctx context.Context, db dbx.DBContext, query string, arg interface{}) (*sqlx.Rows, error) {
+	if ext, ok := db.(sqlx.ExtContext); ok {
+		return sqlx.NamedQueryContext(ctx, ext, query, arg)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return db.NamedQuery(query, arg)
+}
+
+// Executes a named statement using the context. Both *sqlx.DB and *sqlx.Tx honor the context, other DBContext implementations only fail fast if the context is already done.
+func namedExecContext(ctx context.Context, db dbx.DBContext, query string, arg interface{}) (sql.Result, error) {
+	if ext, ok := db.(sqlx.ExtContext); ok {
+		return sqlx.NamedExecContext(ctx, ext, query, arg)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return db.NamedExec(query, arg)
+}
+
+// Runs a named query returning a single string column using the context, returning the values in order. May return an empty value.
+func namedQueryStrings(ctx context.Context, db dbx.DBContext, query string, arg interface{}) ([]string, error) {
+	rows, err := namedQueryContext(ctx, db, query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := make([]string, 0)
+	for rows.Next() {
+		var value string
+		if err = rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// Runs fn with a DBContext whose statements form a single transaction. A *sqlx.DB begins a transaction that is committed if fn succeeds and rolled back otherwise. Any other DBContext, such as a *sqlx.Tx, is assumed to be managed by the caller and is passed to fn as is.
+func inTransaction(ctx context.Context, db dbx.DBContext, fn func(tx dbx.DBContext) error) error {
+	sqlDB, ok := db.(*sqlx.DB)
+	if !ok {
+		return fn(db)
+	}
+	tx, err := sqlDB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
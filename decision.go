@@ -0,0 +1,123 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import "context"
+
+// Identifies the rule that decided an access check.
+type DecisionRule int
+
+const (
+	// No rule matched, so access was denied by default.
+	NoMatchingRule DecisionRule = iota
+	// The principal owns the resource.
+	OwnerRule
+	// The principal has an admin role and full admin access is allowed.
+	AdminRule
+	// One of the principal's roles contains the permission.
+	RoleRule
+	// An entry for the principal's sid matched the permission.
+	SidACERule
+	// An entry for the WorldSid matched the permission.
+	WorldSidACERule
+	// An entry for one of the principal's group sids matched the permission.
+	GroupSidACERule
+)
+
+func (this DecisionRule) String() string {
+	switch this {
+	case OwnerRule:
+		return "owner"
+	case AdminRule:
+		return "admin"
+	case RoleRule:
+		return "role"
+	case SidACERule:
+		return "sid ace"
+	case WorldSidACERule:
+		return "world sid ace"
+	case GroupSidACERule:
+		return "group sid ace"
+	}
+	return "no matching rule"
+}
+
+// A structured explanation of an access check, describing the outcome, the rule that decided it and everything consulted along the way.
+type Decision struct {
+	// True if access is granted.
+	Granted bool
+	// The rule that decided the outcome.
+	Rule DecisionRule
+	// The id of the principal being checked.
+	PrincipalId string
+	// The permission being checked.
+	Permission Permission
+	// The native id of the resource being checked. Empty for role checks.
+	ResourceId string
+	// The name of the deciding role for RoleRule and AdminRule decisions.
+	RoleName string
+	// The sid of the deciding entry for SidACERule, GroupSidACERule and WorldSidACERule decisions.
+	Sid string
+	// True if the deciding entry is a deny entry.
+	Deny bool
+	// The native id of the resource whose ACL decided the outcome. Differs from ResourceId when the entry was inherited from an ancestor.
+	DecidingResourceId string
+	// The names of the principal's roles that were consulted, in order.
+	RolesConsulted []string
+	// The native ids of the resources whose ACLs were consulted, in order from the resource to its farthest ancestor.
+	ResourcesConsulted []string
+}
+
+// Returns true if the deciding entry was inherited from an ancestor of the resource being checked.
+func (this *Decision) IsInherited() bool {
+	return this.DecidingResourceId != "" && this.DecidingResourceId != this.ResourceId
+}
+
+// records the entry that decided a resource check.
+func (this *Decision) applyACE(deny bool, sid string, principalSid string, decidingResourceId string) {
+	this.Granted = !deny
+	this.Deny = deny
+	this.Sid = sid
+	this.DecidingResourceId = decidingResourceId
+	switch sid {
+	case principalSid:
+		this.Rule = SidACERule
+	case WorldSid:
+		this.Rule = WorldSidACERule
+	default:
+		this.Rule = GroupSidACERule
+	}
+}
+
+type decisionObserverKey struct{}
+
+// Returns a context that makes the Verify checks of the default strategy report the Decision they were made with to the observer, letting decorators such as an auditing strategy describe a check without evaluating it a second time. Observers added by enclosing decorators are called as well. Strategies that do not report decisions never call the observer.
+func WithDecisionObserver(ctx context.Context, observer func(decision *Decision)) context.Context {
+	if enclosing, ok := ctx.Value(decisionObserverKey{}).(func(*Decision)); ok {
+		inner := observer
+		observer = func(decision *Decision) {
+			inner(decision)
+			enclosing(decision)
+		}
+	}
+	return context.WithValue(ctx, decisionObserverKey{}, observer)
+}
+
+// reports the decision to the observers carried by the context, if any.
+func observeDecision(ctx context.Context, decision *Decision) {
+	if observer, ok := ctx.Value(decisionObserverKey{}).(func(*Decision)); ok {
+		observer(decision)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// Matches, via errors.Is, any error returned because a principal does not have access.
+	ErrAccessDenied = errors.New("access denied")
+	// Matches, via errors.Is, any error returned because a secure resource does not exist. Repositories should wrap this error when a resource can not be found.
+	ErrResourceNotFound = errors.New("resource not found")
+	// Matches, via errors.Is, any error returned because a repository or ACL lookup failed while verifying access.
+	ErrRepository = errors.New("repository error")
+	// Matches, via errors.Is, any error returned by a change tracking repository because a change was made but could not be recorded.
+	ErrChangeNotRecorded = errors.New("change not recorded")
+)
+
+// Returned when a principal does not have access to a permission or resource.
+type AccessDeniedError struct {
+	// The id of the principal that was denied access.
+	PrincipalId string
+	// The permission the principal was denied.
+	Permission Permission
+	// The native id of the resource the principal was denied access to. Empty for role checks.
+	ResourceId string
+}
+
+func (this *AccessDeniedError) Error() string {
+	if this.ResourceId == "" {
+		return fmt.Sprintf("Principal %v does not have access", this.PrincipalId)
+	}
+	return fmt.Sprintf("Principal %v does not have access to the resource %v.", this.PrincipalId, this.ResourceId)
+}
+
+// Returns true if the target is ErrAccessDenied.
+func (this *AccessDeniedError) Is(target error) bool {
+	return target == ErrAccessDenied
+}
+
+// Returned when access could not be verified because a repository or ACL lookup failed. The underlying error is available via errors.Unwrap.
+type RepositoryError struct {
+	Err error
+}
+
+func (this *RepositoryError) Error() string {
+	return fmt.Sprintf("Could not verify access: %v", this.Err)
+}
+
+// Returns true if the target is ErrRepository.
+func (this *RepositoryError) Is(target error) bool {
+	return target == ErrRepository
+}
+
+func (this *RepositoryError) Unwrap() error {
+	return this.Err
+}
+
+// Returned by change tracking repositories when the underlying repository made a change but the change history could not record it. The underlying error is available via errors.Unwrap.
+type ChangeNotRecordedError struct {
+	// The kind of change that was made.
+	Operation ChangeOperation
+	Err       error
+}
+
+func (this *ChangeNotRecordedError) Error() string {
+	return fmt.Sprintf("The %v change was made but could not be recorded: %v", this.Operation, this.Err)
+}
+
+// Returns true if the target is ErrChangeNotRecorded.
+func (this *ChangeNotRecordedError) Is(target error) bool {
+	return target == ErrChangeNotRecorded
+}
+
+func (this *ChangeNotRecordedError) Unwrap() error {
+	return this.Err
+}
+
+// wraps a lookup failure in a RepositoryError. Errors reporting a missing resource are returned as is.
+func wrapRepositoryError(err error) error {
+	if err == nil || errors.Is(err, ErrResourceNotFound) {
+		return err
+	}
+	return &RepositoryError{Err: err}
+}
@@ -0,0 +1,74 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import "context"
+
+// Optionally implemented by a Principal that belongs to groups. ACL entries for any of the group sids apply to the principal.
+type GroupPrincipal interface {
+	Principal
+	// Returns the security identifiers of the groups the principal belongs to. May return an empty value.
+	GetGroupSids() []string
+}
+
+// Resolves the groups a principal belongs to, for principals that do not carry their group sids.
+type GroupResolver interface {
+	// Returns the security identifiers of the groups the principal belongs to, or an error if the groups could not be resolved.
+	ResolveGroupSids(ctx context.Context, principal Principal) ([]string, error)
+}
+
+// Resolves the group sids of each principal through the resolver, in addition to any returned by GroupPrincipal.GetGroupSids.
+func WithGroupResolver(resolver GroupResolver) StrategyOption {
+	return func(strategy *defaultAccessControlStrategy) {
+		strategy.groupResolver = resolver
+	}
+}
+
+// returns the distinct group sids of the principal, excluding the principal's own sid and the WorldSid.
+func (this *defaultAccessControlStrategy) findGroupSids(ctx context.Context, principal Principal) ([]string, error) {
+	groupSids := make([]string, 0)
+	if groupPrincipal, ok := principal.(GroupPrincipal); ok {
+		groupSids = append(groupSids, groupPrincipal.GetGroupSids()...)
+	}
+	if this.groupResolver != nil {
+		resolved, err := this.groupResolver.ResolveGroupSids(ctx, principal)
+		if err != nil {
+			return nil, err
+		}
+		groupSids = append(groupSids, resolved...)
+	}
+	return distinctGroupSids(principal.GetSid(), groupSids), nil
+}
+
+// removes duplicates, empty sids, the principal's own sid and the WorldSid, preserving order.
+func distinctGroupSids(sid string, groupSids []string) []string {
+	seen := map[string]bool{"": true, sid: true, WorldSid: true}
+	distinct := make([]string, 0, len(groupSids))
+	for _, groupSid := range groupSids {
+		if !seen[groupSid] {
+			seen[groupSid] = true
+			distinct = append(distinct, groupSid)
+		}
+	}
+	return distinct
+}
+
+// returns the sids whose entries apply to a principal, in order of precedence: the principal's own sid, its group sids and the WorldSid.
+func entrySids(sid string, groupSids []string) []string {
+	sids := make([]string, 0, len(groupSids)+2)
+	sids = append(sids, sid)
+	sids = append(sids, distinctGroupSids(sid, groupSids)...)
+	return append(sids, WorldSid)
+}
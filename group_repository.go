@@ -0,0 +1,45 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package nogo
+
+import "context"
+
+// A repository for managing groups of principals, keyed by group security identifier. Groups are nested by adding the sid of one group as a member of another.
+type GroupRepository interface {
+	// Creates a new group. Returns an error if the group could not be created, or already exists.
+	CreateGroup(groupSid string) error
+	// Deletes a group along with its members and its membership of other groups. Returns an error if the group could not be deleted, or does not exist.
+	DeleteGroup(groupSid string) error
+	// Adds a principal or group sid as a member of the group. Returns an error if the member could not be added, if the group does not exist, if the sid is already a member, or if the group would become a member of itself.
+	AddMember(groupSid string, memberSid string) error
+	// Removes a member from the group. Returns an error if the member could not be removed, or is not a member of the group.
+	RemoveMember(groupSid string, memberSid string) error
+	// Returns the sids of the direct members of the group. May return an empty value. Returns an error if the members could not be retrieved.
+	FindMembers(groupSid string) ([]string, error)
+	// Returns the sids of all groups the sid belongs to, either directly or through nested groups. May return an empty value. Returns an error if the groups could not be retrieved.
+	FindGroupSidsForSid(sid string) ([]string, error)
+}
+
+// Resolves the group sids of each principal, including nested groups, through the group repository using the context of each check. Replaces any resolver given with WithGroupResolver.
+func WithGroupRepository(groupRepo GroupRepository) StrategyOption {
+	return WithGroupResolver(&groupRepositoryResolver{AdaptGroupRepository(groupRepo)})
+}
+
+type groupRepositoryResolver struct {
+	groupRepository ContextGroupRepository
+}
+
+func (this *groupRepositoryResolver) ResolveGroupSids(ctx context.Context, principal Principal) ([]string, error) {
+	return this.groupRepository.FindGroupSidsForSidContext(ctx, principal.GetSid())
+}
@@ -0,0 +1,98 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"sync"
+)
+
+type mapBackedChangeHistory struct {
+	lock    *sync.RWMutex
+	records []*ChangeRecord
+}
+
+// Construct a new in-memory ChangeHistory.
+func NewMapBackedChangeHistory() ChangeHistory {
+	return &mapBackedChangeHistory{lock: &sync.RWMutex{}, records: make([]*ChangeRecord, 0)}
+}
+
+func (this *mapBackedChangeHistory) AppendChange(ctx context.Context, record *ChangeRecord) error {
+	return this.AppendChanges(ctx, []*ChangeRecord{record})
+}
+
+func (this *mapBackedChangeHistory) AppendChanges(ctx context.Context, records []*ChangeRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, record := range records {
+		this.records = append(this.records, copyChangeRecord(record))
+	}
+	return nil
+}
+
+func (this *mapBackedChangeHistory) FindChanges(ctx context.Context, query ChangeQuery) ([]*ChangeRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	ret := make([]*ChangeRecord, 0)
+	for _, record := range this.records {
+		if query.Limit > 0 && len(ret) == query.Limit {
+			break
+		}
+		if query.matches(record) {
+			ret = append(ret, copyChangeRecord(record))
+		}
+	}
+	return ret, nil
+}
+
+// copies the record and its states so that the stored history can not be changed through the records passed in or returned.
+func copyChangeRecord(record *ChangeRecord) *ChangeRecord {
+	clone := *record
+	clone.Before = copyChangeState(record.Before)
+	clone.After = copyChangeState(record.After)
+	return &clone
+}
+
+func copyChangeState(state *ChangeState) *ChangeState {
+	if state == nil {
+		return nil
+	}
+	clone := &ChangeState{}
+	if state.Role != nil {
+		role := *state.Role
+		if state.Role.ParentRoleNames != nil {
+			role.ParentRoleNames = append(make([]string, 0, len(state.Role.ParentRoleNames)), state.Role.ParentRoleNames...)
+		}
+		clone.Role = &role
+	}
+	if state.Resource != nil {
+		resource := *state.Resource
+		if state.Resource.ACEs != nil {
+			resource.ACEs = append(make([]ACEState, 0, len(state.Resource.ACEs)), state.Resource.ACEs...)
+		}
+		clone.Resource = &resource
+	}
+	if state.ACE != nil {
+		ace := *state.ACE
+		clone.ACE = &ace
+	}
+	return clone
+}
@@ -0,0 +1,128 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package nogo
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type mapBackedGroupRepository struct {
+	lock     *sync.RWMutex
+	groupMap map[string]map[string]bool
+}
+
+// Construct a new in-memory GroupRepository.
+func NewMapBackedGroupRepository() GroupRepository {
+	return &mapBackedGroupRepository{lock: &sync.RWMutex{}, groupMap: make(map[string]map[string]bool)}
+}
+
+func (this *mapBackedGroupRepository) CreateGroup(groupSid string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.groupMap[groupSid]; ok {
+		return errors.New(fmt.Sprintf("Error creating group. Group %v already exists.", groupSid))
+	}
+	this.groupMap[groupSid] = make(map[string]bool)
+	return nil
+}
+
+func (this *mapBackedGroupRepository) DeleteGroup(groupSid string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.groupMap[groupSid]; !ok {
+		return errors.New(fmt.Sprintf("Error deleting group. Group %v does not exist.", groupSid))
+	}
+	delete(this.groupMap, groupSid)
+	for _, members := range this.groupMap {
+		delete(members, groupSid)
+	}
+	return nil
+}
+
+func (this *mapBackedGroupRepository) AddMember(groupSid string, memberSid string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	members, ok := this.groupMap[groupSid]
+	if !ok {
+		return errors.New(fmt.Sprintf("Error adding member. Group %v does not exist.", groupSid))
+	}
+	if members[memberSid] {
+		return errors.New(fmt.Sprintf("Error adding member. Sid %v is already a member of group %v.", memberSid, groupSid))
+	}
+	// the member would contain the group if the group already belongs to it
+	if memberSid == groupSid || containsString(this.findGroupSids(groupSid), memberSid) {
+		return errors.New(fmt.Sprintf("Error adding member. Group %v cannot be a member of itself.", groupSid))
+	}
+	members[memberSid] = true
+	return nil
+}
+
+func (this *mapBackedGroupRepository) RemoveMember(groupSid string, memberSid string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if members, ok := this.groupMap[groupSid]; ok && members[memberSid] {
+		delete(members, memberSid)
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Error removing member. Sid %v is not a member of group %v.", memberSid, groupSid))
+}
+
+func (this *mapBackedGroupRepository) FindMembers(groupSid string) ([]string, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	ret := make([]string, 0)
+	for memberSid := range this.groupMap[groupSid] {
+		ret = append(ret, memberSid)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+func (this *mapBackedGroupRepository) FindGroupSidsForSid(sid string) ([]string, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.findGroupSids(sid), nil
+}
+
+// expands the groups containing the sid breadth first. Must be called while holding the lock.
+func (this *mapBackedGroupRepository) findGroupSids(sid string) []string {
+	visited := make(map[string]bool)
+	ret := make([]string, 0)
+	pending := []string{sid}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+		for groupSid, members := range this.groupMap {
+			if members[current] && !visited[groupSid] {
+				visited[groupSid] = true
+				ret = append(ret, groupSid)
+				pending = append(pending, groupSid)
+			}
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func containsString(values []string, value string) bool {
+	for _, existing := range values {
+		if existing == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package nogo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapNestedGroups(t *testing.T) {
+	// given
+	repo := NewMapBackedGroupRepository()
+	repo.CreateGroup("finance")
+	repo.CreateGroup("accounting")
+	repo.CreateGroup("payroll")
+
+	// when
+	assert.Nil(t, repo.AddMember("finance", "accounting"))
+	assert.Nil(t, repo.AddMember("accounting", "payroll"))
+	assert.Nil(t, repo.AddMember("payroll", "sid"))
+	assert.Nil(t, repo.AddMember("finance", "sid"))
+
+	// then
+	groupSids, err := repo.FindGroupSidsForSid("sid")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"accounting", "finance", "payroll"}, groupSids)
+	groupSids, _ = repo.FindGroupSidsForSid("accounting")
+	assert.Equal(t, []string{"finance"}, groupSids)
+	members, err := repo.FindMembers("finance")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"accounting", "sid"}, members)
+}
+
+func TestMapGroupMembershipErrors(t *testing.T) {
+	// given
+	repo := NewMapBackedGroupRepository()
+	repo.CreateGroup("finance")
+	repo.CreateGroup("accounting")
+	repo.AddMember("finance", "accounting")
+
+	// when
+	cycleErr := repo.AddMember("accounting", "finance")
+	selfErr := repo.AddMember("finance", "finance")
+	duplicateErr := repo.AddMember("finance", "accounting")
+	missingErr := repo.AddMember("missing", "sid")
+	removeErr := repo.RemoveMember("accounting", "sid")
+
+	// then
+	assert.NotNil(t, cycleErr)
+	assert.NotNil(t, selfErr)
+	assert.NotNil(t, duplicateErr)
+	assert.NotNil(t, missingErr)
+	assert.NotNil(t, removeErr)
+	assert.NotNil(t, repo.CreateGroup("finance"))
+}
+
+func TestMapDeleteGroup(t *testing.T) {
+	// given
+	repo := NewMapBackedGroupRepository()
+	repo.CreateGroup("finance")
+	repo.CreateGroup("accounting")
+	repo.AddMember("finance", "accounting")
+	repo.AddMember("accounting", "sid")
+
+	// when
+	err := repo.DeleteGroup("accounting")
+
+	// then
+	assert.Nil(t, err)
+	groupSids, _ := repo.FindGroupSidsForSid("sid")
+	assert.Equal(t, 0, len(groupSids))
+	members, _ := repo.FindMembers("finance")
+	assert.Equal(t, 0, len(members))
+	assert.NotNil(t, repo.DeleteGroup("accounting"))
+}
@@ -0,0 +1,118 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type mapBackedRoleMembershipRepository struct {
+	lock      *sync.RWMutex
+	memberMap map[string]map[string]membershipWindow
+}
+
+// the window of time during which a membership applies. Zero times leave the window open.
+type membershipWindow struct {
+	notBefore time.Time
+	notAfter  time.Time
+}
+
+// Construct a new in-memory RoleMembershipRepository. The returned repository also implements TimeBoundRoleMembershipRepository.
+func NewMapBackedRoleMembershipRepository() RoleMembershipRepository {
+	return &mapBackedRoleMembershipRepository{lock: &sync.RWMutex{}, memberMap: make(map[string]map[string]membershipWindow)}
+}
+
+func (this *mapBackedRoleMembershipRepository) AddMember(roleName string, principalSid string) error {
+	return this.AddMemberBetween(roleName, principalSid, time.Time{}, time.Time{})
+}
+
+func (this *mapBackedRoleMembershipRepository) AddMemberBetween(roleName string, principalSid string, notBefore time.Time, notAfter time.Time) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	members, ok := this.memberMap[roleName]
+	if !ok {
+		members = make(map[string]membershipWindow)
+		this.memberMap[roleName] = members
+	}
+	if _, ok := members[principalSid]; ok {
+		return errors.New(fmt.Sprintf("Error adding member. Principal %v is already a member of role %v.", principalSid, roleName))
+	}
+	members[principalSid] = membershipWindow{notBefore: notBefore, notAfter: notAfter}
+	return nil
+}
+
+func (this *mapBackedRoleMembershipRepository) RemoveMember(roleName string, principalSid string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.memberMap[roleName][principalSid]; ok {
+		members := this.memberMap[roleName]
+		delete(members, principalSid)
+		if len(members) == 0 {
+			delete(this.memberMap, roleName)
+		}
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Error removing member. Principal %v is not a member of role %v.", principalSid, roleName))
+}
+
+func (this *mapBackedRoleMembershipRepository) FindRolesForSid(principalSid string) ([]string, error) {
+	return this.FindRolesForSidAt(principalSid, time.Now())
+}
+
+func (this *mapBackedRoleMembershipRepository) FindRolesForSidAt(principalSid string, at time.Time) ([]string, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	ret := make([]string, 0)
+	for roleName, members := range this.memberMap {
+		if window, ok := members[principalSid]; ok && isWithinWindow(at, window.notBefore, window.notAfter) {
+			ret = append(ret, roleName)
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+func (this *mapBackedRoleMembershipRepository) FindMembersOfRole(roleName string) ([]string, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	ret := make([]string, 0)
+	for principalSid := range this.memberMap[roleName] {
+		ret = append(ret, principalSid)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+func (this *mapBackedRoleMembershipRepository) PurgeExpiredMembers(before time.Time) (int, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	count := 0
+	for roleName, members := range this.memberMap {
+		for principalSid, window := range members {
+			if !window.notAfter.IsZero() && !window.notAfter.After(before) {
+				delete(members, principalSid)
+				count++
+			}
+		}
+		if len(members) == 0 {
+			delete(this.memberMap, roleName)
+		}
+	}
+	return count, nil
+}
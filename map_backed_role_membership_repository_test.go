@@ -0,0 +1,117 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapAddMember(t *testing.T) {
+	// given
+	repo := NewMapBackedRoleMembershipRepository()
+
+	// when
+	err := repo.AddMember("role1", "sid")
+	assert.Nil(t, err)
+	err = repo.AddMember("role2", "sid")
+	assert.Nil(t, err)
+	err = repo.AddMember("role1", "sid2")
+	assert.Nil(t, err)
+
+	// then
+	roleNames, err := repo.FindRolesForSid("sid")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"role1", "role2"}, roleNames)
+	members, err := repo.FindMembersOfRole("role1")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sid", "sid2"}, members)
+}
+
+func TestMapAddDuplicateMember(t *testing.T) {
+	// given
+	repo := NewMapBackedRoleMembershipRepository()
+	repo.AddMember("role", "sid")
+
+	// when
+	err := repo.AddMember("role", "sid")
+
+	// then
+	assert.NotNil(t, err)
+}
+
+func TestMapRemoveMember(t *testing.T) {
+	// given
+	repo := NewMapBackedRoleMembershipRepository()
+	repo.AddMember("role", "sid")
+
+	// when
+	err := repo.RemoveMember("role", "sid")
+
+	// then
+	assert.Nil(t, err)
+	roleNames, err := repo.FindRolesForSid("sid")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(roleNames))
+	err = repo.RemoveMember("role", "sid")
+	assert.NotNil(t, err)
+}
+
+func TestMapTimeBoundMember(t *testing.T) {
+	// given
+	repo := NewMapBackedRoleMembershipRepository().(TimeBoundRoleMembershipRepository)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	repo.AddMember("permanent", "sid")
+
+	// when
+	err := repo.AddMemberBetween("oncall", "sid", start, end)
+
+	// then
+	assert.Nil(t, err)
+	assert.NotNil(t, repo.AddMemberBetween("oncall", "sid", start, end))
+	roleNames, _ := repo.FindRolesForSidAt("sid", start.Add(-time.Second))
+	assert.Equal(t, []string{"permanent"}, roleNames)
+	roleNames, _ = repo.FindRolesForSidAt("sid", start)
+	assert.Equal(t, []string{"oncall", "permanent"}, roleNames)
+	roleNames, _ = repo.FindRolesForSidAt("sid", end)
+	assert.Equal(t, []string{"permanent"}, roleNames)
+	roleNames, _ = repo.FindRolesForSid("sid")
+	assert.Equal(t, []string{"permanent"}, roleNames)
+	members, _ := repo.FindMembersOfRole("oncall")
+	assert.Equal(t, []string{"sid"}, members)
+}
+
+func TestMapPurgeExpiredMembers(t *testing.T) {
+	// given
+	repo := NewMapBackedRoleMembershipRepository().(TimeBoundRoleMembershipRepository)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo.AddMember("permanent", "sid")
+	repo.AddMemberBetween("oncall", "sid", start, start.Add(time.Hour))
+	repo.AddMemberBetween("auditor", "sid", start, start.Add(2*time.Hour))
+
+	// when
+	count, err := repo.PurgeExpiredMembers(start.Add(time.Hour))
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	members, _ := repo.FindMembersOfRole("oncall")
+	assert.Equal(t, 0, len(members))
+	roleNames, _ := repo.FindRolesForSidAt("sid", start)
+	assert.Equal(t, []string{"auditor", "permanent"}, roleNames)
+}
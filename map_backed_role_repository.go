@@ -46,12 +46,18 @@ func (this *mapBackedRoleRepository) CreateRole(role Role) error {
 	if _, ok := this.roleMap[role.GetName()]; ok {
 		return errors.New(fmt.Sprintf("Error creating role. Role %v already exists", role.GetName()))
 	}
+	if err := validateRoleParents(role, this.roleMap); err != nil {
+		return err
+	}
 	this.roleMap[role.GetName()] = role
 	return nil
 }
 
 func (this *mapBackedRoleRepository) UpdateRole(role Role) error {
 	if _, ok := this.roleMap[role.GetName()]; ok {
+		if err := validateRoleParents(role, this.roleMap); err != nil {
+			return err
+		}
 		this.roleMap[role.GetName()] = role
 		return nil
 	}
@@ -160,3 +160,19 @@ func DeleteNonExistantRoleTest(t *testing.T) {
 	// then
 	assert.NotNil(t, err)
 }
+
+func TestMapRoleHierarchyCycleDetection(t *testing.T) {
+	// given
+	repo := NewMapBackedRoleRepository()
+	repo.CreateRole(NewRole("employee", Permission(1)))
+	repo.CreateRole(NewHierarchicalRole("manager", Permission(2), "employee"))
+
+	// when
+	err := repo.UpdateRole(NewHierarchicalRole("employee", Permission(1), "manager"))
+
+	// then
+	assert.NotNil(t, err)
+	assert.NotNil(t, repo.UpdateRole(NewHierarchicalRole("employee", Permission(1), "employee")))
+	assert.NotNil(t, repo.CreateRole(NewHierarchicalRole("director", Permission(4), "missing")))
+	assert.Nil(t, repo.CreateRole(NewHierarchicalRole("director", Permission(4), "manager", "employee")))
+}
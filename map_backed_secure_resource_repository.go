@@ -0,0 +1,216 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// a stored resource. The parent is referenced by its native id and resolved when the resource is found.
+type mapResourceEntry struct {
+	nativeId         string
+	parentId         string
+	ownerSid         string
+	inheritParentACL bool
+	aces             []ACE
+}
+
+type mapBackedSecureResourceRepository struct {
+	lock        *sync.RWMutex
+	resourceMap map[string]*mapResourceEntry
+}
+
+// Construct a new in-memory SecureResourceRepository. Resources are copied on write and on read, so changes to a resource or its ACL are only visible after calling UpdateResource. The returned repository also implements AccessibleResourceFinder and ExpiredACEPurger.
+func NewMapBackedSecureResourceRepository() SecureResourceRepository {
+	return &mapBackedSecureResourceRepository{lock: &sync.RWMutex{}, resourceMap: make(map[string]*mapResourceEntry)}
+}
+
+func (this *mapBackedSecureResourceRepository) FindResource(nativeResourceId string) (SecureResource, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.findResource(nativeResourceId)
+}
+
+func (this *mapBackedSecureResourceRepository) FindAccessibleResourceIds(ctx context.Context, sid string, groupSids []string, permission Permission, afterResourceId string, limit int, at time.Time) ([]string, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	candidates := make([]string, 0)
+	for nativeId := range this.resourceMap {
+		if nativeId > afterResourceId {
+			candidates = append(candidates, nativeId)
+		}
+	}
+	sort.Strings(candidates)
+	sids := entrySids(sid, groupSids)
+	ids := make([]string, 0)
+	for _, nativeId := range candidates {
+		if limit > 0 && len(ids) == limit {
+			break
+		}
+		resource, err := this.findResource(nativeId)
+		if err != nil {
+			return nil, err
+		}
+		if owner := resource.GetOwnerSid(); owner != "" && owner == sid {
+			ids = append(ids, nativeId)
+			continue
+		}
+		consulted := make([]string, 0)
+		result, _, _, err := evaluateInheritedACL(ctx, sids, permission, at, resource, &consulted)
+		if err != nil {
+			return nil, err
+		}
+		if result == aceAllowed {
+			ids = append(ids, nativeId)
+		}
+	}
+	return ids, nil
+}
+
+// must be called while holding the lock.
+func (this *mapBackedSecureResourceRepository) findResource(nativeResourceId string) (SecureResource, error) {
+	entry, ok := this.resourceMap[nativeResourceId]
+	if !ok {
+		return nil, fmt.Errorf("Could not find resource %v: %w", nativeResourceId, ErrResourceNotFound)
+	}
+	resource, err := entry.toResource()
+	if err != nil {
+		return nil, err
+	}
+	// resolve the parent chain by native id
+	visited := map[string]bool{entry.nativeId: true}
+	current := resource
+	for entry.parentId != "" {
+		if visited[entry.parentId] {
+			return nil, errors.New(fmt.Sprintf("Resource %v has a cyclic parent hierarchy", nativeResourceId))
+		}
+		visited[entry.parentId] = true
+		parentEntry, ok := this.resourceMap[entry.parentId]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Could not find parent resource for %v", entry.nativeId))
+		}
+		parent, err := parentEntry.toResource()
+		if err != nil {
+			return nil, err
+		}
+		current.parent = parent
+		current = parent
+		entry = parentEntry
+	}
+	return resource, nil
+}
+
+func (this *mapBackedSecureResourceRepository) CreateResource(resource SecureResource) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.resourceMap[resource.GetNativeId()]; ok {
+		return errors.New(fmt.Sprintf("Error creating resource. Resource %v already exists.", resource.GetNativeId()))
+	}
+	entry, err := this.newEntry(resource)
+	if err != nil {
+		return err
+	}
+	this.resourceMap[entry.nativeId] = entry
+	return nil
+}
+
+func (this *mapBackedSecureResourceRepository) UpdateResource(resource SecureResource) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.resourceMap[resource.GetNativeId()]; !ok {
+		return errors.New(fmt.Sprintf("Error updating resource. Resource %v does not exist.", resource.GetNativeId()))
+	}
+	entry, err := this.newEntry(resource)
+	if err != nil {
+		return err
+	}
+	// reject a parent that is the resource itself or one of its descendants
+	for parentId := entry.parentId; parentId != ""; parentId = this.resourceMap[parentId].parentId {
+		if parentId == entry.nativeId {
+			return errors.New(fmt.Sprintf("Error updating resource. Resource %v cannot be its own ancestor.", entry.nativeId))
+		}
+	}
+	this.resourceMap[entry.nativeId] = entry
+	return nil
+}
+
+func (this *mapBackedSecureResourceRepository) DeleteResource(nativeResourceId string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.resourceMap[nativeResourceId]; !ok {
+		return errors.New(fmt.Sprintf("Error deleting resource. Resource %v does not exist.", nativeResourceId))
+	}
+	for _, entry := range this.resourceMap {
+		if entry.parentId == nativeResourceId {
+			return errors.New(fmt.Sprintf("Error deleting resource. Resource %v is the parent of resource %v.", nativeResourceId, entry.nativeId))
+		}
+	}
+	delete(this.resourceMap, nativeResourceId)
+	return nil
+}
+
+// copies a resource into a new entry, ensuring that its parent, if any, is already stored.
+func (this *mapBackedSecureResourceRepository) newEntry(resource SecureResource) (*mapResourceEntry, error) {
+	entry := &mapResourceEntry{nativeId: resource.GetNativeId(), ownerSid: resource.GetOwnerSid(), inheritParentACL: resource.InheritsParentACL()}
+	if parent := resource.GetParentResource(); parent != nil {
+		if _, ok := this.resourceMap[parent.GetNativeId()]; !ok {
+			return nil, errors.New(fmt.Sprintf("Parent resource %v does not exist.", parent.GetNativeId()))
+		}
+		entry.parentId = parent.GetNativeId()
+	}
+	acl, err := resource.GetACL()
+	if err != nil {
+		return nil, err
+	}
+	if acl != nil {
+		if entry.aces, err = acl.GetACEs(); err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+func (this *mapResourceEntry) toResource() (*defaultSecureResource, error) {
+	acl := NewACL()
+	for _, ace := range this.aces {
+		if err := acl.AddACE(ace); err != nil {
+			return nil, err
+		}
+	}
+	return &defaultSecureResource{nativeId: this.nativeId, ownerSid: this.ownerSid, inheritParentACL: this.inheritParentACL, acl: acl}, nil
+}
+
+func (this *mapBackedSecureResourceRepository) PurgeExpiredACEs(ctx context.Context, before time.Time) (int, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	count := 0
+	for _, entry := range this.resourceMap {
+		aces := entry.aces[:0]
+		for _, ace := range entry.aces {
+			if timeBound, ok := ace.(TimeBoundACE); ok && !timeBound.GetNotAfter().IsZero() && !timeBound.GetNotAfter().After(before) {
+				count++
+				continue
+			}
+			aces = append(aces, ace)
+		}
+		entry.aces = aces
+	}
+	return count, nil
+}
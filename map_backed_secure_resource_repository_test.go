@@ -0,0 +1,298 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapCreateResource(t *testing.T) {
+	// given
+	repo := NewMapBackedSecureResourceRepository()
+	resource := NewSecureResource("resource", "owner", nil, false)
+	acl, _ := resource.GetACL()
+	acl.AddACE(NewACE("sid", 1))
+
+	// when
+	err := repo.CreateResource(resource)
+
+	// then
+	assert.Nil(t, err)
+	found, err := repo.FindResource("resource")
+	assert.Nil(t, err)
+	assert.Equal(t, "owner", found.GetOwnerSid())
+	foundACL, _ := found.GetACL()
+	ace, _ := foundACL.GetACEForSid("sid")
+	assert.NotNil(t, ace)
+}
+
+func TestMapCreateDuplicateResource(t *testing.T) {
+	// given
+	repo := NewMapBackedSecureResourceRepository()
+	repo.CreateResource(NewSecureResource("resource", "owner", nil, false))
+
+	// when
+	err := repo.CreateResource(NewSecureResource("resource", "owner", nil, false))
+
+	// then
+	assert.NotNil(t, err)
+}
+
+func TestMapFindNonExistentResource(t *testing.T) {
+	// given
+	repo := NewMapBackedSecureResourceRepository()
+
+	// when
+	resource, err := repo.FindResource("resource")
+
+	// then
+	assert.NotNil(t, err)
+	assert.Nil(t, resource)
+}
+
+func TestMapResourceParentResolution(t *testing.T) {
+	// given
+	repo := NewMapBackedSecureResourceRepository()
+	parent := NewSecureResource("parent", "owner", nil, false)
+	repo.CreateResource(parent)
+	repo.CreateResource(NewSecureResource("child", "owner", parent, true))
+
+	// when
+	// the stored parent is updated after the child was created
+	parentACL, _ := parent.GetACL()
+	parentACL.AddACE(NewACE("sid", 1))
+	repo.UpdateResource(parent)
+	found, err := repo.FindResource("child")
+
+	// then
+	assert.Nil(t, err)
+	assert.True(t, found.InheritsParentACL())
+	assert.Equal(t, "parent", found.GetParentResource().GetNativeId())
+	foundParentACL, _ := found.GetParentResource().GetACL()
+	ace, _ := foundParentACL.GetACEForSid("sid")
+	assert.NotNil(t, ace)
+}
+
+func TestMapCreateResourceWithMissingParent(t *testing.T) {
+	// given
+	repo := NewMapBackedSecureResourceRepository()
+	parent := NewSecureResource("parent", "owner", nil, false)
+
+	// when
+	err := repo.CreateResource(NewSecureResource("child", "owner", parent, true))
+
+	// then
+	assert.NotNil(t, err)
+}
+
+func TestMapUpdateResource(t *testing.T) {
+	// given
+	repo := NewMapBackedSecureResourceRepository()
+	resource := NewSecureResource("resource", "owner", nil, false)
+	repo.CreateResource(resource)
+
+	// when
+	// changes are not visible until the resource is updated
+	acl, _ := resource.GetACL()
+	acl.AddACE(NewACE("sid", 1))
+	found, _ := repo.FindResource("resource")
+	foundACL, _ := found.GetACL()
+	ace, _ := foundACL.GetACEForSid("sid")
+	assert.Nil(t, ace)
+	err := repo.UpdateResource(resource)
+
+	// then
+	assert.Nil(t, err)
+	found, _ = repo.FindResource("resource")
+	foundACL, _ = found.GetACL()
+	ace, _ = foundACL.GetACEForSid("sid")
+	assert.NotNil(t, ace)
+}
+
+func TestMapUpdateNonExistentResource(t *testing.T) {
+	// given
+	repo := NewMapBackedSecureResourceRepository()
+
+	// when
+	err := repo.UpdateResource(NewSecureResource("resource", "owner", nil, false))
+
+	// then
+	assert.NotNil(t, err)
+}
+
+func TestMapUpdateResourceCycle(t *testing.T) {
+	// given
+	repo := NewMapBackedSecureResourceRepository()
+	parent := NewSecureResource("parent", "owner", nil, false)
+	child := NewSecureResource("child", "owner", parent, true)
+	repo.CreateResource(parent)
+	repo.CreateResource(child)
+
+	// when
+	err := repo.UpdateResource(NewSecureResource("parent", "owner", child, true))
+
+	// then
+	assert.NotNil(t, err)
+}
+
+func TestMapDeleteResource(t *testing.T) {
+	// given
+	repo := NewMapBackedSecureResourceRepository()
+	parent := NewSecureResource("parent", "owner", nil, false)
+	repo.CreateResource(parent)
+	repo.CreateResource(NewSecureResource("child", "owner", parent, true))
+
+	// when
+	err := repo.DeleteResource("parent")
+
+	// then
+	// a parent can not be deleted while it has children
+	assert.NotNil(t, err)
+	assert.Nil(t, repo.DeleteResource("child"))
+	assert.Nil(t, repo.DeleteResource("parent"))
+	assert.NotNil(t, repo.DeleteResource("parent"))
+}
+
+func TestMapResourceConcurrentAccess(t *testing.T) {
+	// given
+	repo := NewMapBackedSecureResourceRepository()
+	var wg sync.WaitGroup
+
+	// when
+	for i := 0; i < N; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			id := fmt.Sprintf("resource-%d", index)
+			assert.Nil(t, repo.CreateResource(NewSecureResource(id, "owner", nil, false)))
+			_, err := repo.FindResource(id)
+			assert.Nil(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	// then
+	for i := 0; i < N; i++ {
+		_, err := repo.FindResource(fmt.Sprintf("resource-%d", i))
+		assert.Nil(t, err)
+	}
+}
+
+func TestMapFindAccessibleResourceIds(t *testing.T) {
+	// given
+	repo := NewMapBackedSecureResourceRepository()
+	createAccessibleResources(t, repo)
+	finder := repo.(AccessibleResourceFinder)
+
+	// when
+	all, err := finder.FindAccessibleResourceIds(context.Background(), "sid", nil, 1, "", 0, time.Now())
+	firstPage, _ := finder.FindAccessibleResourceIds(context.Background(), "sid", nil, 1, "", 2, time.Now())
+	secondPage, _ := finder.FindAccessibleResourceIds(context.Background(), "sid", nil, 1, firstPage[1], 2, time.Now())
+	lastPage, _ := finder.FindAccessibleResourceIds(context.Background(), "sid", nil, 1, secondPage[1], 2, time.Now())
+	owned, _ := finder.FindAccessibleResourceIds(context.Background(), "sid", nil, 2, "", 0, time.Now())
+	grouped, _ := finder.FindAccessibleResourceIds(context.Background(), "sid", []string{"group"}, 2, "", 0, time.Now())
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"direct", "mine", "root", "shared"}, all)
+	assert.Equal(t, []string{"direct", "mine"}, firstPage)
+	assert.Equal(t, []string{"root", "shared"}, secondPage)
+	assert.Equal(t, 0, len(lastPage))
+	assert.Equal(t, []string{"mine"}, owned)
+	assert.Equal(t, []string{"doc", "folder", "mine"}, grouped)
+}
+
+func TestMapFindAccessibleResourceIdsAt(t *testing.T) {
+	// given
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo := NewMapBackedSecureResourceRepository()
+	resource := NewSecureResource("document", "owner", nil, false)
+	acl, _ := resource.GetACL()
+	acl.AddACE(NewTimeBoundACE("sid", 1, start, start.Add(time.Hour)))
+	repo.CreateResource(resource)
+	finder := repo.(AccessibleResourceFinder)
+
+	// when
+	before, _ := finder.FindAccessibleResourceIds(context.Background(), "sid", nil, 1, "", 0, start.Add(-time.Second))
+	during, err := finder.FindAccessibleResourceIds(context.Background(), "sid", nil, 1, "", 0, start)
+	after, _ := finder.FindAccessibleResourceIds(context.Background(), "sid", nil, 1, "", 0, start.Add(time.Hour))
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(before))
+	assert.Equal(t, []string{"document"}, during)
+	assert.Equal(t, 0, len(after))
+}
+
+// creates resources where sid may read root and shared through a WorldSid entry, direct through its own entry and mine
+// through ownership. Reading folder and doc is denied, and private does not inherit from root. Members of group may
+// update folder and doc.
+func createAccessibleResources(t *testing.T, repo SecureResourceRepository) {
+	root := NewSecureResource("root", "owner", nil, false)
+	acl, _ := root.GetACL()
+	acl.AddACE(NewACE(WorldSid, 1))
+	folder := NewSecureResource("folder", "owner", root, true)
+	acl, _ = folder.GetACL()
+	acl.AddACE(NewDenyACE("sid", 1))
+	acl.AddACE(NewACE("group", 2))
+	direct := NewSecureResource("direct", "owner", root, false)
+	acl, _ = direct.GetACL()
+	acl.AddACE(NewACE("sid", 1))
+	resources := []SecureResource{
+		root,
+		folder,
+		direct,
+		NewSecureResource("doc", "owner", folder, true),
+		NewSecureResource("shared", "owner", root, true),
+		NewSecureResource("private", "owner", root, false),
+		NewSecureResource("mine", "sid", nil, false),
+	}
+	for _, resource := range resources {
+		assert.Nil(t, repo.CreateResource(resource))
+	}
+}
+
+func TestMapPurgeExpiredACEs(t *testing.T) {
+	// given
+	repo := NewMapBackedSecureResourceRepository()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	resource := NewSecureResource("doc", "owner", nil, false)
+	acl, _ := resource.GetACL()
+	acl.AddACE(NewACE("permanent", 1))
+	acl.AddACE(NewTimeBoundACE("oncall", 1, start, start.Add(time.Hour)))
+	acl.AddACE(NewTimeBoundACE("auditor", 1, start, start.Add(2*time.Hour)))
+	repo.CreateResource(resource)
+
+	// when
+	count, err := repo.(ExpiredACEPurger).PurgeExpiredACEs(context.Background(), start.Add(time.Hour))
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	stored, _ := repo.FindResource("doc")
+	acl, _ = stored.GetACL()
+	aces, _ := acl.GetACEs()
+	assert.Equal(t, 2, len(aces))
+	ace, _ := acl.GetACEForSid("oncall")
+	assert.Nil(t, ace)
+	ace, _ = acl.GetACEForSid("auditor")
+	assert.Equal(t, start.Add(2*time.Hour), ace.(TimeBoundACE).GetNotAfter())
+}
@@ -0,0 +1,365 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// The outcomes of a check recorded with Metrics.
+const (
+	GrantedOutcome  = "granted"
+	DeniedOutcome   = "denied"
+	NotFoundOutcome = "not_found"
+	ErrorOutcome    = "error"
+	// Some, but not all, of the resources passed to FilterAuthorized were authorized.
+	FilteredOutcome = "filtered"
+)
+
+// The kinds of checks recorded with Metrics, in addition to the kinds recorded in an AuditEvent.
+const (
+	FilterAuthorizedCheck          = "filter"
+	ExplainRoleAccessCheck         = "explain role"
+	ExplainResourceAccessCheck     = "explain resource"
+	ExplainResourceAccessByIdCheck = "explain resource by id"
+)
+
+// The repositories recorded with Metrics.
+const (
+	RoleRepositoryName           = "role"
+	SecureResourceRepositoryName = "secure_resource"
+)
+
+// Receives measurements of authorization checks and repository calls, allowing them to be exported to a monitoring system such as Prometheus. Implementations must be safe for concurrent use.
+type Metrics interface {
+	// Counts a check of the kind (RoleAccessCheck, ResourceAccessCheck, ResourceAccessByIdCheck, their Explain variants such as ExplainRoleAccessCheck, or FilterAuthorizedCheck) with the outcome (GrantedOutcome, DeniedOutcome, NotFoundOutcome, ErrorOutcome, or FilteredOutcome for FilterAuthorizedCheck), and records how long it took.
+	ObserveCheck(kind string, outcome string, duration time.Duration)
+	// Records how long a call to the repository operation (e.g. "FindAll") took, and counts it as an error if failed is true.
+	ObserveRepositoryCall(repository string, operation string, failed bool, duration time.Duration)
+}
+
+// Returns Metrics that discard all measurements.
+func NewNoopMetrics() Metrics {
+	return noopMetrics{}
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveCheck(kind string, outcome string, duration time.Duration) {
+}
+
+func (noopMetrics) ObserveRepositoryCall(repository string, operation string, failed bool, duration time.Duration) {
+}
+
+type instrumentedAccessControlStrategy struct {
+	delegate ContextAccessControlStrategy
+	metrics  Metrics
+	now      func() time.Time
+}
+
+// Construct an AccessControlStrategy that records the outcome and latency of every check made through the given strategy with the metrics.
+func NewInstrumentedAccessControlStrategy(strategy AccessControlStrategy, metrics Metrics) ContextAccessControlStrategy {
+	return &instrumentedAccessControlStrategy{delegate: AdaptAccessControlStrategy(strategy), metrics: metrics, now: time.Now}
+}
+
+func (this *instrumentedAccessControlStrategy) VerifyRoleAccess(principal Principal, permission Permission) error {
+	return this.VerifyRoleAccessContext(context.Background(), principal, permission)
+}
+
+func (this *instrumentedAccessControlStrategy) VerifyResourceAccess(principal Principal, permission Permission, resource SecureResource) error {
+	return this.VerifyResourceAccessContext(context.Background(), principal, permission, resource)
+}
+
+func (this *instrumentedAccessControlStrategy) VerifyResourceAccessById(principal Principal, permission Permission, resourceId string) error {
+	return this.VerifyResourceAccessByIdContext(context.Background(), principal, permission, resourceId)
+}
+
+func (this *instrumentedAccessControlStrategy) ExplainRoleAccess(principal Principal, permission Permission) (*Decision, error) {
+	return this.ExplainRoleAccessContext(context.Background(), principal, permission)
+}
+
+func (this *instrumentedAccessControlStrategy) ExplainResourceAccess(principal Principal, permission Permission, resource SecureResource) (*Decision, error) {
+	return this.ExplainResourceAccessContext(context.Background(), principal, permission, resource)
+}
+
+func (this *instrumentedAccessControlStrategy) ExplainResourceAccessById(principal Principal, permission Permission, resourceId string) (*Decision, error) {
+	return this.ExplainResourceAccessByIdContext(context.Background(), principal, permission, resourceId)
+}
+
+func (this *instrumentedAccessControlStrategy) FilterAuthorized(principal Principal, permission Permission, resources []SecureResource) ([]SecureResource, error) {
+	return this.FilterAuthorizedContext(context.Background(), principal, permission, resources)
+}
+
+func (this *instrumentedAccessControlStrategy) VerifyRoleAccessContext(ctx context.Context, principal Principal, permission Permission) error {
+	start := this.now()
+	err := this.delegate.VerifyRoleAccessContext(ctx, principal, permission)
+	this.metrics.ObserveCheck(RoleAccessCheck, checkOutcome(err), this.now().Sub(start))
+	return err
+}
+
+func (this *instrumentedAccessControlStrategy) VerifyResourceAccessContext(ctx context.Context, principal Principal, permission Permission, resource SecureResource) error {
+	start := this.now()
+	err := this.delegate.VerifyResourceAccessContext(ctx, principal, permission, resource)
+	this.metrics.ObserveCheck(ResourceAccessCheck, checkOutcome(err), this.now().Sub(start))
+	return err
+}
+
+func (this *instrumentedAccessControlStrategy) VerifyResourceAccessByIdContext(ctx context.Context, principal Principal, permission Permission, resourceId string) error {
+	start := this.now()
+	err := this.delegate.VerifyResourceAccessByIdContext(ctx, principal, permission, resourceId)
+	this.metrics.ObserveCheck(ResourceAccessByIdCheck, checkOutcome(err), this.now().Sub(start))
+	return err
+}
+
+func (this *instrumentedAccessControlStrategy) ExplainRoleAccessContext(ctx context.Context, principal Principal, permission Permission) (*Decision, error) {
+	start := this.now()
+	decision, err := this.delegate.ExplainRoleAccessContext(ctx, principal, permission)
+	this.metrics.ObserveCheck(ExplainRoleAccessCheck, decisionOutcome(decision, err), this.now().Sub(start))
+	return decision, err
+}
+
+func (this *instrumentedAccessControlStrategy) ExplainResourceAccessContext(ctx context.Context, principal Principal, permission Permission, resource SecureResource) (*Decision, error) {
+	start := this.now()
+	decision, err := this.delegate.ExplainResourceAccessContext(ctx, principal, permission, resource)
+	this.metrics.ObserveCheck(ExplainResourceAccessCheck, decisionOutcome(decision, err), this.now().Sub(start))
+	return decision, err
+}
+
+func (this *instrumentedAccessControlStrategy) ExplainResourceAccessByIdContext(ctx context.Context, principal Principal, permission Permission, resourceId string) (*Decision, error) {
+	start := this.now()
+	decision, err := this.delegate.ExplainResourceAccessByIdContext(ctx, principal, permission, resourceId)
+	this.metrics.ObserveCheck(ExplainResourceAccessByIdCheck, decisionOutcome(decision, err), this.now().Sub(start))
+	return decision, err
+}
+
+func (this *instrumentedAccessControlStrategy) FilterAuthorizedContext(ctx context.Context, principal Principal, permission Permission, resources []SecureResource) ([]SecureResource, error) {
+	start := this.now()
+	authorized, err := this.delegate.FilterAuthorizedContext(ctx, principal, permission, resources)
+	this.metrics.ObserveCheck(FilterAuthorizedCheck, filterOutcome(len(resources), authorized, err), this.now().Sub(start))
+	return authorized, err
+}
+
+// returns the outcome of a check that returned the error.
+func checkOutcome(err error) string {
+	switch {
+	case err == nil:
+		return GrantedOutcome
+	case errors.Is(err, ErrAccessDenied):
+		return DeniedOutcome
+	case errors.Is(err, ErrResourceNotFound):
+		return NotFoundOutcome
+	}
+	return ErrorOutcome
+}
+
+// returns the outcome of a filter of count resources that returned the authorized resources and error. Filters of no resources are granted.
+func filterOutcome(count int, authorized []SecureResource, err error) string {
+	switch {
+	case err != nil:
+		return checkOutcome(err)
+	case len(authorized) == count:
+		return GrantedOutcome
+	case len(authorized) == 0:
+		return DeniedOutcome
+	}
+	return FilteredOutcome
+}
+
+// returns the outcome of a check that returned the decision and error.
+func decisionOutcome(decision *Decision, err error) string {
+	if err == nil && !decision.Granted {
+		return DeniedOutcome
+	}
+	return checkOutcome(err)
+}
+
+type instrumentedRoleRepository struct {
+	ContextRoleRepository
+	metrics Metrics
+	now     func() time.Time
+}
+
+// Construct a RoleRepository that records the latency and failures of every call to the given repository with the metrics.
+func NewInstrumentedRoleRepository(repo RoleRepository, metrics Metrics) ContextRoleRepository {
+	return &instrumentedRoleRepository{ContextRoleRepository: AdaptRoleRepository(repo), metrics: metrics, now: time.Now}
+}
+
+func (this *instrumentedRoleRepository) FindAll() ([]Role, error) {
+	return this.FindAllContext(context.Background())
+}
+
+func (this *instrumentedRoleRepository) FindRole(roleName string) (Role, error) {
+	return this.FindRoleContext(context.Background(), roleName)
+}
+
+func (this *instrumentedRoleRepository) CreateRole(role Role) error {
+	return this.CreateRoleContext(context.Background(), role)
+}
+
+func (this *instrumentedRoleRepository) UpdateRole(role Role) error {
+	return this.UpdateRoleContext(context.Background(), role)
+}
+
+func (this *instrumentedRoleRepository) DeleteRole(roleName string) error {
+	return this.DeleteRoleContext(context.Background(), roleName)
+}
+
+func (this *instrumentedRoleRepository) FindAllContext(ctx context.Context) ([]Role, error) {
+	start := this.now()
+	roles, err := this.ContextRoleRepository.FindAllContext(ctx)
+	this.observe("FindAll", start, err)
+	return roles, err
+}
+
+func (this *instrumentedRoleRepository) FindRoleContext(ctx context.Context, roleName string) (Role, error) {
+	start := this.now()
+	role, err := this.ContextRoleRepository.FindRoleContext(ctx, roleName)
+	this.observe("FindRole", start, err)
+	return role, err
+}
+
+func (this *instrumentedRoleRepository) CreateRoleContext(ctx context.Context, role Role) error {
+	start := this.now()
+	err := this.ContextRoleRepository.CreateRoleContext(ctx, role)
+	this.observe("CreateRole", start, err)
+	return err
+}
+
+func (this *instrumentedRoleRepository) UpdateRoleContext(ctx context.Context, role Role) error {
+	start := this.now()
+	err := this.ContextRoleRepository.UpdateRoleContext(ctx, role)
+	this.observe("UpdateRole", start, err)
+	return err
+}
+
+func (this *instrumentedRoleRepository) DeleteRoleContext(ctx context.Context, roleName string) error {
+	start := this.now()
+	err := this.ContextRoleRepository.DeleteRoleContext(ctx, roleName)
+	this.observe("DeleteRole", start, err)
+	return err
+}
+
+func (this *instrumentedRoleRepository) observe(operation string, start time.Time, err error) {
+	this.metrics.ObserveRepositoryCall(RoleRepositoryName, operation, err != nil, this.now().Sub(start))
+}
+
+type instrumentedSecureResourceRepository struct {
+	ContextSecureResourceRepository
+	metrics Metrics
+	now     func() time.Time
+}
+
+// Construct a SecureResourceRepository that records the latency and failures of every call to the given repository with the metrics. Lookups of resources that do not exist are not counted as failures. The returned repository also implements ACLEvaluator, AccessibleResourceFinder and ExpiredACEPurger when the given repository does, recording their calls as well.
+func NewInstrumentedSecureResourceRepository(repo SecureResourceRepository, metrics Metrics) ContextSecureResourceRepository {
+	instrumented := &instrumentedSecureResourceRepository{ContextSecureResourceRepository: AdaptSecureResourceRepository(repo), metrics: metrics, now: time.Now}
+	extensions := FindSecureResourceRepositoryExtensions(repo)
+	if extensions.ACLEvaluator != nil {
+		extensions.ACLEvaluator = &instrumentedACLEvaluator{repo: instrumented, evaluator: extensions.ACLEvaluator}
+	}
+	if extensions.AccessibleResourceFinder != nil {
+		extensions.AccessibleResourceFinder = &instrumentedAccessibleResourceFinder{repo: instrumented, finder: extensions.AccessibleResourceFinder}
+	}
+	if extensions.ExpiredACEPurger != nil {
+		extensions.ExpiredACEPurger = &instrumentedExpiredACEPurger{repo: instrumented, purger: extensions.ExpiredACEPurger}
+	}
+	return ExtendSecureResourceRepository(instrumented, extensions)
+}
+
+func (this *instrumentedSecureResourceRepository) FindResource(nativeResourceId string) (SecureResource, error) {
+	return this.FindResourceContext(context.Background(), nativeResourceId)
+}
+
+func (this *instrumentedSecureResourceRepository) CreateResource(resource SecureResource) error {
+	return this.CreateResourceContext(context.Background(), resource)
+}
+
+func (this *instrumentedSecureResourceRepository) UpdateResource(resource SecureResource) error {
+	return this.UpdateResourceContext(context.Background(), resource)
+}
+
+func (this *instrumentedSecureResourceRepository) DeleteResource(nativeResourceId string) error {
+	return this.DeleteResourceContext(context.Background(), nativeResourceId)
+}
+
+func (this *instrumentedSecureResourceRepository) FindResourceContext(ctx context.Context, nativeResourceId string) (SecureResource, error) {
+	start := this.now()
+	resource, err := this.ContextSecureResourceRepository.FindResourceContext(ctx, nativeResourceId)
+	this.observe("FindResource", start, err)
+	return resource, err
+}
+
+func (this *instrumentedSecureResourceRepository) CreateResourceContext(ctx context.Context, resource SecureResource) error {
+	start := this.now()
+	err := this.ContextSecureResourceRepository.CreateResourceContext(ctx, resource)
+	this.observe("CreateResource", start, err)
+	return err
+}
+
+func (this *instrumentedSecureResourceRepository) UpdateResourceContext(ctx context.Context, resource SecureResource) error {
+	start := this.now()
+	err := this.ContextSecureResourceRepository.UpdateResourceContext(ctx, resource)
+	this.observe("UpdateResource", start, err)
+	return err
+}
+
+func (this *instrumentedSecureResourceRepository) DeleteResourceContext(ctx context.Context, nativeResourceId string) error {
+	start := this.now()
+	err := this.ContextSecureResourceRepository.DeleteResourceContext(ctx, nativeResourceId)
+	this.observe("DeleteResource", start, err)
+	return err
+}
+
+func (this *instrumentedSecureResourceRepository) observe(operation string, start time.Time, err error) {
+	failed := err != nil && !errors.Is(err, ErrResourceNotFound)
+	this.metrics.ObserveRepositoryCall(SecureResourceRepositoryName, operation, failed, this.now().Sub(start))
+}
+
+type instrumentedACLEvaluator struct {
+	repo      *instrumentedSecureResourceRepository
+	evaluator ACLEvaluator
+}
+
+func (this *instrumentedACLEvaluator) EvaluateACL(ctx context.Context, sid string, groupSids []string, permission Permission, nativeResourceId string, at time.Time) (*ACLEvaluation, error) {
+	start := this.repo.now()
+	evaluation, err := this.evaluator.EvaluateACL(ctx, sid, groupSids, permission, nativeResourceId, at)
+	this.repo.observe("EvaluateACL", start, err)
+	return evaluation, err
+}
+
+type instrumentedAccessibleResourceFinder struct {
+	repo   *instrumentedSecureResourceRepository
+	finder AccessibleResourceFinder
+}
+
+func (this *instrumentedAccessibleResourceFinder) FindAccessibleResourceIds(ctx context.Context, sid string, groupSids []string, permission Permission, afterResourceId string, limit int, at time.Time) ([]string, error) {
+	start := this.repo.now()
+	ids, err := this.finder.FindAccessibleResourceIds(ctx, sid, groupSids, permission, afterResourceId, limit, at)
+	this.repo.observe("FindAccessibleResourceIds", start, err)
+	return ids, err
+}
+
+type instrumentedExpiredACEPurger struct {
+	repo   *instrumentedSecureResourceRepository
+	purger ExpiredACEPurger
+}
+
+func (this *instrumentedExpiredACEPurger) PurgeExpiredACEs(ctx context.Context, before time.Time) (int, error) {
+	start := this.repo.now()
+	count, err := this.purger.PurgeExpiredACEs(ctx, before)
+	this.repo.observe("PurgeExpiredACEs", start, err)
+	return count, err
+}
@@ -0,0 +1,146 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrumentedStrategyObservesChecks(t *testing.T) {
+	// given
+	read := Permission(1)
+	update := Permission(2)
+	metrics := newRecordingMetrics()
+	roleRepo := NewInstrumentedRoleRepository(NewMapBackedRoleRepository(), metrics)
+	roleRepo.CreateRole(NewRole("reader", read))
+	resourceRepo := NewInstrumentedSecureResourceRepository(NewMapBackedSecureResourceRepository(), metrics)
+	resource := NewSecureResource("doc", "owner", nil, false)
+	acl, _ := resource.GetACL()
+	acl.AddACE(NewACE("id", read))
+	resourceRepo.CreateResource(resource)
+	strategy := NewInstrumentedAccessControlStrategy(NewAccessControlStrategy(resourceRepo, roleRepo, false), metrics).(*instrumentedAccessControlStrategy)
+	ticks := 0
+	strategy.now = func() time.Time {
+		ticks++
+		return time.Unix(0, 0).Add(time.Duration(ticks) * time.Millisecond)
+	}
+	p := &mockPrincipal{id: "bob", sid: "id", roleNames: []string{"reader"}}
+
+	// when
+	strategy.VerifyRoleAccess(p, read)
+	strategy.VerifyRoleAccess(p, update)
+	strategy.VerifyResourceAccessById(p, read, "doc")
+	strategy.VerifyResourceAccessById(p, read, "missing")
+	strategy.ExplainResourceAccess(p, update, resource)
+	strategy.FilterAuthorized(p, read, []SecureResource{resource})
+	strategy.FilterAuthorized(p, update, []SecureResource{resource})
+	strategy.FilterAuthorized(p, read, []SecureResource{resource, NewSecureResource("other", "owner", nil, false)})
+
+	// then
+	assert.Equal(t, []string{
+		"role granted",
+		"role denied",
+		"resource by id granted",
+		"resource by id not_found",
+		"explain resource denied",
+		"filter granted",
+		"filter denied",
+		"filter filtered",
+	}, metrics.checks)
+	assert.Equal(t, []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond, time.Millisecond, time.Millisecond, time.Millisecond, time.Millisecond, time.Millisecond}, metrics.checkDurations)
+	assert.Equal(t, 0, metrics.failures[SecureResourceRepositoryName+" FindResource"])
+	assert.Equal(t, 2, metrics.calls[SecureResourceRepositoryName+" FindResource"])
+	assert.Equal(t, 2, metrics.calls[RoleRepositoryName+" FindAll"])
+}
+
+func TestInstrumentedRepositoryObservesFailures(t *testing.T) {
+	// given
+	metrics := newRecordingMetrics()
+	failingRepo := new(mockRoleRepository)
+	failingRepo.On("FindAll").Return([]Role{}, errors.New("connection refused"))
+	strategy := NewInstrumentedAccessControlStrategy(NewAccessControlStrategy(nil, NewInstrumentedRoleRepository(failingRepo, metrics), false), metrics)
+
+	// when
+	err := strategy.VerifyRoleAccess(&mockPrincipal{id: "bob", sid: "id", roleNames: []string{"reader"}}, 1)
+
+	// then
+	assert.True(t, errors.Is(err, ErrRepository))
+	assert.Equal(t, []string{"role error"}, metrics.checks)
+	assert.Equal(t, 1, metrics.failures[RoleRepositoryName+" FindAll"])
+	assert.NotNil(t, NewNoopMetrics())
+}
+
+func TestInstrumentedRepositoryForwardsOptionalInterfaces(t *testing.T) {
+	// given
+	metrics := newRecordingMetrics()
+	repo := NewInstrumentedSecureResourceRepository(NewMapBackedSecureResourceRepository(), metrics)
+	resource := NewSecureResource("doc", "owner", nil, false)
+	acl, _ := resource.GetACL()
+	acl.AddACE(NewACE("id", 1))
+	repo.CreateResource(resource)
+
+	// when
+	_, isEvaluator := repo.(ACLEvaluator)
+	finder, isFinder := repo.(AccessibleResourceFinder)
+	purger, isPurger := repo.(ExpiredACEPurger)
+	ids, err := finder.FindAccessibleResourceIds(context.Background(), "id", nil, 1, "", 0, time.Now())
+	purger.PurgeExpiredACEs(context.Background(), time.Now())
+
+	// then
+	assert.False(t, isEvaluator)
+	assert.True(t, isFinder)
+	assert.True(t, isPurger)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"doc"}, ids)
+	assert.Equal(t, 1, metrics.calls[SecureResourceRepositoryName+" FindAccessibleResourceIds"])
+	assert.Equal(t, 1, metrics.calls[SecureResourceRepositoryName+" PurgeExpiredACEs"])
+}
+
+// metrics that keep the measurements they receive
+type recordingMetrics struct {
+	lock           *sync.Mutex
+	checks         []string
+	checkDurations []time.Duration
+	calls          map[string]int
+	failures       map[string]int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{lock: &sync.Mutex{}, calls: make(map[string]int), failures: make(map[string]int)}
+}
+
+func (this *recordingMetrics) ObserveCheck(kind string, outcome string, duration time.Duration) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.checks = append(this.checks, fmt.Sprintf("%v %v", kind, outcome))
+	this.checkDurations = append(this.checkDurations, duration)
+}
+
+func (this *recordingMetrics) ObserveRepositoryCall(repository string, operation string, failed bool, duration time.Duration) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	key := repository + " " + operation
+	this.calls[key]++
+	if failed {
+		this.failures[key]++
+	}
+}
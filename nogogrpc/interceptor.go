@@ -0,0 +1,226 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nogogrpc provides gRPC server interceptors that enforce nogo permissions declared per method.
+package nogogrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+
+	"github.com/dakiva/nogo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Returned by a PrincipalFunc when the call does not carry a principal.
+var ErrNoPrincipal = errors.New("no principal associated with the call")
+
+// Resolves the principal making a call. Returns an error if the principal could not be resolved.
+type PrincipalFunc func(ctx context.Context) (nogo.Principal, error)
+
+// Extracts the native id of the resource a request message refers to. Returns an error if the id could not be extracted.
+type ResourceIdFunc func(ctx context.Context, req interface{}) (string, error)
+
+// The permission required on the resource identified by a request message.
+type ResourcePermission struct {
+	Permission nogo.Permission
+	ResourceId ResourceIdFunc
+}
+
+// An option that customizes the authorizer.
+type Option func(*Authorizer)
+
+// Resolves principals with the function instead of PrincipalFromContext.
+func WithPrincipalFunc(principalFunc PrincipalFunc) Option {
+	return func(authorizer *Authorizer) {
+		authorizer.principalFunc = principalFunc
+	}
+}
+
+// Declares resource scoped methods, keyed by full method name (e.g. "/package.Service/Method"). Resource checks are performed in addition to any role check declared for the same method.
+func WithResourcePermissions(resourcePermissions map[string]ResourcePermission) Option {
+	return func(authorizer *Authorizer) {
+		authorizer.resourcePermissions = resourcePermissions
+	}
+}
+
+// Rejects calls to methods without a declared permission with codes.PermissionDenied. By default such calls are allowed.
+func WithDenyUnlistedMethods() Option {
+	return func(authorizer *Authorizer) {
+		authorizer.denyUnlisted = true
+	}
+}
+
+// Authorizer enforces the permissions declared for each method using an AccessControlStrategy.
+type Authorizer struct {
+	strategy            nogo.ContextAccessControlStrategy
+	principalFunc       PrincipalFunc
+	methodPermissions   map[string]nogo.Permission
+	resourcePermissions map[string]ResourcePermission
+	denyUnlisted        bool
+}
+
+// Constructs an authorizer requiring the role permissions declared in methodPermissions, keyed by full method name (e.g. "/package.Service/Method"). By default principals are resolved with PrincipalFromContext.
+func NewAuthorizer(strategy nogo.AccessControlStrategy, methodPermissions map[string]nogo.Permission, options ...Option) *Authorizer {
+	authorizer := &Authorizer{
+		strategy:            nogo.AdaptAccessControlStrategy(strategy),
+		principalFunc:       PrincipalFromContext,
+		methodPermissions:   methodPermissions,
+		resourcePermissions: make(map[string]ResourcePermission),
+	}
+	for _, option := range options {
+		option(authorizer)
+	}
+	return authorizer
+}
+
+// Returns a unary server interceptor enforcing the declared permissions before invoking the handler.
+func (this *Authorizer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := this.authorizeMethod(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		if err := this.authorizeResource(ctx, info.FullMethod, req); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Returns a stream server interceptor enforcing the declared permissions. Role checks are performed before invoking the handler. Resource checks are performed against the first message received on the stream, and until that check succeeds the handler can not send messages. Streams closed by the client before sending a message, and handlers returning without receiving one, fail the resource check.
+func (this *Authorizer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := this.authorizeMethod(stream.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		if _, ok := this.resourcePermissions[info.FullMethod]; !ok {
+			return handler(srv, stream)
+		}
+		authorizing := &authorizingStream{ServerStream: stream, authorizer: this, fullMethod: info.FullMethod}
+		err := handler(srv, authorizing)
+		if err == nil && !authorizing.authorized.Load() {
+			return status.Error(codes.PermissionDenied, "the handler returned before the resource was authorized")
+		}
+		return err
+	}
+}
+
+func (this *Authorizer) authorizeMethod(ctx context.Context, fullMethod string) error {
+	permission, ok := this.methodPermissions[fullMethod]
+	if !ok {
+		if _, isResourceMethod := this.resourcePermissions[fullMethod]; this.denyUnlisted && !isResourceMethod {
+			return status.Errorf(codes.PermissionDenied, "no permission declared for method %v", fullMethod)
+		}
+		return nil
+	}
+	principal, err := this.principal(ctx)
+	if err == nil {
+		err = this.strategy.VerifyRoleAccessContext(ctx, principal, permission)
+	}
+	return toStatus(err)
+}
+
+func (this *Authorizer) authorizeResource(ctx context.Context, fullMethod string, req interface{}) error {
+	resourcePermission, ok := this.resourcePermissions[fullMethod]
+	if !ok {
+		return nil
+	}
+	principal, err := this.principal(ctx)
+	if err == nil {
+		var resourceId string
+		if resourceId, err = resourcePermission.ResourceId(ctx, req); err != nil {
+			return status.Errorf(codes.InvalidArgument, "could not determine resource: %v", err)
+		}
+		err = this.strategy.VerifyResourceAccessByIdContext(ctx, principal, resourcePermission.Permission, resourceId)
+	}
+	return toStatus(err)
+}
+
+func (this *Authorizer) principal(ctx context.Context) (nogo.Principal, error) {
+	principal, err := this.principalFunc(ctx)
+	if err == nil && principal == nil {
+		err = ErrNoPrincipal
+	}
+	return principal, err
+}
+
+// maps a failed check to a status error based on its cause.
+func toStatus(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, nogo.ErrAccessDenied):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ErrNoPrincipal):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, nogo.ErrResourceNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// a stream that performs the resource check on the first message received, refusing to send messages until the check succeeds. Messages may be sent and received from different goroutines.
+type authorizingStream struct {
+	grpc.ServerStream
+	authorizer *Authorizer
+	fullMethod string
+	authorized atomic.Bool
+}
+
+func (this *authorizingStream) RecvMsg(m interface{}) error {
+	err := this.ServerStream.RecvMsg(m)
+	if this.authorized.Load() {
+		return err
+	}
+	if err == io.EOF {
+		return status.Error(codes.InvalidArgument, "could not determine resource: the stream was closed before a message was received")
+	} else if err != nil {
+		return err
+	}
+	if err := this.authorizer.authorizeResource(this.Context(), this.fullMethod, m); err != nil {
+		return err
+	}
+	this.authorized.Store(true)
+	return nil
+}
+
+func (this *authorizingStream) SendMsg(m interface{}) error {
+	if !this.authorized.Load() {
+		return status.Error(codes.PermissionDenied, "messages can not be sent before the resource is authorized")
+	}
+	return this.ServerStream.SendMsg(m)
+}
+
+type principalKey struct{}
+
+// Returns a copy of the context carrying the principal, for use by PrincipalFromContext.
+func WithPrincipal(ctx context.Context, principal nogo.Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// Returns the principal stored in the context by WithPrincipal, or ErrNoPrincipal if there is none.
+func PrincipalFromContext(ctx context.Context) (nogo.Principal, error) {
+	if principal, ok := ctx.Value(principalKey{}).(nogo.Principal); ok && principal != nil {
+		return principal, nil
+	}
+	return nil, ErrNoPrincipal
+}
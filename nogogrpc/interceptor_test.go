@@ -0,0 +1,284 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogogrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/dakiva/nogo"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	read nogo.Permission = 1 << iota
+	update
+)
+
+const (
+	getMethod    = "/docs.Documents/Get"
+	updateMethod = "/docs.Documents/Update"
+	watchMethod  = "/docs.Documents/Watch"
+	otherMethod  = "/docs.Documents/Other"
+)
+
+type documentRequest struct {
+	id string
+}
+
+func TestUnaryServerInterceptorRoleChecks(t *testing.T) {
+	// given
+	roleRepo := nogo.NewMapBackedRoleRepository()
+	roleRepo.CreateRole(nogo.NewRole("reader", read))
+	authorizer := NewAuthorizer(nogo.NewAccessControlStrategy(nil, roleRepo, true), map[string]nogo.Permission{getMethod: read, updateMethod: update})
+	interceptor := authorizer.UnaryServerInterceptor()
+	ctx := WithPrincipal(context.Background(), &testPrincipal{id: "bob", sid: "bob", roleNames: []string{"reader"}})
+
+	// when
+	_, granted := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: getMethod}, okHandler)
+	_, denied := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: updateMethod}, okHandler)
+	_, anonymous := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: getMethod}, okHandler)
+	_, unlisted := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: otherMethod}, okHandler)
+
+	// then
+	assert.Nil(t, granted)
+	assert.Equal(t, codes.PermissionDenied, status.Code(denied))
+	assert.Equal(t, codes.Unauthenticated, status.Code(anonymous))
+	assert.Nil(t, unlisted)
+}
+
+func TestUnaryServerInterceptorResourceChecks(t *testing.T) {
+	// given
+	authorizer := NewAuthorizer(nogo.NewAccessControlStrategy(newResourceRepository(), nil, false), nil,
+		WithResourcePermissions(map[string]ResourcePermission{
+			getMethod:    {Permission: read, ResourceId: documentId},
+			updateMethod: {Permission: update, ResourceId: documentId},
+		}))
+	interceptor := authorizer.UnaryServerInterceptor()
+	ctx := WithPrincipal(context.Background(), &testPrincipal{id: "bob", sid: "bob"})
+
+	// when
+	_, granted := interceptor(ctx, &documentRequest{id: "doc"}, &grpc.UnaryServerInfo{FullMethod: getMethod}, okHandler)
+	_, denied := interceptor(ctx, &documentRequest{id: "doc"}, &grpc.UnaryServerInfo{FullMethod: updateMethod}, okHandler)
+	_, missing := interceptor(ctx, &documentRequest{id: "missing"}, &grpc.UnaryServerInfo{FullMethod: getMethod}, okHandler)
+	_, invalid := interceptor(ctx, "not a document", &grpc.UnaryServerInfo{FullMethod: getMethod}, okHandler)
+
+	// then
+	assert.Nil(t, granted)
+	assert.Equal(t, codes.PermissionDenied, status.Code(denied))
+	assert.Equal(t, codes.NotFound, status.Code(missing))
+	assert.Equal(t, codes.InvalidArgument, status.Code(invalid))
+}
+
+func TestUnaryServerInterceptorRepositoryFailure(t *testing.T) {
+	// given
+	authorizer := NewAuthorizer(nogo.NewAccessControlStrategy(nil, &failingRoleRepository{err: errors.New("connection refused")}, true),
+		map[string]nogo.Permission{getMethod: read},
+		WithPrincipalFunc(func(ctx context.Context) (nogo.Principal, error) {
+			return &testPrincipal{id: "bob", sid: "bob"}, nil
+		}))
+
+	// when
+	_, err := authorizer.UnaryServerInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: getMethod}, okHandler)
+
+	// then
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestUnaryServerInterceptorDenyUnlistedMethods(t *testing.T) {
+	// given
+	authorizer := NewAuthorizer(nogo.NewAccessControlStrategy(nil, nogo.NewMapBackedRoleRepository(), true), nil, WithDenyUnlistedMethods())
+	ctx := WithPrincipal(context.Background(), &testPrincipal{id: "bob", sid: "bob"})
+
+	// when
+	_, err := authorizer.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: otherMethod}, okHandler)
+
+	// then
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	// given
+	roleRepo := nogo.NewMapBackedRoleRepository()
+	roleRepo.CreateRole(nogo.NewRole("reader", read))
+	authorizer := NewAuthorizer(nogo.NewAccessControlStrategy(newResourceRepository(), roleRepo, false),
+		map[string]nogo.Permission{updateMethod: update},
+		WithResourcePermissions(map[string]ResourcePermission{watchMethod: {Permission: read, ResourceId: documentId}}))
+	interceptor := authorizer.StreamServerInterceptor()
+	ctx := WithPrincipal(context.Background(), &testPrincipal{id: "bob", sid: "bob", roleNames: []string{"reader"}})
+	recvHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		return stream.RecvMsg(&documentRequest{})
+	}
+
+	// when
+	denied := interceptor(nil, &testStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: updateMethod}, recvHandler)
+	granted := interceptor(nil, &testStream{ctx: ctx, id: "doc"}, &grpc.StreamServerInfo{FullMethod: watchMethod}, recvHandler)
+	missing := interceptor(nil, &testStream{ctx: ctx, id: "missing"}, &grpc.StreamServerInfo{FullMethod: watchMethod}, recvHandler)
+
+	// then
+	assert.Equal(t, codes.PermissionDenied, status.Code(denied))
+	assert.Nil(t, granted)
+	assert.Equal(t, codes.NotFound, status.Code(missing))
+}
+
+func TestStreamServerInterceptorGatesSends(t *testing.T) {
+	// given
+	authorizer := NewAuthorizer(nogo.NewAccessControlStrategy(newResourceRepository(), nogo.NewMapBackedRoleRepository(), false), nil,
+		WithResourcePermissions(map[string]ResourcePermission{watchMethod: {Permission: read, ResourceId: documentId}}))
+	interceptor := authorizer.StreamServerInterceptor()
+	ctx := WithPrincipal(context.Background(), &testPrincipal{id: "bob", sid: "bob"})
+	var sendErr error
+	sendHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		if sendErr = stream.SendMsg("early"); sendErr != nil {
+			return sendErr
+		}
+		return stream.RecvMsg(&documentRequest{})
+	}
+	recvThenSendHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		if err := stream.RecvMsg(&documentRequest{}); err != nil {
+			return err
+		}
+		return stream.SendMsg("update")
+	}
+	stranger := WithPrincipal(context.Background(), &testPrincipal{id: "eve", sid: "eve"})
+	sendBeforeRecv := &testStream{ctx: ctx, id: "doc"}
+	recvThenSend := &testStream{ctx: ctx, id: "doc"}
+	deniedThenSend := &testStream{ctx: stranger, id: "doc"}
+
+	// when
+	early := interceptor(nil, sendBeforeRecv, &grpc.StreamServerInfo{FullMethod: watchMethod}, sendHandler)
+	granted := interceptor(nil, recvThenSend, &grpc.StreamServerInfo{FullMethod: watchMethod}, recvThenSendHandler)
+	denied := interceptor(nil, deniedThenSend, &grpc.StreamServerInfo{FullMethod: watchMethod}, recvThenSendHandler)
+
+	// then
+	assert.Equal(t, codes.PermissionDenied, status.Code(early))
+	assert.Equal(t, codes.PermissionDenied, status.Code(sendErr))
+	assert.Equal(t, 0, len(sendBeforeRecv.sent))
+	assert.Nil(t, granted)
+	assert.Equal(t, []interface{}{"update"}, recvThenSend.sent)
+	assert.Equal(t, codes.PermissionDenied, status.Code(denied))
+	assert.Equal(t, 0, len(deniedThenSend.sent))
+}
+
+func TestStreamServerInterceptorEmptyStream(t *testing.T) {
+	// given
+	authorizer := NewAuthorizer(nogo.NewAccessControlStrategy(newResourceRepository(), nogo.NewMapBackedRoleRepository(), false), nil,
+		WithResourcePermissions(map[string]ResourcePermission{watchMethod: {Permission: read, ResourceId: documentId}}))
+	interceptor := authorizer.StreamServerInterceptor()
+	ctx := WithPrincipal(context.Background(), &testPrincipal{id: "bob", sid: "bob"})
+	var recvErr error
+	drainHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		// a handler treating the end of the stream as a normal completion
+		if recvErr = stream.RecvMsg(&documentRequest{}); recvErr == io.EOF {
+			return nil
+		}
+		return recvErr
+	}
+	idleHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		return nil
+	}
+	swallowHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		stream.RecvMsg(&documentRequest{})
+		return nil
+	}
+
+	// when
+	drained := interceptor(nil, &testStream{ctx: ctx, empty: true}, &grpc.StreamServerInfo{FullMethod: watchMethod}, drainHandler)
+	idle := interceptor(nil, &testStream{ctx: ctx, empty: true}, &grpc.StreamServerInfo{FullMethod: watchMethod}, idleHandler)
+	swallowed := interceptor(nil, &testStream{ctx: ctx, empty: true}, &grpc.StreamServerInfo{FullMethod: watchMethod}, swallowHandler)
+
+	// then
+	assert.Equal(t, codes.InvalidArgument, status.Code(drained))
+	assert.Equal(t, codes.InvalidArgument, status.Code(recvErr))
+	assert.Equal(t, codes.PermissionDenied, status.Code(idle))
+	assert.Equal(t, codes.PermissionDenied, status.Code(swallowed))
+}
+
+func newResourceRepository() nogo.SecureResourceRepository {
+	resourceRepo := nogo.NewMapBackedSecureResourceRepository()
+	resource := nogo.NewSecureResource("doc", "owner", nil, false)
+	acl, _ := resource.GetACL()
+	acl.AddACE(nogo.NewACE("bob", read))
+	resourceRepo.CreateResource(resource)
+	return resourceRepo
+}
+
+func documentId(ctx context.Context, req interface{}) (string, error) {
+	if document, ok := req.(*documentRequest); ok {
+		return document.id, nil
+	}
+	return "", errors.New("unexpected request type")
+}
+
+func okHandler(ctx context.Context, req interface{}) (interface{}, error) {
+	return "ok", nil
+}
+
+type testStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	id    string
+	empty bool
+	sent  []interface{}
+}
+
+func (this *testStream) Context() context.Context {
+	return this.ctx
+}
+
+func (this *testStream) RecvMsg(m interface{}) error {
+	if this.empty {
+		return io.EOF
+	}
+	m.(*documentRequest).id = this.id
+	return nil
+}
+
+func (this *testStream) SendMsg(m interface{}) error {
+	this.sent = append(this.sent, m)
+	return nil
+}
+
+type testPrincipal struct {
+	id        string
+	sid       string
+	roleNames []string
+}
+
+func (this *testPrincipal) GetId() string {
+	return this.id
+}
+
+func (this *testPrincipal) GetSid() string {
+	return this.sid
+}
+
+func (this *testPrincipal) GetRoleNames() []string {
+	return this.roleNames
+}
+
+type failingRoleRepository struct {
+	nogo.RoleRepository
+	err error
+}
+
+func (this *failingRoleRepository) FindAll() ([]nogo.Role, error) {
+	return nil, this.err
+}
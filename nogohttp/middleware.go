@@ -0,0 +1,169 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nogohttp provides net/http middleware that enforces nogo role and resource checks.
+package nogohttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dakiva/nogo"
+)
+
+// Returned by a PrincipalFunc when the request does not carry a principal.
+var ErrNoPrincipal = errors.New("no principal associated with the request")
+
+// Matched by the errors of a ResourceIdFunc that could not extract a resource id from the request, which are wrapped so that they match both this error and the original error.
+var ErrInvalidResourceId = errors.New("invalid resource id")
+
+// Resolves the principal making a request. Returns an error if the principal could not be resolved.
+type PrincipalFunc func(r *http.Request) (nogo.Principal, error)
+
+// Extracts the native id of the resource a request refers to. Returns an error if the id could not be extracted.
+type ResourceIdFunc func(r *http.Request) (string, error)
+
+// Writes the response for a request that failed an access check.
+type ErrorHandlerFunc func(w http.ResponseWriter, r *http.Request, err error)
+
+// An option that customizes the middleware.
+type Option func(*Middleware)
+
+// Resolves principals with the function instead of PrincipalFromContext.
+func WithPrincipalFromRequest(principalFunc PrincipalFunc) Option {
+	return func(middleware *Middleware) {
+		middleware.principalFromRequest = principalFunc
+	}
+}
+
+// Writes denied responses with the handler instead of DefaultDeniedHandler.
+func WithDeniedHandler(handler ErrorHandlerFunc) Option {
+	return func(middleware *Middleware) {
+		middleware.deniedHandler = handler
+	}
+}
+
+// Writes error responses with the handler instead of DefaultErrorHandler.
+func WithErrorHandler(handler ErrorHandlerFunc) Option {
+	return func(middleware *Middleware) {
+		middleware.errorHandler = handler
+	}
+}
+
+// Middleware wraps handlers with access checks performed by an AccessControlStrategy. Checks use the request context.
+type Middleware struct {
+	strategy             nogo.ContextAccessControlStrategy
+	principalFromRequest PrincipalFunc
+	deniedHandler        ErrorHandlerFunc
+	errorHandler         ErrorHandlerFunc
+}
+
+// Constructs middleware enforcing checks with the strategy. By default principals are resolved with PrincipalFromContext, denials are written by DefaultDeniedHandler and all other failures by DefaultErrorHandler.
+func NewMiddleware(strategy nogo.AccessControlStrategy, options ...Option) *Middleware {
+	middleware := &Middleware{
+		strategy:             nogo.AdaptAccessControlStrategy(strategy),
+		principalFromRequest: PrincipalFromContext,
+		deniedHandler:        DefaultDeniedHandler,
+		errorHandler:         DefaultErrorHandler,
+	}
+	for _, option := range options {
+		option(middleware)
+	}
+	return middleware
+}
+
+// Returns middleware that only calls the next handler if the principal has been granted the permission through its roles.
+func (this *Middleware) RequirePermission(permission nogo.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := this.principal(r)
+			if err == nil {
+				err = this.strategy.VerifyRoleAccessContext(r.Context(), principal, permission)
+			}
+			this.handle(next, w, r, err)
+		})
+	}
+}
+
+// Returns middleware that only calls the next handler if the principal has been granted the permission on the resource identified by the extractor.
+func (this *Middleware) RequireResourcePermission(permission nogo.Permission, extractor ResourceIdFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := this.principal(r)
+			if err == nil {
+				var resourceId string
+				if resourceId, err = extractor(r); err == nil {
+					err = this.strategy.VerifyResourceAccessByIdContext(r.Context(), principal, permission, resourceId)
+				} else {
+					err = fmt.Errorf("%w: %w", ErrInvalidResourceId, err)
+				}
+			}
+			this.handle(next, w, r, err)
+		})
+	}
+}
+
+func (this *Middleware) principal(r *http.Request) (nogo.Principal, error) {
+	principal, err := this.principalFromRequest(r)
+	if err == nil && principal == nil {
+		err = ErrNoPrincipal
+	}
+	return principal, err
+}
+
+func (this *Middleware) handle(next http.Handler, w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case err == nil:
+		next.ServeHTTP(w, r)
+	case errors.Is(err, nogo.ErrAccessDenied):
+		this.deniedHandler(w, r, err)
+	default:
+		this.errorHandler(w, r, err)
+	}
+}
+
+// Writes a 403 Forbidden response.
+func DefaultDeniedHandler(w http.ResponseWriter, r *http.Request, err error) {
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
+
+// Writes a 401 Unauthorized response if the request has no principal, a 400 Bad Request response if the resource id could not be extracted, a 404 Not Found response if the resource does not exist, and a 500 Internal Server Error response otherwise.
+func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, ErrNoPrincipal) {
+		status = http.StatusUnauthorized
+	} else if errors.Is(err, ErrInvalidResourceId) {
+		status = http.StatusBadRequest
+	} else if errors.Is(err, nogo.ErrResourceNotFound) {
+		status = http.StatusNotFound
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+type principalKey struct{}
+
+// Returns a copy of the context carrying the principal, for use by PrincipalFromContext.
+func WithPrincipal(ctx context.Context, principal nogo.Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// Returns the principal stored in the request context by WithPrincipal, or ErrNoPrincipal if there is none.
+func PrincipalFromContext(r *http.Request) (nogo.Principal, error) {
+	if principal, ok := r.Context().Value(principalKey{}).(nogo.Principal); ok && principal != nil {
+		return principal, nil
+	}
+	return nil, ErrNoPrincipal
+}
@@ -0,0 +1,173 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogohttp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dakiva/nogo"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	read nogo.Permission = 1 << iota
+	update
+)
+
+func TestRequirePermission(t *testing.T) {
+	// given
+	roleRepo := nogo.NewMapBackedRoleRepository()
+	roleRepo.CreateRole(nogo.NewRole("reader", read))
+	middleware := NewMiddleware(nogo.NewAccessControlStrategy(nil, roleRepo, true))
+	principal := &testPrincipal{id: "bob", sid: "bob", roleNames: []string{"reader"}}
+
+	// when
+	granted := serve(middleware.RequirePermission(read), principal)
+	denied := serve(middleware.RequirePermission(update), principal)
+	anonymous := serve(middleware.RequirePermission(read), nil)
+
+	// then
+	assert.Equal(t, http.StatusOK, granted.Code)
+	assert.Equal(t, http.StatusForbidden, denied.Code)
+	assert.Equal(t, http.StatusUnauthorized, anonymous.Code)
+}
+
+func TestRequireResourcePermission(t *testing.T) {
+	// given
+	resourceRepo := nogo.NewMapBackedSecureResourceRepository()
+	resource := nogo.NewSecureResource("doc", "owner", nil, false)
+	acl, _ := resource.GetACL()
+	acl.AddACE(nogo.NewACE("bob", read))
+	resourceRepo.CreateResource(resource)
+	middleware := NewMiddleware(nogo.NewAccessControlStrategy(resourceRepo, nil, false))
+	principal := &testPrincipal{id: "bob", sid: "bob"}
+	extractor := func(id string) ResourceIdFunc {
+		return func(r *http.Request) (string, error) {
+			return id, nil
+		}
+	}
+
+	// when
+	granted := serve(middleware.RequireResourcePermission(read, extractor("doc")), principal)
+	denied := serve(middleware.RequireResourcePermission(update, extractor("doc")), principal)
+	missing := serve(middleware.RequireResourcePermission(read, extractor("missing")), principal)
+
+	// then
+	assert.Equal(t, http.StatusOK, granted.Code)
+	assert.Equal(t, http.StatusForbidden, denied.Code)
+	assert.Equal(t, http.StatusNotFound, missing.Code)
+}
+
+func TestInvalidResourceId(t *testing.T) {
+	// given
+	parseErr := errors.New("malformed id")
+	var handledErr error
+	extractor := func(r *http.Request) (string, error) {
+		return "", parseErr
+	}
+	middleware := NewMiddleware(nogo.NewAccessControlStrategy(nogo.NewMapBackedSecureResourceRepository(), nil, false))
+	customMiddleware := NewMiddleware(nogo.NewAccessControlStrategy(nogo.NewMapBackedSecureResourceRepository(), nil, false),
+		WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+			handledErr = err
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}))
+	principal := &testPrincipal{id: "bob", sid: "bob"}
+
+	// when
+	invalid := serve(middleware.RequireResourcePermission(read, extractor), principal)
+	custom := serve(customMiddleware.RequireResourcePermission(read, extractor), principal)
+
+	// then
+	assert.Equal(t, http.StatusBadRequest, invalid.Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, custom.Code)
+	assert.True(t, errors.Is(handledErr, ErrInvalidResourceId))
+	assert.True(t, errors.Is(handledErr, parseErr))
+}
+
+func TestCustomHandlers(t *testing.T) {
+	// given
+	backendErr := errors.New("connection refused")
+	var deniedErr, handledErr error
+	middleware := NewMiddleware(nogo.NewAccessControlStrategy(nil, &failingRoleRepository{err: backendErr}, true),
+		WithPrincipalFromRequest(func(r *http.Request) (nogo.Principal, error) {
+			return &testPrincipal{id: r.Header.Get("X-User")}, nil
+		}),
+		WithDeniedHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+			deniedErr = err
+			w.WriteHeader(http.StatusTeapot)
+		}),
+		WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+			handledErr = err
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-User", "bob")
+	w := httptest.NewRecorder()
+
+	// when
+	middleware.RequirePermission(read)(okHandler()).ServeHTTP(w, r)
+
+	// then
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Nil(t, deniedErr)
+	assert.True(t, errors.Is(handledErr, nogo.ErrRepository))
+	assert.True(t, errors.Is(handledErr, backendErr))
+}
+
+func serve(middleware func(http.Handler) http.Handler, principal nogo.Principal) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/", nil)
+	if principal != nil {
+		r = r.WithContext(WithPrincipal(r.Context(), principal))
+	}
+	w := httptest.NewRecorder()
+	middleware(okHandler()).ServeHTTP(w, r)
+	return w
+}
+
+func okHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+type testPrincipal struct {
+	id        string
+	sid       string
+	roleNames []string
+}
+
+func (this *testPrincipal) GetId() string {
+	return this.id
+}
+
+func (this *testPrincipal) GetSid() string {
+	return this.sid
+}
+
+func (this *testPrincipal) GetRoleNames() []string {
+	return this.roleNames
+}
+
+type failingRoleRepository struct {
+	nogo.RoleRepository
+	err error
+}
+
+func (this *failingRoleRepository) FindAll() ([]nogo.Role, error) {
+	return nil, this.err
+}
@@ -0,0 +1,492 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nogopolicy loads roles and the permissions they grant from a YAML or JSON policy document and seeds a role repository with them.
+package nogopolicy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dakiva/nogo"
+	"gopkg.in/yaml.v3"
+)
+
+// A set of named permissions and the roles granting them, as defined by a policy document. Documents are written in YAML, or equivalently in JSON, for example:
+//
+//	permissions:
+//	  read: 0
+//	  update: 1
+//	roles:
+//	  - name: reader
+//	    permissions: [read]
+//	  - name: editor
+//	    permissions: [update]
+//	    parents: [reader]
+//	  - name: admin
+//	    admin: true
+//	    permissions: [read, update]
+//
+// Each permission maps a name to the bit, from 0 to 63, it occupies in a permission mask. A role may inherit the permissions of parent roles defined before it in the document.
+type Policy struct {
+	// The permissions defined by the policy, keyed by name.
+	Permissions map[string]nogo.Permission
+	// The roles defined by the policy, in document order.
+	Roles []nogo.Role
+}
+
+// An error found while validating a policy document, along with its position in the document.
+type ValidationError struct {
+	// The line of the document the error was found on, starting at 1.
+	Line int
+	// The column of the document the error was found on, starting at 1.
+	Column int
+	// A description of the error.
+	Message string
+}
+
+func (this *ValidationError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", this.Line, this.Column, this.Message)
+}
+
+// The errors found while validating a policy document, in document order.
+type ValidationErrors []*ValidationError
+
+func (this ValidationErrors) Error() string {
+	messages := make([]string, 0, len(this))
+	for _, err := range this {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Reads and parses a policy document from the reader. See Parse.
+func Load(reader io.Reader) (*Policy, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read policy: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parses and validates a YAML or JSON policy document. Returns ValidationErrors listing every problem found if the document is not a valid policy, or an error reporting the position of the problem if the document is not well formed.
+func Parse(data []byte) (*Policy, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("Could not parse policy: %w", err)
+	}
+	parser := &policyParser{policy: &Policy{Permissions: make(map[string]nogo.Permission)}, permissionNames: make(map[nogo.Permission]string), roleNames: make(map[string]bool)}
+	parser.parseDocument(&document)
+	if len(parser.errors) > 0 {
+		return nil, parser.errors
+	}
+	return parser.policy, nil
+}
+
+type policyParser struct {
+	policy          *Policy
+	permissionNames map[nogo.Permission]string
+	roleNames       map[string]bool
+	errors          ValidationErrors
+}
+
+func (this *policyParser) fail(node *yaml.Node, format string, args ...interface{}) {
+	this.errors = append(this.errors, &ValidationError{Line: node.Line, Column: node.Column, Message: fmt.Sprintf(format, args...)})
+}
+
+func (this *policyParser) parseDocument(document *yaml.Node) {
+	if len(document.Content) == 0 {
+		this.errors = append(this.errors, &ValidationError{Line: 1, Column: 1, Message: "Policy document is empty."})
+		return
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		this.fail(root, "Policy must be a mapping of permissions and roles.")
+		return
+	}
+	var permissions, roles *yaml.Node
+	fields := make(map[string]bool)
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if fields[key.Value] {
+			this.fail(key, "Policy field %v is defined more than once.", key.Value)
+			continue
+		}
+		fields[key.Value] = true
+		switch key.Value {
+		case "permissions":
+			permissions = value
+		case "roles":
+			roles = value
+		default:
+			this.fail(key, "Unknown policy field %v.", key.Value)
+		}
+	}
+	// permissions are parsed first so roles may refer to them regardless of the order of the fields
+	if permissions != nil {
+		this.parsePermissions(permissions)
+	}
+	if roles != nil {
+		this.parseRoles(roles)
+	}
+}
+
+func (this *policyParser) parsePermissions(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		this.fail(node, "Permissions must be a mapping of permission names to bits.")
+		return
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		name := key.Value
+		if key.Kind != yaml.ScalarNode || name == "" {
+			this.fail(key, "Permission names must not be empty.")
+			continue
+		}
+		var bit int
+		if value.Kind != yaml.ScalarNode || value.Decode(&bit) != nil || bit < 0 || bit >= nogo.MaxPermissions {
+			this.fail(value, "Permission %v must be a bit between 0 and %d.", name, nogo.MaxPermissions-1)
+			continue
+		}
+		if _, ok := this.policy.Permissions[name]; ok {
+			this.fail(key, "Permission %v is defined more than once.", name)
+			continue
+		}
+		permission := nogo.Permission(1) << uint(bit)
+		if other, ok := this.permissionNames[permission]; ok {
+			this.fail(value, "Permission %v uses bit %d, which is already used by permission %v.", name, bit, other)
+			continue
+		}
+		this.policy.Permissions[name] = permission
+		this.permissionNames[permission] = name
+	}
+}
+
+func (this *policyParser) parseRoles(node *yaml.Node) {
+	if node.Kind != yaml.SequenceNode {
+		this.fail(node, "Roles must be a list.")
+		return
+	}
+	for _, item := range node.Content {
+		role := this.parseRole(item)
+		if role == nil {
+			continue
+		}
+		if this.roleNames[role.GetName()] {
+			this.fail(item, "Role %v is defined more than once.", role.GetName())
+			continue
+		}
+		this.roleNames[role.GetName()] = true
+		this.policy.Roles = append(this.policy.Roles, role)
+	}
+}
+
+// returns the role defined by the node, or nil if the definition is not valid.
+func (this *policyParser) parseRole(node *yaml.Node) nogo.Role {
+	if node.Kind != yaml.MappingNode {
+		this.fail(node, "A role must be a mapping with a name, an admin flag, permissions and parents.")
+		return nil
+	}
+	errorCount := len(this.errors)
+	name := ""
+	admin := false
+	mask := nogo.EmptyPermissionMask
+	var parents *yaml.Node
+	fields := make(map[string]bool)
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if fields[key.Value] {
+			this.fail(key, "Role field %v is defined more than once.", key.Value)
+			continue
+		}
+		fields[key.Value] = true
+		switch key.Value {
+		case "name":
+			if value.Kind != yaml.ScalarNode || value.Value == "" {
+				this.fail(value, "Role names must not be empty.")
+				continue
+			}
+			name = value.Value
+		case "admin":
+			if value.Kind != yaml.ScalarNode || value.Decode(&admin) != nil {
+				this.fail(value, "The admin flag must be true or false.")
+			}
+		case "permissions":
+			mask = this.parseRolePermissions(value)
+		case "parents":
+			parents = value
+		default:
+			this.fail(key, "Unknown role field %v.", key.Value)
+		}
+	}
+	if name == "" && len(this.errors) == errorCount {
+		this.fail(node, "A role must have a name.")
+	}
+	parentNames := make([]string, 0)
+	if parents != nil && name != "" {
+		parentNames = this.parseRoleParents(name, admin, parents)
+	}
+	if len(this.errors) > errorCount {
+		return nil
+	}
+	if admin {
+		return nogo.NewAdminRole(name, mask)
+	}
+	if len(parentNames) > 0 {
+		return nogo.NewHierarchicalRole(name, mask, parentNames...)
+	}
+	return nogo.NewRole(name, mask)
+}
+
+// returns the names of the parents of the role. Parents must be defined earlier in the document, which also keeps roles from inheriting from themselves, directly or through their ancestors.
+func (this *policyParser) parseRoleParents(name string, admin bool, node *yaml.Node) []string {
+	parentNames := make([]string, 0)
+	if node.Kind != yaml.SequenceNode {
+		this.fail(node, "Role parents must be a list of role names.")
+		return parentNames
+	}
+	if admin && len(node.Content) > 0 {
+		this.fail(node, "Admin role %v can not inherit from other roles.", name)
+		return parentNames
+	}
+	listed := make(map[string]bool)
+	for _, item := range node.Content {
+		switch {
+		case item.Kind != yaml.ScalarNode || item.Value == "":
+			this.fail(item, "Parent role names must not be empty.")
+		case item.Value == name:
+			this.fail(item, "Role %v can not inherit from itself.", name)
+		case !this.roleNames[item.Value]:
+			this.fail(item, "Parent role %v is not defined before role %v.", item.Value, name)
+		case listed[item.Value]:
+			this.fail(item, "Parent role %v is listed more than once.", item.Value)
+		default:
+			listed[item.Value] = true
+			parentNames = append(parentNames, item.Value)
+		}
+	}
+	return parentNames
+}
+
+func (this *policyParser) parseRolePermissions(node *yaml.Node) nogo.Permission {
+	mask := nogo.EmptyPermissionMask
+	if node.Kind != yaml.SequenceNode {
+		this.fail(node, "Role permissions must be a list of permission names.")
+		return mask
+	}
+	for _, item := range node.Content {
+		permission, ok := this.policy.Permissions[item.Value]
+		if item.Kind != yaml.ScalarNode || !ok {
+			this.fail(item, "Permission %v is not defined.", item.Value)
+			continue
+		}
+		if mask&permission != 0 {
+			this.fail(item, "Permission %v is listed more than once.", item.Value)
+			continue
+		}
+		mask |= permission
+	}
+	return mask
+}
+
+// A role defined by the policy that differs from the role of the same name in a repository.
+type RoleUpdate struct {
+	// The role currently in the repository.
+	Before nogo.Role
+	// The role defined by the policy.
+	After nogo.Role
+}
+
+// The differences between the roles defined by a policy and the roles in a repository.
+type RoleDiff struct {
+	// The roles defined by the policy that are missing from the repository, in document order.
+	Created []nogo.Role
+	// The roles defined by the policy that differ from the repository, in document order.
+	Updated []*RoleUpdate
+	// The names of the roles defined by the policy that match the repository, in document order.
+	Unchanged []string
+	// The names of the roles in the repository that the policy does not define, in repository order. Seeding leaves them untouched.
+	Unmanaged []string
+	// the permissions defined by the policy, used to name the permissions of roles
+	permissions map[string]nogo.Permission
+}
+
+// Returns true if seeding would not change the repository.
+func (this *RoleDiff) IsEmpty() bool {
+	return len(this.Created) == 0 && len(this.Updated) == 0
+}
+
+// Returns a line per role describing the difference, prefixed with "+" for created roles, "~" for updated roles, "=" for unchanged roles and "?" for unmanaged roles.
+func (this *RoleDiff) String() string {
+	var builder strings.Builder
+	for _, role := range this.Created {
+		fmt.Fprintf(&builder, "+ %v %v\n", role.GetName(), this.describe(role))
+	}
+	for _, update := range this.Updated {
+		fmt.Fprintf(&builder, "~ %v %v -> %v\n", update.After.GetName(), this.describe(update.Before), this.describe(update.After))
+	}
+	for _, roleName := range this.Unchanged {
+		fmt.Fprintf(&builder, "= %v\n", roleName)
+	}
+	for _, roleName := range this.Unmanaged {
+		fmt.Fprintf(&builder, "? %v\n", roleName)
+	}
+	return builder.String()
+}
+
+// describes the role's admin flag and permissions, naming permissions the policy does not define by bit.
+func (this *RoleDiff) describe(role nogo.Role) string {
+	mask, err := roleMask(role)
+	if err != nil {
+		return fmt.Sprintf("(%v)", err)
+	}
+	names := make([]string, 0)
+	for bit := 0; bit < nogo.MaxPermissions; bit++ {
+		permission := nogo.Permission(1) << uint(bit)
+		if mask&permission == 0 {
+			continue
+		}
+		name := fmt.Sprintf("bit %d", bit)
+		for permissionName, definedPermission := range this.permissions {
+			if definedPermission == permission {
+				name = permissionName
+				break
+			}
+		}
+		names = append(names, name)
+	}
+	description := fmt.Sprintf("[%v]", strings.Join(names, ", "))
+	if role.IsAdmin() {
+		description = "admin " + description
+	}
+	if parents := parentRoleNames(role); len(parents) > 0 {
+		description += fmt.Sprintf(" inherits [%v]", strings.Join(parents, ", "))
+	}
+	return description
+}
+
+// Compares the roles defined by the policy with those returned by the repository's FindAll, without changing the repository.
+func (this *Policy) Diff(repo nogo.RoleRepository) (*RoleDiff, error) {
+	existingRoles, err := repo.FindAll()
+	if err != nil {
+		return nil, fmt.Errorf("Could not find roles: %w", err)
+	}
+	existing := make(map[string]nogo.Role)
+	for _, role := range existingRoles {
+		existing[role.GetName()] = role
+	}
+	diff := &RoleDiff{permissions: this.Permissions}
+	defined := make(map[string]bool)
+	for _, role := range this.Roles {
+		defined[role.GetName()] = true
+		current, ok := existing[role.GetName()]
+		if !ok {
+			diff.Created = append(diff.Created, role)
+			continue
+		}
+		same, err := sameRole(current, role)
+		if err != nil {
+			return nil, err
+		}
+		if same {
+			diff.Unchanged = append(diff.Unchanged, role.GetName())
+		} else {
+			diff.Updated = append(diff.Updated, &RoleUpdate{Before: current, After: role})
+		}
+	}
+	for _, role := range existingRoles {
+		if !defined[role.GetName()] {
+			diff.Unmanaged = append(diff.Unmanaged, role.GetName())
+		}
+	}
+	return diff, nil
+}
+
+// Creates the roles defined by the policy that are missing from the repository and then updates those that differ, each in document order, returning the differences that were applied. Roles the policy does not define are left untouched. Seeding is not atomic: returns an error if a role could not be created or updated, in which case the roles created or updated before it are kept rather than rolled back. Seeding again applies the remaining differences.
+func (this *Policy) Seed(repo nogo.RoleRepository) (*RoleDiff, error) {
+	diff, err := this.Diff(repo)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range diff.Created {
+		if err := repo.CreateRole(role); err != nil {
+			return nil, fmt.Errorf("Could not create role %v: %w", role.GetName(), err)
+		}
+	}
+	for _, update := range diff.Updated {
+		if err := repo.UpdateRole(update.After); err != nil {
+			return nil, fmt.Errorf("Could not update role %v: %w", update.After.GetName(), err)
+		}
+	}
+	return diff, nil
+}
+
+// returns true if the current role has the same admin flag, permissions and parents, in any order, as the role defined by the policy.
+func sameRole(current nogo.Role, role nogo.Role) (bool, error) {
+	if current.IsAdmin() != role.IsAdmin() || !sameNames(parentRoleNames(current), parentRoleNames(role)) {
+		return false, nil
+	}
+	currentMask, err := roleMask(current)
+	if err != nil {
+		return false, err
+	}
+	mask, err := roleMask(role)
+	if err != nil {
+		return false, err
+	}
+	return currentMask == mask, nil
+}
+
+// returns the permission mask of the role by probing each permission bit.
+func roleMask(role nogo.Role) (nogo.Permission, error) {
+	mask := nogo.EmptyPermissionMask
+	for bit := 0; bit < nogo.MaxPermissions; bit++ {
+		permission := nogo.Permission(1) << uint(bit)
+		granted, err := role.HasPermission(permission)
+		if err != nil {
+			return mask, errors.New(fmt.Sprintf("Could not resolve the permissions of role %v: %v", role.GetName(), err))
+		}
+		if granted {
+			mask |= permission
+		}
+	}
+	return mask, nil
+}
+
+// returns true if both lists hold the same names, ignoring order.
+func sameNames(names []string, others []string) bool {
+	if len(names) != len(others) {
+		return false
+	}
+	counts := make(map[string]int)
+	for _, name := range names {
+		counts[name]++
+	}
+	for _, name := range others {
+		if counts[name] == 0 {
+			return false
+		}
+		counts[name]--
+	}
+	return true
+}
+
+func parentRoleNames(role nogo.Role) []string {
+	if hierarchicalRole, ok := role.(nogo.HierarchicalRole); ok {
+		return hierarchicalRole.GetParentRoleNames()
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogopolicy
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dakiva/nogo"
+	"github.com/stretchr/testify/assert"
+)
+
+const yamlPolicy = `permissions:
+  read: 0
+  update: 1
+  delete: 2
+roles:
+  - name: reader
+    permissions: [read]
+  - name: editor
+    permissions:
+      - read
+      - update
+  - name: admin
+    admin: true
+    permissions: [read, update, delete]
+`
+
+func TestParseYAML(t *testing.T) {
+	// when
+	policy, err := Parse([]byte(yamlPolicy))
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]nogo.Permission{"read": 1, "update": 2, "delete": 4}, policy.Permissions)
+	assert.Equal(t, []nogo.Role{nogo.NewRole("reader", 1), nogo.NewRole("editor", 3), nogo.NewAdminRole("admin", 7)}, policy.Roles)
+}
+
+func TestLoadJSON(t *testing.T) {
+	// given
+	document := `{
+  "permissions": {"read": 0, "update": 63},
+  "roles": [
+    {"name": "editor", "admin": false, "permissions": ["read", "update"]}
+  ]
+}`
+
+	// when
+	policy, err := Load(strings.NewReader(document))
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, []nogo.Role{nogo.NewRole("editor", 1|1<<63)}, policy.Roles)
+}
+
+func TestParseReportsLineNumbers(t *testing.T) {
+	// given
+	document := `permissions:
+  read: 0
+  update: 0
+  delete: 64
+roles:
+  - name: reader
+    permissions: [read]
+  - name: editor
+    permissions: [read, write]
+  - name: reader
+  - admin: maybe
+    colour: blue
+`
+
+	// when
+	_, err := Parse([]byte(document))
+
+	// then
+	var validationErrors ValidationErrors
+	assert.True(t, errors.As(err, &validationErrors))
+	assert.Equal(t, 6, len(validationErrors))
+	assert.Equal(t, &ValidationError{Line: 3, Column: 11, Message: "Permission update uses bit 0, which is already used by permission read."}, validationErrors[0])
+	assert.Equal(t, &ValidationError{Line: 4, Column: 11, Message: "Permission delete must be a bit between 0 and 63."}, validationErrors[1])
+	assert.Equal(t, &ValidationError{Line: 9, Column: 25, Message: "Permission write is not defined."}, validationErrors[2])
+	assert.Equal(t, &ValidationError{Line: 10, Column: 5, Message: "Role reader is defined more than once."}, validationErrors[3])
+	assert.Equal(t, &ValidationError{Line: 11, Column: 12, Message: "The admin flag must be true or false."}, validationErrors[4])
+	assert.Equal(t, &ValidationError{Line: 12, Column: 5, Message: "Unknown role field colour."}, validationErrors[5])
+	assert.True(t, strings.HasPrefix(err.Error(), "line 3, column 11: Permission update"))
+}
+
+func TestParseRoleParents(t *testing.T) {
+	// given
+	document := `permissions:
+  read: 0
+  update: 1
+roles:
+  - name: reader
+    permissions: [read]
+  - name: editor
+    parents: [reader]
+    permissions: [update]
+`
+
+	// when
+	policy, err := Parse([]byte(document))
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, []nogo.Role{nogo.NewRole("reader", 1), nogo.NewHierarchicalRole("editor", 2, "reader")}, policy.Roles)
+}
+
+func TestParseReportsInvalidParentsAndDuplicateFields(t *testing.T) {
+	// given
+	document := `permissions:
+  read: 0
+roles:
+  - name: editor
+    parents: [reader, editor]
+  - name: reader
+    name: viewer
+  - name: admin
+    admin: true
+    parents: [editor]
+permissions:
+  update: 1
+`
+
+	// when
+	_, err := Parse([]byte(document))
+
+	// then
+	var validationErrors ValidationErrors
+	assert.True(t, errors.As(err, &validationErrors))
+	assert.Equal(t, 5, len(validationErrors))
+	assert.Equal(t, &ValidationError{Line: 11, Column: 1, Message: "Policy field permissions is defined more than once."}, validationErrors[0])
+	assert.Equal(t, &ValidationError{Line: 5, Column: 15, Message: "Parent role reader is not defined before role editor."}, validationErrors[1])
+	assert.Equal(t, &ValidationError{Line: 5, Column: 23, Message: "Role editor can not inherit from itself."}, validationErrors[2])
+	assert.Equal(t, &ValidationError{Line: 7, Column: 5, Message: "Role field name is defined more than once."}, validationErrors[3])
+	assert.Equal(t, &ValidationError{Line: 10, Column: 14, Message: "Admin role admin can not inherit from other roles."}, validationErrors[4])
+}
+
+func TestParseMalformedDocument(t *testing.T) {
+	// when
+	_, err := Parse([]byte("permissions:\n  read: [0\n"))
+	_, emptyErr := Parse([]byte(""))
+
+	// then
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "line")
+	assert.Equal(t, "line 1, column 1: Policy document is empty.", emptyErr.Error())
+}
+
+func TestDiffAndSeed(t *testing.T) {
+	// given
+	policy, _ := Parse([]byte(yamlPolicy))
+	repo := nogo.NewMapBackedRoleRepository()
+	repo.CreateRole(nogo.NewRole("reader", 1))
+	repo.CreateRole(nogo.NewRole("editor", 1|8))
+	repo.CreateRole(nogo.NewRole("auditor", 1))
+
+	// when
+	diff, err := policy.Diff(repo)
+
+	// then
+	assert.Nil(t, err)
+	assert.False(t, diff.IsEmpty())
+	assert.Equal(t, []nogo.Role{nogo.NewAdminRole("admin", 7)}, diff.Created)
+	assert.Equal(t, 1, len(diff.Updated))
+	assert.Equal(t, "editor", diff.Updated[0].After.GetName())
+	assert.Equal(t, []string{"reader"}, diff.Unchanged)
+	assert.Equal(t, []string{"auditor"}, diff.Unmanaged)
+	assert.Equal(t, "+ admin admin [read, update, delete]\n~ editor [read, bit 3] -> [read, update]\n= reader\n? auditor\n", diff.String())
+	editor, _ := repo.FindRole("editor")
+	assert.Equal(t, nogo.NewRole("editor", 1|8), editor)
+
+	// when
+	applied, err := policy.Seed(repo)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, diff.String(), applied.String())
+	after, _ := policy.Diff(repo)
+	assert.True(t, after.IsEmpty())
+	editor, _ = repo.FindRole("editor")
+	assert.Equal(t, nogo.NewRole("editor", 3), editor)
+	auditor, _ := repo.FindRole("auditor")
+	assert.NotNil(t, auditor)
+}
+
+func TestSeedKeepsInheritance(t *testing.T) {
+	// given
+	document := `permissions:
+  read: 0
+  update: 1
+roles:
+  - name: reader
+    permissions: [read]
+  - name: editor
+    permissions: [update]
+    parents: [reader]
+`
+	policy, _ := Parse([]byte(document))
+	repo := nogo.NewMapBackedRoleRepository()
+	repo.CreateRole(nogo.NewRole("reader", 1))
+	repo.CreateRole(nogo.NewRole("editor", 2))
+
+	// when
+	diff, err := policy.Seed(repo)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "~ editor [update] -> [update] inherits [reader]\n= reader\n", diff.String())
+	editor, _ := repo.FindRole("editor")
+	assert.Equal(t, []string{"reader"}, editor.(nogo.HierarchicalRole).GetParentRoleNames())
+	after, _ := policy.Diff(repo)
+	assert.True(t, after.IsEmpty())
+}
+
+func TestSeedKeepsAppliedRolesOnFailure(t *testing.T) {
+	// given
+	policy, _ := Parse([]byte(yamlPolicy))
+	repo := &failingUpdateRoleRepository{RoleRepository: nogo.NewMapBackedRoleRepository(), err: errors.New("unavailable")}
+	repo.CreateRole(nogo.NewRole("editor", 1))
+
+	// when
+	_, err := policy.Seed(repo)
+
+	// then
+	assert.True(t, errors.Is(err, repo.err))
+	reader, _ := repo.FindRole("reader")
+	assert.Equal(t, nogo.NewRole("reader", 1), reader)
+	editor, _ := repo.FindRole("editor")
+	assert.Equal(t, nogo.NewRole("editor", 1), editor)
+
+	// when
+	repo.err = nil
+	diff, err := policy.Seed(repo)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "~ editor [read] -> [read, update]\n= reader\n= admin\n", diff.String())
+}
+
+// a role repository whose updates fail with err, when set
+type failingUpdateRoleRepository struct {
+	nogo.RoleRepository
+	err error
+}
+
+func (this *failingUpdateRoleRepository) UpdateRole(role nogo.Role) error {
+	if this.err != nil {
+		return this.err
+	}
+	return this.RoleRepository.UpdateRole(role)
+}
@@ -0,0 +1,83 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nogoprom exports nogo authorization metrics to Prometheus.
+package nogoprom
+
+import (
+	"time"
+
+	"github.com/dakiva/nogo"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type prometheusMetrics struct {
+	checks             *prometheus.CounterVec
+	checkDuration      *prometheus.HistogramVec
+	repositoryErrors   *prometheus.CounterVec
+	repositoryDuration *prometheus.HistogramVec
+}
+
+// Construct nogo.Metrics backed by Prometheus collectors registered with the registerer, prefixed with the namespace. The following collectors are registered:
+//
+//	<namespace>_authorization_checks_total{kind, outcome}
+//	<namespace>_authorization_check_duration_seconds{kind}
+//	<namespace>_repository_errors_total{repository, operation}
+//	<namespace>_repository_call_duration_seconds{repository, operation}
+//
+// Returns an error if a collector could not be registered.
+func NewMetrics(registerer prometheus.Registerer, namespace string) (nogo.Metrics, error) {
+	metrics := &prometheusMetrics{
+		checks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "authorization_checks_total",
+			Help:      "The number of authorization checks by kind and outcome.",
+		}, []string{"kind", "outcome"}),
+		checkDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "authorization_check_duration_seconds",
+			Help:      "The latency of authorization checks by kind.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"kind"}),
+		repositoryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "repository_errors_total",
+			Help:      "The number of failed repository calls by repository and operation.",
+		}, []string{"repository", "operation"}),
+		repositoryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "repository_call_duration_seconds",
+			Help:      "The latency of repository calls by repository and operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"repository", "operation"}),
+	}
+	for _, collector := range []prometheus.Collector{metrics.checks, metrics.checkDuration, metrics.repositoryErrors, metrics.repositoryDuration} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return metrics, nil
+}
+
+func (this *prometheusMetrics) ObserveCheck(kind string, outcome string, duration time.Duration) {
+	this.checks.WithLabelValues(kind, outcome).Inc()
+	this.checkDuration.WithLabelValues(kind).Observe(duration.Seconds())
+}
+
+func (this *prometheusMetrics) ObserveRepositoryCall(repository string, operation string, failed bool, duration time.Duration) {
+	if failed {
+		this.repositoryErrors.WithLabelValues(repository, operation).Inc()
+	}
+	this.repositoryDuration.WithLabelValues(repository, operation).Observe(duration.Seconds())
+}
@@ -0,0 +1,86 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogoprom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dakiva/nogo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	read nogo.Permission = 1 << iota
+	update
+)
+
+func TestMetricsCountChecks(t *testing.T) {
+	// given
+	registry := prometheus.NewRegistry()
+	metrics, err := NewMetrics(registry, "nogo")
+	assert.Nil(t, err)
+	roleRepo := nogo.NewMapBackedRoleRepository()
+	roleRepo.CreateRole(nogo.NewRole("reader", read))
+	strategy := nogo.NewInstrumentedAccessControlStrategy(nogo.NewAccessControlStrategy(nil, nogo.NewInstrumentedRoleRepository(roleRepo, metrics), false), metrics)
+	principal := &testPrincipal{id: "bob", sid: "bob", roleNames: []string{"reader"}}
+
+	// when
+	strategy.VerifyRoleAccess(principal, read)
+	strategy.VerifyRoleAccess(principal, update)
+	strategy.VerifyRoleAccess(principal, update)
+	metrics.ObserveRepositoryCall(nogo.RoleRepositoryName, "FindAll", true, time.Millisecond)
+
+	// then
+	checks, _ := registry.Gather()
+	assert.Equal(t, 4, len(checks))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.(*prometheusMetrics).checks.WithLabelValues(nogo.RoleAccessCheck, nogo.GrantedOutcome)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.(*prometheusMetrics).checks.WithLabelValues(nogo.RoleAccessCheck, nogo.DeniedOutcome)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.(*prometheusMetrics).repositoryErrors.WithLabelValues(nogo.RoleRepositoryName, "FindAll")))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.(*prometheusMetrics).checkDuration))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.(*prometheusMetrics).repositoryDuration))
+}
+
+func TestMetricsRegistrationConflict(t *testing.T) {
+	// given
+	registry := prometheus.NewRegistry()
+	NewMetrics(registry, "nogo")
+
+	// when
+	_, err := NewMetrics(registry, "nogo")
+
+	// then
+	assert.NotNil(t, err)
+}
+
+type testPrincipal struct {
+	id        string
+	sid       string
+	roleNames []string
+}
+
+func (this *testPrincipal) GetId() string {
+	return this.id
+}
+
+func (this *testPrincipal) GetSid() string {
+	return this.sid
+}
+
+func (this *testPrincipal) GetRoleNames() []string {
+	return this.roleNames
+}
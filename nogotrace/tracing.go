@@ -0,0 +1,403 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nogotrace records OpenTelemetry spans for nogo access checks and repository calls.
+package nogotrace
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dakiva/nogo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The name of the tracer used to create spans.
+const TracerName = "github.com/dakiva/nogo"
+
+// Span attribute keys.
+const (
+	PrincipalIdKey        = attribute.Key("nogo.principal.id")
+	PermissionKey         = attribute.Key("nogo.permission")
+	ResourceIdKey         = attribute.Key("nogo.resource.id")
+	DecisionKey           = attribute.Key("nogo.decision")
+	RuleKey               = attribute.Key("nogo.rule")
+	DecidingResourceIdKey = attribute.Key("nogo.deciding_resource.id")
+	AncestorsWalkedKey    = attribute.Key("nogo.ancestors_walked")
+	ResourceCountKey      = attribute.Key("nogo.resource.count")
+	AuthorizedCountKey    = attribute.Key("nogo.authorized.count")
+	RoleNameKey           = attribute.Key("nogo.role.name")
+)
+
+type tracingStrategy struct {
+	delegate nogo.ContextAccessControlStrategy
+	tracer   trace.Tracer
+}
+
+// Construct an AccessControlStrategy that records a span for every check made through the given strategy, using a tracer from the provider. Spans carry the principal id, permission, resource id, decision, deciding rule and the number of ancestors walked. Verify checks are made with the given strategy's Verify methods, so decorators such as an auditing strategy still see them, and take the rule from the decision reported through nogo.WithDecisionObserver by the same evaluation. Repository calls made with the check's context, such as those of repositories wrapped with NewRoleRepository and NewSecureResourceRepository, are recorded as child spans.
+func NewAccessControlStrategy(strategy nogo.AccessControlStrategy, provider trace.TracerProvider) nogo.ContextAccessControlStrategy {
+	return &tracingStrategy{delegate: nogo.AdaptAccessControlStrategy(strategy), tracer: provider.Tracer(TracerName)}
+}
+
+func (this *tracingStrategy) VerifyRoleAccess(principal nogo.Principal, permission nogo.Permission) error {
+	return this.VerifyRoleAccessContext(context.Background(), principal, permission)
+}
+
+func (this *tracingStrategy) VerifyResourceAccess(principal nogo.Principal, permission nogo.Permission, resource nogo.SecureResource) error {
+	return this.VerifyResourceAccessContext(context.Background(), principal, permission, resource)
+}
+
+func (this *tracingStrategy) VerifyResourceAccessById(principal nogo.Principal, permission nogo.Permission, resourceId string) error {
+	return this.VerifyResourceAccessByIdContext(context.Background(), principal, permission, resourceId)
+}
+
+func (this *tracingStrategy) ExplainRoleAccess(principal nogo.Principal, permission nogo.Permission) (*nogo.Decision, error) {
+	return this.ExplainRoleAccessContext(context.Background(), principal, permission)
+}
+
+func (this *tracingStrategy) ExplainResourceAccess(principal nogo.Principal, permission nogo.Permission, resource nogo.SecureResource) (*nogo.Decision, error) {
+	return this.ExplainResourceAccessContext(context.Background(), principal, permission, resource)
+}
+
+func (this *tracingStrategy) ExplainResourceAccessById(principal nogo.Principal, permission nogo.Permission, resourceId string) (*nogo.Decision, error) {
+	return this.ExplainResourceAccessByIdContext(context.Background(), principal, permission, resourceId)
+}
+
+func (this *tracingStrategy) FilterAuthorized(principal nogo.Principal, permission nogo.Permission, resources []nogo.SecureResource) ([]nogo.SecureResource, error) {
+	return this.FilterAuthorizedContext(context.Background(), principal, permission, resources)
+}
+
+func (this *tracingStrategy) VerifyRoleAccessContext(ctx context.Context, principal nogo.Principal, permission nogo.Permission) error {
+	ctx, span := this.startCheck(ctx, "nogo.VerifyRoleAccess", principal, permission, "")
+	defer span.End()
+	var decision *nogo.Decision
+	err := this.delegate.VerifyRoleAccessContext(observeInto(ctx, &decision), principal, permission)
+	recordOutcome(span, decision, err)
+	return err
+}
+
+func (this *tracingStrategy) VerifyResourceAccessContext(ctx context.Context, principal nogo.Principal, permission nogo.Permission, resource nogo.SecureResource) error {
+	ctx, span := this.startCheck(ctx, "nogo.VerifyResourceAccess", principal, permission, nativeId(resource))
+	defer span.End()
+	var decision *nogo.Decision
+	err := this.delegate.VerifyResourceAccessContext(observeInto(ctx, &decision), principal, permission, resource)
+	recordOutcome(span, decision, err)
+	return err
+}
+
+func (this *tracingStrategy) VerifyResourceAccessByIdContext(ctx context.Context, principal nogo.Principal, permission nogo.Permission, resourceId string) error {
+	ctx, span := this.startCheck(ctx, "nogo.VerifyResourceAccessById", principal, permission, resourceId)
+	defer span.End()
+	var decision *nogo.Decision
+	err := this.delegate.VerifyResourceAccessByIdContext(observeInto(ctx, &decision), principal, permission, resourceId)
+	recordOutcome(span, decision, err)
+	return err
+}
+
+func (this *tracingStrategy) ExplainRoleAccessContext(ctx context.Context, principal nogo.Principal, permission nogo.Permission) (*nogo.Decision, error) {
+	ctx, span := this.startCheck(ctx, "nogo.ExplainRoleAccess", principal, permission, "")
+	defer span.End()
+	decision, err := this.delegate.ExplainRoleAccessContext(ctx, principal, permission)
+	recordDecision(span, decision, err)
+	return decision, err
+}
+
+func (this *tracingStrategy) ExplainResourceAccessContext(ctx context.Context, principal nogo.Principal, permission nogo.Permission, resource nogo.SecureResource) (*nogo.Decision, error) {
+	ctx, span := this.startCheck(ctx, "nogo.ExplainResourceAccess", principal, permission, nativeId(resource))
+	defer span.End()
+	decision, err := this.delegate.ExplainResourceAccessContext(ctx, principal, permission, resource)
+	recordDecision(span, decision, err)
+	return decision, err
+}
+
+func (this *tracingStrategy) ExplainResourceAccessByIdContext(ctx context.Context, principal nogo.Principal, permission nogo.Permission, resourceId string) (*nogo.Decision, error) {
+	ctx, span := this.startCheck(ctx, "nogo.ExplainResourceAccessById", principal, permission, resourceId)
+	defer span.End()
+	decision, err := this.delegate.ExplainResourceAccessByIdContext(ctx, principal, permission, resourceId)
+	recordDecision(span, decision, err)
+	return decision, err
+}
+
+func (this *tracingStrategy) FilterAuthorizedContext(ctx context.Context, principal nogo.Principal, permission nogo.Permission, resources []nogo.SecureResource) ([]nogo.SecureResource, error) {
+	ctx, span := this.startCheck(ctx, "nogo.FilterAuthorized", principal, permission, "")
+	defer span.End()
+	span.SetAttributes(ResourceCountKey.Int(len(resources)))
+	authorized, err := this.delegate.FilterAuthorizedContext(ctx, principal, permission, resources)
+	if err != nil {
+		recordError(span, err)
+	} else {
+		span.SetAttributes(AuthorizedCountKey.Int(len(authorized)))
+	}
+	return authorized, err
+}
+
+func (this *tracingStrategy) startCheck(ctx context.Context, name string, principal nogo.Principal, permission nogo.Permission, resourceId string) (context.Context, trace.Span) {
+	attributes := []attribute.KeyValue{PrincipalIdKey.String(principal.GetId()), PermissionKey.Int64(int64(permission))}
+	if resourceId != "" {
+		attributes = append(attributes, ResourceIdKey.String(resourceId))
+	}
+	return this.tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// returns a context storing the decision reported by the check made with it.
+func observeInto(ctx context.Context, decision **nogo.Decision) context.Context {
+	return nogo.WithDecisionObserver(ctx, func(observed *nogo.Decision) {
+		*decision = observed
+	})
+}
+
+// records the outcome of a Verify check on the span, along with the details of the decision the check reported, if any.
+func recordOutcome(span trace.Span, decision *nogo.Decision, err error) {
+	switch {
+	case err == nil:
+		span.SetAttributes(DecisionKey.String("granted"))
+	case errors.Is(err, nogo.ErrAccessDenied):
+		span.SetAttributes(DecisionKey.String("denied"))
+	default:
+		recordError(span, err)
+		return
+	}
+	if decision != nil {
+		recordDetails(span, decision)
+	}
+}
+
+func recordDecision(span trace.Span, decision *nogo.Decision, err error) {
+	if err != nil {
+		recordError(span, err)
+		return
+	}
+	outcome := "denied"
+	if decision.Granted {
+		outcome = "granted"
+	}
+	span.SetAttributes(DecisionKey.String(outcome))
+	recordDetails(span, decision)
+}
+
+// records the deciding rule, deciding resource and number of ancestors walked of the decision on the span.
+func recordDetails(span trace.Span, decision *nogo.Decision) {
+	span.SetAttributes(RuleKey.String(decision.Rule.String()))
+	if decision.DecidingResourceId != "" {
+		span.SetAttributes(DecidingResourceIdKey.String(decision.DecidingResourceId))
+	}
+	if len(decision.ResourcesConsulted) > 0 {
+		span.SetAttributes(AncestorsWalkedKey.Int(len(decision.ResourcesConsulted) - 1))
+	}
+}
+
+// records a failed call on the span. Lookups of resources that do not exist are not considered failures.
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	if !errors.Is(err, nogo.ErrResourceNotFound) && !errors.Is(err, nogo.ErrAccessDenied) {
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func nativeId(resource nogo.SecureResource) string {
+	if resource == nil {
+		return ""
+	}
+	return resource.GetNativeId()
+}
+
+type tracingRoleRepository struct {
+	nogo.ContextRoleRepository
+	tracer trace.Tracer
+}
+
+// Construct a RoleRepository that records a span for every call to the given repository, using a tracer from the provider.
+func NewRoleRepository(repo nogo.RoleRepository, provider trace.TracerProvider) nogo.ContextRoleRepository {
+	return &tracingRoleRepository{ContextRoleRepository: nogo.AdaptRoleRepository(repo), tracer: provider.Tracer(TracerName)}
+}
+
+func (this *tracingRoleRepository) FindAll() ([]nogo.Role, error) {
+	return this.FindAllContext(context.Background())
+}
+
+func (this *tracingRoleRepository) FindRole(roleName string) (nogo.Role, error) {
+	return this.FindRoleContext(context.Background(), roleName)
+}
+
+func (this *tracingRoleRepository) CreateRole(role nogo.Role) error {
+	return this.CreateRoleContext(context.Background(), role)
+}
+
+func (this *tracingRoleRepository) UpdateRole(role nogo.Role) error {
+	return this.UpdateRoleContext(context.Background(), role)
+}
+
+func (this *tracingRoleRepository) DeleteRole(roleName string) error {
+	return this.DeleteRoleContext(context.Background(), roleName)
+}
+
+func (this *tracingRoleRepository) FindAllContext(ctx context.Context) ([]nogo.Role, error) {
+	ctx, span := this.tracer.Start(ctx, "nogo.RoleRepository.FindAll")
+	defer span.End()
+	roles, err := this.ContextRoleRepository.FindAllContext(ctx)
+	endCall(span, err)
+	return roles, err
+}
+
+func (this *tracingRoleRepository) FindRoleContext(ctx context.Context, roleName string) (nogo.Role, error) {
+	ctx, span := this.tracer.Start(ctx, "nogo.RoleRepository.FindRole", trace.WithAttributes(RoleNameKey.String(roleName)))
+	defer span.End()
+	role, err := this.ContextRoleRepository.FindRoleContext(ctx, roleName)
+	endCall(span, err)
+	return role, err
+}
+
+func (this *tracingRoleRepository) CreateRoleContext(ctx context.Context, role nogo.Role) error {
+	ctx, span := this.tracer.Start(ctx, "nogo.RoleRepository.CreateRole", trace.WithAttributes(RoleNameKey.String(role.GetName())))
+	defer span.End()
+	err := this.ContextRoleRepository.CreateRoleContext(ctx, role)
+	endCall(span, err)
+	return err
+}
+
+func (this *tracingRoleRepository) UpdateRoleContext(ctx context.Context, role nogo.Role) error {
+	ctx, span := this.tracer.Start(ctx, "nogo.RoleRepository.UpdateRole", trace.WithAttributes(RoleNameKey.String(role.GetName())))
+	defer span.End()
+	err := this.ContextRoleRepository.UpdateRoleContext(ctx, role)
+	endCall(span, err)
+	return err
+}
+
+func (this *tracingRoleRepository) DeleteRoleContext(ctx context.Context, roleName string) error {
+	ctx, span := this.tracer.Start(ctx, "nogo.RoleRepository.DeleteRole", trace.WithAttributes(RoleNameKey.String(roleName)))
+	defer span.End()
+	err := this.ContextRoleRepository.DeleteRoleContext(ctx, roleName)
+	endCall(span, err)
+	return err
+}
+
+type tracingSecureResourceRepository struct {
+	nogo.ContextSecureResourceRepository
+	tracer trace.Tracer
+}
+
+// Construct a SecureResourceRepository that records a span for every call to the given repository, using a tracer from the provider. The returned repository also implements nogo.ACLEvaluator, nogo.AccessibleResourceFinder and nogo.ExpiredACEPurger when the given repository does, recording their calls as well.
+func NewSecureResourceRepository(repo nogo.SecureResourceRepository, provider trace.TracerProvider) nogo.ContextSecureResourceRepository {
+	tracer := provider.Tracer(TracerName)
+	extensions := nogo.FindSecureResourceRepositoryExtensions(repo)
+	if extensions.ACLEvaluator != nil {
+		extensions.ACLEvaluator = &tracingACLEvaluator{evaluator: extensions.ACLEvaluator, tracer: tracer}
+	}
+	if extensions.AccessibleResourceFinder != nil {
+		extensions.AccessibleResourceFinder = &tracingAccessibleResourceFinder{finder: extensions.AccessibleResourceFinder, tracer: tracer}
+	}
+	if extensions.ExpiredACEPurger != nil {
+		extensions.ExpiredACEPurger = &tracingExpiredACEPurger{purger: extensions.ExpiredACEPurger, tracer: tracer}
+	}
+	return nogo.ExtendSecureResourceRepository(&tracingSecureResourceRepository{ContextSecureResourceRepository: nogo.AdaptSecureResourceRepository(repo), tracer: tracer}, extensions)
+}
+
+func (this *tracingSecureResourceRepository) FindResource(nativeResourceId string) (nogo.SecureResource, error) {
+	return this.FindResourceContext(context.Background(), nativeResourceId)
+}
+
+func (this *tracingSecureResourceRepository) CreateResource(resource nogo.SecureResource) error {
+	return this.CreateResourceContext(context.Background(), resource)
+}
+
+func (this *tracingSecureResourceRepository) UpdateResource(resource nogo.SecureResource) error {
+	return this.UpdateResourceContext(context.Background(), resource)
+}
+
+func (this *tracingSecureResourceRepository) DeleteResource(nativeResourceId string) error {
+	return this.DeleteResourceContext(context.Background(), nativeResourceId)
+}
+
+func (this *tracingSecureResourceRepository) FindResourceContext(ctx context.Context, nativeResourceId string) (nogo.SecureResource, error) {
+	ctx, span := this.tracer.Start(ctx, "nogo.SecureResourceRepository.FindResource", trace.WithAttributes(ResourceIdKey.String(nativeResourceId)))
+	defer span.End()
+	resource, err := this.ContextSecureResourceRepository.FindResourceContext(ctx, nativeResourceId)
+	endCall(span, err)
+	return resource, err
+}
+
+func (this *tracingSecureResourceRepository) CreateResourceContext(ctx context.Context, resource nogo.SecureResource) error {
+	ctx, span := this.tracer.Start(ctx, "nogo.SecureResourceRepository.CreateResource", trace.WithAttributes(ResourceIdKey.String(resource.GetNativeId())))
+	defer span.End()
+	err := this.ContextSecureResourceRepository.CreateResourceContext(ctx, resource)
+	endCall(span, err)
+	return err
+}
+
+func (this *tracingSecureResourceRepository) UpdateResourceContext(ctx context.Context, resource nogo.SecureResource) error {
+	ctx, span := this.tracer.Start(ctx, "nogo.SecureResourceRepository.UpdateResource", trace.WithAttributes(ResourceIdKey.String(resource.GetNativeId())))
+	defer span.End()
+	err := this.ContextSecureResourceRepository.UpdateResourceContext(ctx, resource)
+	endCall(span, err)
+	return err
+}
+
+func (this *tracingSecureResourceRepository) DeleteResourceContext(ctx context.Context, nativeResourceId string) error {
+	ctx, span := this.tracer.Start(ctx, "nogo.SecureResourceRepository.DeleteResource", trace.WithAttributes(ResourceIdKey.String(nativeResourceId)))
+	defer span.End()
+	err := this.ContextSecureResourceRepository.DeleteResourceContext(ctx, nativeResourceId)
+	endCall(span, err)
+	return err
+}
+
+type tracingACLEvaluator struct {
+	evaluator nogo.ACLEvaluator
+	tracer    trace.Tracer
+}
+
+func (this *tracingACLEvaluator) EvaluateACL(ctx context.Context, sid string, groupSids []string, permission nogo.Permission, nativeResourceId string, at time.Time) (*nogo.ACLEvaluation, error) {
+	ctx, span := this.tracer.Start(ctx, "nogo.SecureResourceRepository.EvaluateACL", trace.WithAttributes(ResourceIdKey.String(nativeResourceId), PermissionKey.Int64(int64(permission))))
+	defer span.End()
+	evaluation, err := this.evaluator.EvaluateACL(ctx, sid, groupSids, permission, nativeResourceId, at)
+	endCall(span, err)
+	return evaluation, err
+}
+
+type tracingAccessibleResourceFinder struct {
+	finder nogo.AccessibleResourceFinder
+	tracer trace.Tracer
+}
+
+func (this *tracingAccessibleResourceFinder) FindAccessibleResourceIds(ctx context.Context, sid string, groupSids []string, permission nogo.Permission, afterResourceId string, limit int, at time.Time) ([]string, error) {
+	ctx, span := this.tracer.Start(ctx, "nogo.SecureResourceRepository.FindAccessibleResourceIds", trace.WithAttributes(PermissionKey.Int64(int64(permission))))
+	defer span.End()
+	ids, err := this.finder.FindAccessibleResourceIds(ctx, sid, groupSids, permission, afterResourceId, limit, at)
+	if err == nil {
+		span.SetAttributes(ResourceCountKey.Int(len(ids)))
+	}
+	endCall(span, err)
+	return ids, err
+}
+
+type tracingExpiredACEPurger struct {
+	purger nogo.ExpiredACEPurger
+	tracer trace.Tracer
+}
+
+func (this *tracingExpiredACEPurger) PurgeExpiredACEs(ctx context.Context, before time.Time) (int, error) {
+	ctx, span := this.tracer.Start(ctx, "nogo.SecureResourceRepository.PurgeExpiredACEs")
+	defer span.End()
+	count, err := this.purger.PurgeExpiredACEs(ctx, before)
+	endCall(span, err)
+	return count, err
+}
+
+func endCall(span trace.Span, err error) {
+	if err != nil {
+		recordError(span, err)
+	}
+}
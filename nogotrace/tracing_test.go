@@ -0,0 +1,237 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogotrace
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dakiva/nogo"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const (
+	read nogo.Permission = 1 << iota
+	update
+)
+
+func TestTraceResourceAccessById(t *testing.T) {
+	// given
+	recorder, provider := newRecordingProvider()
+	resourceRepo := nogo.NewMapBackedSecureResourceRepository()
+	folder := nogo.NewSecureResource("folder", "alice", nil, false)
+	folderACL, _ := folder.GetACL()
+	folderACL.AddACE(nogo.NewACE("bob", read))
+	resourceRepo.CreateResource(folder)
+	resourceRepo.CreateResource(nogo.NewSecureResource("doc", "alice", folder, true))
+	roleRepo := nogo.NewMapBackedRoleRepository()
+	strategy := NewAccessControlStrategy(nogo.NewAccessControlStrategy(NewSecureResourceRepository(resourceRepo, provider), NewRoleRepository(roleRepo, provider), false), provider)
+	principal := &testPrincipal{id: "bob", sid: "bob"}
+
+	// when
+	err := strategy.VerifyResourceAccessById(principal, read, "doc")
+	_, explainErr := strategy.ExplainResourceAccessById(principal, read, "doc")
+
+	// then
+	assert.Nil(t, err)
+	assert.Nil(t, explainErr)
+	spans := recorder.Ended()
+	check := findSpan(spans, "nogo.VerifyResourceAccessById")
+	assert.NotNil(t, check)
+	attributes := attributeMap(check)
+	assert.Equal(t, "bob", attributes[PrincipalIdKey])
+	assert.Equal(t, int64(read), attributes[PermissionKey])
+	assert.Equal(t, "doc", attributes[ResourceIdKey])
+	assert.Equal(t, "granted", attributes[DecisionKey])
+	assert.Equal(t, nogo.SidACERule.String(), attributes[RuleKey])
+	assert.Equal(t, "folder", attributes[DecidingResourceIdKey])
+	assert.Equal(t, int64(1), attributes[AncestorsWalkedKey])
+	explain := findSpan(spans, "nogo.ExplainResourceAccessById")
+	assert.NotNil(t, explain)
+	attributes = attributeMap(explain)
+	assert.Equal(t, "granted", attributes[DecisionKey])
+	assert.Equal(t, nogo.SidACERule.String(), attributes[RuleKey])
+	assert.Equal(t, "folder", attributes[DecidingResourceIdKey])
+	assert.Equal(t, int64(1), attributes[AncestorsWalkedKey])
+	find := findSpan(spans, "nogo.SecureResourceRepository.FindResource")
+	assert.NotNil(t, find)
+	assert.Equal(t, check.SpanContext().SpanID(), find.Parent().SpanID())
+	assert.Equal(t, check.SpanContext().TraceID(), find.SpanContext().TraceID())
+}
+
+func TestTraceRoleAccessDenied(t *testing.T) {
+	// given
+	recorder, provider := newRecordingProvider()
+	roleRepo := nogo.NewMapBackedRoleRepository()
+	roleRepo.CreateRole(nogo.NewRole("reader", read))
+	strategy := NewAccessControlStrategy(nogo.NewAccessControlStrategy(nil, NewRoleRepository(roleRepo, provider), false), provider)
+	principal := &testPrincipal{id: "bob", sid: "bob", roleNames: []string{"reader"}}
+
+	// when
+	err := strategy.VerifyRoleAccess(principal, update)
+
+	// then
+	assert.True(t, errors.Is(err, nogo.ErrAccessDenied))
+	spans := recorder.Ended()
+	check := findSpan(spans, "nogo.VerifyRoleAccess")
+	assert.NotNil(t, check)
+	assert.Equal(t, "denied", attributeMap(check)[DecisionKey])
+	assert.Equal(t, codes.Unset, check.Status().Code)
+	findAll := findSpan(spans, "nogo.RoleRepository.FindAll")
+	assert.NotNil(t, findAll)
+	assert.Equal(t, check.SpanContext().SpanID(), findAll.Parent().SpanID())
+}
+
+func TestTraceAuditedStrategy(t *testing.T) {
+	// given
+	recorder, provider := newRecordingProvider()
+	roleRepo := nogo.NewMapBackedRoleRepository()
+	roleRepo.CreateRole(nogo.NewRole("reader", read))
+	sink := &recordingAuditSink{}
+	audited := nogo.NewAuditingAccessControlStrategy(nogo.NewAccessControlStrategy(nil, roleRepo, false), sink)
+	strategy := NewAccessControlStrategy(audited, provider)
+	principal := &testPrincipal{id: "bob", sid: "bob", roleNames: []string{"reader"}}
+
+	// when
+	granted := strategy.VerifyRoleAccess(principal, read)
+	denied := strategy.VerifyRoleAccess(principal, update)
+
+	// then
+	assert.Nil(t, granted)
+	assert.True(t, errors.Is(denied, nogo.ErrAccessDenied))
+	assert.Equal(t, 2, len(sink.events))
+	assert.True(t, sink.events[0].Granted)
+	assert.False(t, sink.events[1].Granted)
+	assert.Equal(t, 2, len(recorder.Ended()))
+	assert.Equal(t, "role", sink.events[0].Rule)
+	assert.Equal(t, nogo.RoleRule.String(), attributeMap(recorder.Ended()[0])[RuleKey])
+}
+
+func TestTraceForwardsOptionalInterfaces(t *testing.T) {
+	// given
+	recorder, provider := newRecordingProvider()
+	resourceRepo := NewSecureResourceRepository(nogo.NewMapBackedSecureResourceRepository(), provider)
+	resource := nogo.NewSecureResource("doc", "alice", nil, false)
+	acl, _ := resource.GetACL()
+	acl.AddACE(nogo.NewACE("bob", read))
+	resourceRepo.CreateResource(resource)
+
+	// when
+	_, isEvaluator := resourceRepo.(nogo.ACLEvaluator)
+	finder, isFinder := resourceRepo.(nogo.AccessibleResourceFinder)
+	_, isPurger := resourceRepo.(nogo.ExpiredACEPurger)
+	ids, err := finder.FindAccessibleResourceIds(context.Background(), "bob", nil, read, "", 0, time.Now())
+
+	// then
+	assert.False(t, isEvaluator)
+	assert.True(t, isFinder)
+	assert.True(t, isPurger)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"doc"}, ids)
+	find := findSpan(recorder.Ended(), "nogo.SecureResourceRepository.FindAccessibleResourceIds")
+	assert.NotNil(t, find)
+	assert.Equal(t, int64(1), attributeMap(find)[ResourceCountKey])
+}
+
+func TestTraceMissingResource(t *testing.T) {
+	// given
+	recorder, provider := newRecordingProvider()
+	resourceRepo := NewSecureResourceRepository(nogo.NewMapBackedSecureResourceRepository(), provider)
+	strategy := NewAccessControlStrategy(nogo.NewAccessControlStrategy(resourceRepo, nogo.NewMapBackedRoleRepository(), false), provider)
+	principal := &testPrincipal{id: "bob", sid: "bob"}
+
+	// when
+	err := strategy.VerifyResourceAccessById(principal, read, "missing")
+
+	// then
+	assert.True(t, errors.Is(err, nogo.ErrResourceNotFound))
+	check := findSpan(recorder.Ended(), "nogo.VerifyResourceAccessById")
+	assert.NotNil(t, check)
+	assert.Equal(t, codes.Unset, check.Status().Code)
+	assert.Equal(t, 1, len(check.Events()))
+	_, decided := attributeMap(check)[DecisionKey]
+	assert.False(t, decided)
+}
+
+func TestTraceRepositoryFailure(t *testing.T) {
+	// given
+	recorder, provider := newRecordingProvider()
+	roleRepo := NewRoleRepository(nogo.NewMapBackedRoleRepository(), provider)
+
+	// when
+	err := roleRepo.UpdateRole(nogo.NewRole("missing", read))
+
+	// then
+	assert.NotNil(t, err)
+	update := findSpan(recorder.Ended(), "nogo.RoleRepository.UpdateRole")
+	assert.NotNil(t, update)
+	assert.Equal(t, codes.Error, update.Status().Code)
+	assert.Equal(t, "missing", attributeMap(update)[RoleNameKey])
+}
+
+func newRecordingProvider() (*tracetest.SpanRecorder, *sdktrace.TracerProvider) {
+	recorder := tracetest.NewSpanRecorder()
+	return recorder, sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+}
+
+func findSpan(spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	for _, span := range spans {
+		if span.Name() == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func attributeMap(span sdktrace.ReadOnlySpan) map[interface{}]interface{} {
+	attributes := make(map[interface{}]interface{})
+	for _, attribute := range span.Attributes() {
+		attributes[attribute.Key] = attribute.Value.AsInterface()
+	}
+	return attributes
+}
+
+type testPrincipal struct {
+	id        string
+	sid       string
+	roleNames []string
+}
+
+func (this *testPrincipal) GetId() string {
+	return this.id
+}
+
+func (this *testPrincipal) GetSid() string {
+	return this.sid
+}
+
+func (this *testPrincipal) GetRoleNames() []string {
+	return this.roleNames
+}
+
+// audit sink that keeps the events it records
+type recordingAuditSink struct {
+	events []*nogo.AuditEvent
+}
+
+func (this *recordingAuditSink) WriteAuditEvent(ctx context.Context, event *nogo.AuditEvent) error {
+	this.events = append(this.events, event)
+	return nil
+}
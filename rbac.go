@@ -14,8 +14,35 @@
 
 package nogo
 
-// Represents a specific capability defined by the system or mode of resource access that can be granted to Principals by way of Role assignment or resource ACLs.
-type Permission int
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+)
+
+// Represents a specific capability defined by the system or mode of resource access that can be granted to Principals by way of Role assignment or resource ACLs. Each permission is a single bit, and a set of permissions is represented by combining them into a mask, allowing up to 64 distinct permissions.
+type Permission uint64
+
+// The number of distinct permissions that can be represented.
+const MaxPermissions = 64
+
+// Returns the mask as a signed 64 bit integer so that all 64 bits can be stored in a bigint column.
+func (this Permission) Value() (driver.Value, error) {
+	return int64(this), nil
+}
+
+// Scans a mask stored in an integer column.
+func (this *Permission) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case int64:
+		*this = Permission(value)
+	case nil:
+		*this = EmptyPermissionMask
+	default:
+		return errors.New(fmt.Sprintf("Could not scan permission mask of type %T", src))
+	}
+	return nil
+}
 
 // Represents a user of the system. A principal simply has an ID and 0 or more roles. The principal's authorization is defined by the set of roles associated to the principal.
 type Principal interface {
@@ -37,6 +64,13 @@ type Role interface {
 	HasPermission(permission Permission) (bool, error)
 }
 
+// A role that inherits the permissions of one or more parent roles. Roles implementing this interface are evaluated along with the transitive closure of their parents when verifying role access.
+type HierarchicalRole interface {
+	Role
+	// Returns the names of the roles this role directly inherits from. May return an empty value.
+	GetParentRoleNames() []string
+}
+
 // Creates a new regular role (non admin) with a specific set of permissions
 func NewRole(name string, mask Permission) Role {
 	return &defaultRole{RoleName: name, PermissionMask: mask, Admin: false}
@@ -47,10 +81,16 @@ func NewAdminRole(name string, mask Permission) Role {
 	return &defaultRole{RoleName: name, PermissionMask: mask, Admin: true}
 }
 
+// Creates a new regular role (non admin) with a specific set of permissions that also inherits the permissions of the parent roles.
+func NewHierarchicalRole(name string, mask Permission, parentRoleNames ...string) HierarchicalRole {
+	return &defaultRole{RoleName: name, PermissionMask: mask, Admin: false, ParentRoleNames: parentRoleNames}
+}
+
 type defaultRole struct {
-	RoleName       string     `db:"role_name"`
-	PermissionMask Permission `db:"permission_mask"`
-	Admin          bool       `db:"is_admin"`
+	RoleName        string     `db:"role_name"`
+	PermissionMask  Permission `db:"permission_mask"`
+	Admin           bool       `db:"is_admin"`
+	ParentRoleNames []string   `db:"-"`
 }
 
 func (this *defaultRole) GetName() string {
@@ -65,3 +105,41 @@ func (this *defaultRole) HasPermission(permission Permission) (bool, error) {
 	val := (this.PermissionMask&permission != 0)
 	return val, nil
 }
+
+func (this *defaultRole) GetParentRoleNames() []string {
+	return this.ParentRoleNames
+}
+
+// returns the direct parent role names of the role, or nil if the role is not hierarchical.
+func parentRoleNames(role Role) []string {
+	if hierarchicalRole, ok := role.(HierarchicalRole); ok {
+		return hierarchicalRole.GetParentRoleNames()
+	}
+	return nil
+}
+
+// Ensures the role's parents exist in the set of stored roles, keyed by name, and that the role does not inherit from itself directly or through its ancestors.
+func validateRoleParents(role Role, roles map[string]Role) error {
+	pending := parentRoleNames(role)
+	visited := make(map[string]bool)
+	for _, parentName := range pending {
+		if _, ok := roles[parentName]; !ok {
+			return errors.New(fmt.Sprintf("Parent role %v does not exist.", parentName))
+		}
+	}
+	for len(pending) > 0 {
+		roleName := pending[0]
+		pending = pending[1:]
+		if roleName == role.GetName() {
+			return errors.New(fmt.Sprintf("Role %v can not inherit from itself.", role.GetName()))
+		}
+		if visited[roleName] {
+			continue
+		}
+		visited[roleName] = true
+		if parent, ok := roles[roleName]; ok {
+			pending = append(pending, parentRoleNames(parent)...)
+		}
+	}
+	return nil
+}
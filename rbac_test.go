@@ -42,3 +42,19 @@ func TestAdminAuthorization(t *testing.T) {
 	assert.False(t, ret)
 	assert.Nil(t, err)
 }
+
+func TestPermissionValue(t *testing.T) {
+	high := Permission(1 << 63)
+
+	value, err := high.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1<<63), value)
+
+	var scanned Permission
+	err = scanned.Scan(value)
+	assert.Nil(t, err)
+	assert.Equal(t, high, scanned)
+
+	err = scanned.Scan("invalid")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,40 @@
+// Copyright 2014 Daniel Akiva
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import "time"
+
+// A repository for managing the assignment of roles to principals, keyed by principal security identifier.
+type RoleMembershipRepository interface {
+	// Assigns the role to the principal. Returns an error if the membership could not be added, or if the principal is already a member of the role.
+	AddMember(roleName string, principalSid string) error
+	// Removes the role from the principal. Returns an error if the membership could not be removed, or if the principal is not a member of the role.
+	RemoveMember(roleName string, principalSid string) error
+	// Returns the names of all roles assigned to the principal, excluding time bound memberships that do not apply at the current time. May return an empty value. Returns an error if the roles could not be retrieved.
+	FindRolesForSid(principalSid string) ([]string, error)
+	// Returns the sids of all principals assigned the role, including time bound memberships that have not yet started or have expired. May return an empty value. Returns an error if the members could not be retrieved.
+	FindMembersOfRole(roleName string) ([]string, error)
+}
+
+// Optionally implemented by a RoleMembershipRepository that supports temporary role assignments, such as granting a role to an on-call engineer or auditor for a limited time. The access control strategy resolves roles through FindRolesForSidAt at the time of its clock rather than FindRolesForSid.
+type TimeBoundRoleMembershipRepository interface {
+	RoleMembershipRepository
+	// Assigns the role to the principal from notBefore (inclusive) until notAfter (exclusive). A zero time leaves that end of the window open. Returns an error if the membership could not be added, or if the principal is already a member of the role.
+	AddMemberBetween(roleName string, principalSid string, notBefore time.Time, notAfter time.Time) error
+	// Returns the names of all roles assigned to the principal that apply at the given time. May return an empty value. Returns an error if the roles could not be retrieved.
+	FindRolesForSidAt(principalSid string, at time.Time) ([]string, error)
+	// Removes the memberships that expired at or before the given time, returning the number of memberships removed.
+	PurgeExpiredMembers(before time.Time) (int, error)
+}
@@ -14,9 +14,14 @@
 
 package nogo
 
+import (
+	"context"
+	"time"
+)
+
 // A repository for managing secure resource acls. The use of resource Id here refers to an external identifier for the resource.
 type SecureResourceRepository interface {
-	// Returns the secure resource for the given resource id. Returns an error if the object id is invalid, or if the secure resource could not be retrieved.
+	// Returns the secure resource for the given resource id. Returns an error if the object id is invalid, or if the secure resource could not be retrieved. Errors reporting that the resource does not exist should wrap ErrResourceNotFound.
 	FindResource(nativeResourceId string) (SecureResource, error)
 	// Creates a new secure resource for the given resource id and, optionally a parent id. Returns an error if the resourceId is invalid, or if the resource already contains an ACL.
 	CreateResource(resource SecureResource) error
@@ -25,3 +30,101 @@ type SecureResourceRepository interface {
 	// Deletes an ACL for the given resource. Returns an error if the resourceId is invalid, or if the resource does not contain an ACL.
 	DeleteResource(nativeResourceId string) error
 }
+
+// Optionally implemented by a SecureResourceRepository that can evaluate a resource's ACL, along with the ACLs it inherits, in a single operation. The default access control strategy prefers it over loading the resource and walking its ancestors when verifying access by resource id.
+type ACLEvaluator interface {
+	// Evaluates the entries for the sid, its group sids and the WorldSid matching the permission, starting with the resource and walking up through the ancestors it inherits from. The nearest resource with a matching entry decides, and on any one resource deny entries override allow entries. Time bound entries that do not apply at the given time are ignored. Returns an error matching ErrResourceNotFound if the resource does not exist.
+	EvaluateACL(ctx context.Context, sid string, groupSids []string, permission Permission, nativeResourceId string, at time.Time) (*ACLEvaluation, error)
+}
+
+// The outcome of evaluating a resource's ACL along with the ACLs it inherits.
+type ACLEvaluation struct {
+	// The owner sid of the evaluated resource.
+	OwnerSid string
+	// True if an entry matched the permission.
+	Matched bool
+	// True if the matching entry is a deny entry.
+	Deny bool
+	// The sid of the matching entry, either the evaluated sid, one of its group sids or the WorldSid. When several entries match, deny entries are preferred, followed by the sid, its group sids and the WorldSid in that order.
+	Sid string
+	// The native id of the resource holding the matching entry.
+	DecidingResourceId string
+	// The native ids of the resources whose ACLs were evaluated, in order from the resource to its farthest ancestor.
+	ResourcesConsulted []string
+}
+
+// Optionally implemented by a SecureResourceRepository that can list the resources a principal has access to.
+type AccessibleResourceFinder interface {
+	// Returns, ordered by native id, the native ids of the resources the sid owns, or on which an entry for the sid, one of its group sids or the WorldSid grants the permission, either directly or inherited from an ancestor. Access is evaluated as with VerifyResourceAccess at the given time, excluding admin access. Only ids greater than afterResourceId are returned, up to limit ids (all ids if limit is zero or less). To page through results, pass the last id returned as the next afterResourceId, starting with "".
+	FindAccessibleResourceIds(ctx context.Context, sid string, groupSids []string, permission Permission, afterResourceId string, limit int, at time.Time) ([]string, error)
+}
+
+// Optionally implemented by a SecureResourceRepository that stores time bound ACEs.
+type ExpiredACEPurger interface {
+	// Removes the time bound entries that expired at or before the given time from every resource, returning the number of entries removed.
+	PurgeExpiredACEs(ctx context.Context, before time.Time) (int, error)
+}
+
+// The optional interfaces of a SecureResourceRepository forwarded by a decorator. Nil fields are not implemented.
+type SecureResourceRepositoryExtensions struct {
+	ACLEvaluator             ACLEvaluator
+	AccessibleResourceFinder AccessibleResourceFinder
+	ExpiredACEPurger         ExpiredACEPurger
+}
+
+// Returns the optional interfaces implemented by the repository, for decorators to wrap before passing them to ExtendSecureResourceRepository.
+func FindSecureResourceRepositoryExtensions(repo SecureResourceRepository) SecureResourceRepositoryExtensions {
+	extensions := SecureResourceRepositoryExtensions{}
+	extensions.ACLEvaluator, _ = repo.(ACLEvaluator)
+	extensions.AccessibleResourceFinder, _ = repo.(AccessibleResourceFinder)
+	extensions.ExpiredACEPurger, _ = repo.(ExpiredACEPurger)
+	return extensions
+}
+
+// Returns a repository that delegates to repo and implements exactly the optional interfaces set in extensions, so that decorators keep the optional interfaces of the repository they wrap and strategies detecting them keep working.
+func ExtendSecureResourceRepository(repo ContextSecureResourceRepository, extensions SecureResourceRepositoryExtensions) ContextSecureResourceRepository {
+	evaluator, finder, purger := extensions.ACLEvaluator, extensions.AccessibleResourceFinder, extensions.ExpiredACEPurger
+	switch {
+	case evaluator != nil && finder != nil && purger != nil:
+		return &struct {
+			ContextSecureResourceRepository
+			ACLEvaluator
+			AccessibleResourceFinder
+			ExpiredACEPurger
+		}{repo, evaluator, finder, purger}
+	case evaluator != nil && finder != nil:
+		return &struct {
+			ContextSecureResourceRepository
+			ACLEvaluator
+			AccessibleResourceFinder
+		}{repo, evaluator, finder}
+	case evaluator != nil && purger != nil:
+		return &struct {
+			ContextSecureResourceRepository
+			ACLEvaluator
+			ExpiredACEPurger
+		}{repo, evaluator, purger}
+	case finder != nil && purger != nil:
+		return &struct {
+			ContextSecureResourceRepository
+			AccessibleResourceFinder
+			ExpiredACEPurger
+		}{repo, finder, purger}
+	case evaluator != nil:
+		return &struct {
+			ContextSecureResourceRepository
+			ACLEvaluator
+		}{repo, evaluator}
+	case finder != nil:
+		return &struct {
+			ContextSecureResourceRepository
+			AccessibleResourceFinder
+		}{repo, finder}
+	case purger != nil:
+		return &struct {
+			ContextSecureResourceRepository
+			ExpiredACEPurger
+		}{repo, purger}
+	}
+	return repo
+}